@@ -0,0 +1,484 @@
+// Package battery monitors the 12 V battery from BatteryStatus: the voltage dip while cranking, the resting
+// voltage and current draw while the vehicle is off, and the charging voltage while it runs.
+//
+// The crank dips give the state of health, ChargeLevel or the resting voltage the state of charge, and the
+// current or the fall of the charge while off the parasitic drain. Together they predict the time left until
+// the battery can no longer start the engine, a no-start risk and a recommended action:
+//
+//	m := battery.NewMonitor(battery.Config{Capacity: 70})
+//	for smp := range samples {
+//		for _, e := range m.Update(smp) {
+//			...
+//		}
+//	}
+//	h := m.Health()
+//
+// BatteryStatus carries whole volts and amperes, so the thresholds are coarse: a drain shows in the current
+// only from 1 A, and smaller drains show in the fall of the charge over hours. Whole volts span most of the
+// range between a full and an empty battery, so the state of charge comes from ChargeLevel only, and the state
+// of health and the crank warning are left out, unless the provider reports finer voltages, see
+// Config.VoltageScale.
+package battery
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+	"github.com/calvernaz/w3c-vehicle-data/types/vehicle-power"
+)
+
+// Kind of an event.
+type Kind int
+
+const (
+	// A crank completed; Voltage is the lowest voltage while cranking
+	Crank Kind = iota
+	// The resting voltage was measured after the vehicle was off for RestDelay
+	Rest
+	// The charging voltage while running left the normal range
+	ChargingFault
+	// A parasitic drain was detected while off
+	Drain
+)
+
+var kinds = [...]string{"crank", "rest", "charging fault", "drain"}
+
+func (k Kind) String() string {
+	if k >= 0 && int(k) < len(kinds) {
+		return kinds[k]
+	}
+	return "Kind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Risk of the battery failing to start the engine.
+type Risk int
+
+const (
+	Low Risk = iota
+	Medium
+	High
+)
+
+var risks = [...]string{"low", "medium", "high"}
+
+func (r Risk) String() string {
+	if r >= 0 && int(r) < len(risks) {
+		return risks[r]
+	}
+	return "Risk(" + strconv.Itoa(int(r)) + ")"
+}
+
+// Recommended actions.
+const (
+	ActionNone     = ""
+	ActionReplace  = "replace the battery"
+	ActionCharging = "check the alternator and charging system"
+	ActionDrain    = "find the parasitic drain, e.g. a load left on"
+	ActionCharge   = "charge the battery"
+	ActionTest     = "test the battery"
+)
+
+// Config configures a monitor. Zero values select the defaults noted on each field.
+type Config struct {
+	// Zone of the 12 V battery in BatteryStatus; default none
+	Zone string
+	// Volts per unit of BatteryStatus.Voltage; default 1, the whole volts of the specification. A scale of at
+	// most 0.1, e.g. 0.01 for a provider reporting centivolts, enables the state of charge from the resting
+	// voltage, the state of health from the crank dips and the crank warning.
+	VoltageScale float64
+	// Battery capacity (Unit: ampere hours); default 60
+	Capacity float64
+	// Time after ignition on over which the crank dip is measured; default 3s
+	CrankWindow time.Duration
+	// Crank voltages giving a state of health of 100% and 0% (Unit: volts); default 10.5 and 7.5
+	CrankGood, CrankBad float64
+	// Crank voltage below which the battery is weak (Unit: volts); default 9.6
+	CrankWarn float64
+	// Weight of a new crank in the state of health; default 0.3
+	Smoothing float64
+	// Time off before the voltage is taken as resting; default 2h
+	RestDelay time.Duration
+	// Time off before the current draw is taken as parasitic; default 30 minutes
+	SleepDelay time.Duration
+	// Current draw while asleep that is a drain (Unit: amperes); default 1
+	DrainCurrent float64
+	// Time the current draw must stay at or above DrainCurrent to be a drain; default 5 minutes
+	DrainCurrentTime time.Duration
+	// Fall of the charge while off that is a drain (Unit: percentage per day); default 5
+	DrainRate float64
+	// Time off the fall of the charge is measured over before it is judged; default 6h
+	DrainWindow time.Duration
+	// Normal charging voltage range while running (Unit: volts); default 13 and 15
+	ChargeLow, ChargeHigh float64
+	// Time after start before the charging voltage is judged, and time it must be out of range; default 30s
+	ChargeDelay time.Duration
+	// Charge below which the engine may not start (Unit: percentage); default 40
+	MinStartCharge float64
+}
+
+func (c *Config) defaults() {
+	if c.VoltageScale == 0 {
+		c.VoltageScale = 1
+	}
+	if c.Capacity == 0 {
+		c.Capacity = 60
+	}
+	if c.CrankWindow == 0 {
+		c.CrankWindow = 3 * time.Second
+	}
+	if c.CrankGood == 0 {
+		c.CrankGood = 10.5
+	}
+	if c.CrankBad == 0 {
+		c.CrankBad = 7.5
+	}
+	if c.CrankWarn == 0 {
+		c.CrankWarn = 9.6
+	}
+	if c.Smoothing == 0 {
+		c.Smoothing = 0.3
+	}
+	if c.RestDelay == 0 {
+		c.RestDelay = 2 * time.Hour
+	}
+	if c.SleepDelay == 0 {
+		c.SleepDelay = 30 * time.Minute
+	}
+	if c.DrainCurrent == 0 {
+		c.DrainCurrent = 1
+	}
+	if c.DrainCurrentTime == 0 {
+		c.DrainCurrentTime = 5 * time.Minute
+	}
+	if c.DrainRate == 0 {
+		c.DrainRate = 5
+	}
+	if c.DrainWindow == 0 {
+		c.DrainWindow = 6 * time.Hour
+	}
+	if c.ChargeLow == 0 {
+		c.ChargeLow = 13
+	}
+	if c.ChargeHigh == 0 {
+		c.ChargeHigh = 15
+	}
+	if c.ChargeDelay == 0 {
+		c.ChargeDelay = 30 * time.Second
+	}
+	if c.MinStartCharge == 0 {
+		c.MinStartCharge = 40
+	}
+}
+
+// An Event is a measurement or finding of the monitor.
+type Event struct {
+	Kind Kind
+	Time time.Time
+	// Voltage measured (Unit: volts)
+	Voltage float64
+	// Current draw of a drain (Unit: amperes), or charge fall of a drain (Unit: percentage per day)
+	Current, Rate float64
+}
+
+// Health is the battery state.
+type Health struct {
+	// State of health from the crank dips (Unit: percentage), negative before the first crank or without fine
+	// voltages
+	StateOfHealth float64
+	// State of charge (Unit: percentage), as reported or from a fine resting voltage; negative if unknown
+	Charge float64
+	// Latest crank dip, resting and charging voltages (Unit: volts), zero if not measured
+	CrankVoltage, RestingVoltage, ChargingVoltage float64
+	ChargingFault                                 bool
+	// Parasitic drain while off, by the lowest current over DrainCurrentTime (Unit: amperes) or charge fall (Unit: percentage per day)
+	Drain                   bool
+	DrainCurrent, DrainRate float64
+	// Time left while off until the charge falls below MinStartCharge, zero if unknown or already below
+	TimeToNoStart time.Duration
+	Risk          Risk
+	Action        string
+}
+
+// A reading is a current reading.
+type reading struct {
+	t time.Time
+	a float64
+}
+
+// A Monitor follows the 12 V battery. It is not safe for concurrent use.
+type Monitor struct {
+	cfg Config
+	// voltages are fine enough for the charge and health estimates
+	fine bool
+
+	power vehicle_power.VehiclePowerMode
+	// last battery reading
+	voltage float64
+	current float64
+	level   float64
+	haveBat bool
+
+	// crank window: start, lowest voltage
+	crankStart time.Time
+	crankMin   float64
+	lastOn     time.Time
+
+	// off period: start, resting measured, charge and time at the start of the drain window
+	offStart    time.Time
+	rested      bool
+	drainLevel  float64
+	drainTime   time.Time
+	drainLogged bool
+	// current readings while asleep over the last DrainCurrentTime, oldest first
+	currents []reading
+
+	// running period: start, time the charging voltage left its range, fault reported
+	runStart    time.Time
+	chargeOut   time.Time
+	chargeFault bool
+
+	h Health
+}
+
+// fineVoltage is the coarsest voltage resolution the estimates from voltages are made with (Unit: volts).
+const fineVoltage = 0.1
+
+// NewMonitor returns a monitor with nothing measured yet.
+func NewMonitor(cfg Config) *Monitor {
+	cfg.defaults()
+	return &Monitor{cfg: cfg, fine: cfg.VoltageScale <= fineVoltage, h: Health{StateOfHealth: -1, Charge: -1}}
+}
+
+// Update processes a sample and returns the events it completes.
+func (m *Monitor) Update(s vehicledata.Sample) []Event {
+	t := s.Time
+	var out []Event
+	switch v := s.Value.(type) {
+	case vehicledata.VehiclePowerModeType:
+		m.powerSample(t, v.Value)
+	case vehicledata.IgnitionTime:
+		if !v.IgnitionOnTime.IsZero() && v.IgnitionOnTime.After(m.lastOn) {
+			m.startCrank(v.IgnitionOnTime)
+		}
+	case vehicledata.BatteryStatus:
+		if v.Zone.String() != m.cfg.Zone {
+			return nil
+		}
+		m.voltage, m.current, m.haveBat = float64(v.Voltage)*m.cfg.VoltageScale, float64(v.Current), true
+		m.level = -1
+		if v.ChargeLevel > 0 {
+			m.level = float64(v.ChargeLevel)
+		}
+		out = m.batterySample(t)
+	}
+	return out
+}
+
+func (m *Monitor) powerSample(t time.Time, p vehicle_power.VehiclePowerMode) {
+	prev := m.power
+	m.power = p
+	switch {
+	case p == vehicle_power.Running && prev != vehicle_power.Running:
+		if m.crankStart.IsZero() && t.Sub(m.lastOn) > m.cfg.CrankWindow {
+			m.startCrank(t)
+		}
+		m.runStart, m.chargeOut, m.chargeFault = t, time.Time{}, false
+		m.h.ChargingFault = false
+		m.offStart = time.Time{}
+	case p == vehicle_power.Off && prev != vehicle_power.Off:
+		m.offStart, m.rested, m.drainLogged = t, false, false
+		m.drainTime, m.currents = time.Time{}, nil
+		m.runStart = time.Time{}
+		m.h.Drain, m.h.DrainCurrent, m.h.DrainRate = false, 0, 0
+	}
+}
+
+func (m *Monitor) startCrank(t time.Time) {
+	m.lastOn, m.crankStart, m.crankMin = t, t, math.Inf(1)
+	if m.haveBat {
+		m.crankMin = m.voltage
+	}
+}
+
+func (m *Monitor) batterySample(t time.Time) []Event {
+	var out []Event
+	if !m.crankStart.IsZero() {
+		if t.Sub(m.crankStart) <= m.cfg.CrankWindow {
+			m.crankMin = math.Min(m.crankMin, m.voltage)
+		} else {
+			out = append(out, m.endCrank(t)...)
+		}
+	}
+	if level := m.charge(); level >= 0 {
+		m.h.Charge = level
+	}
+	switch {
+	case !m.offStart.IsZero():
+		out = append(out, m.offSample(t)...)
+	case !m.runStart.IsZero() && t.Sub(m.runStart) >= m.cfg.ChargeDelay:
+		out = append(out, m.runSample(t)...)
+	}
+	return out
+}
+
+// endCrank closes the crank window and updates the state of health.
+func (m *Monitor) endCrank(t time.Time) []Event {
+	v := m.crankMin
+	m.crankStart = time.Time{}
+	if math.IsInf(v, 1) || v == 0 {
+		return nil
+	}
+	m.h.CrankVoltage = v
+	if !m.fine {
+		return []Event{{Kind: Crank, Time: t, Voltage: v}}
+	}
+	soh := 100 * (v - m.cfg.CrankBad) / (m.cfg.CrankGood - m.cfg.CrankBad)
+	soh = math.Max(0, math.Min(100, soh))
+	if m.h.StateOfHealth < 0 {
+		m.h.StateOfHealth = soh
+	} else {
+		m.h.StateOfHealth += m.cfg.Smoothing * (soh - m.h.StateOfHealth)
+	}
+	return []Event{{Kind: Crank, Time: t, Voltage: v}}
+}
+
+// charge returns the reported charge, or the charge from a fine resting voltage while resting, or -1.
+func (m *Monitor) charge() float64 {
+	if m.level >= 0 {
+		return m.level
+	}
+	if m.rested && m.fine {
+		return restingCharge(m.voltage)
+	}
+	return -1
+}
+
+// restingCharge maps the resting voltage of a lead-acid battery to its charge: 12.6 V full, 11.8 V empty.
+func restingCharge(v float64) float64 {
+	return math.Max(0, math.Min(100, (v-11.8)/0.8*100))
+}
+
+func (m *Monitor) offSample(t time.Time) []Event {
+	var out []Event
+	off := t.Sub(m.offStart)
+	if off >= m.cfg.RestDelay && !m.rested {
+		m.rested = true
+		m.h.RestingVoltage = m.voltage
+		if level := m.charge(); level >= 0 {
+			m.h.Charge = level
+		}
+		out = append(out, Event{Kind: Rest, Time: t, Voltage: m.voltage})
+	}
+	if off < m.cfg.SleepDelay {
+		return out
+	}
+	m.h.DrainCurrent = m.sleepCurrent(t)
+	if m.h.Charge >= 0 {
+		if m.drainTime.IsZero() {
+			m.drainLevel, m.drainTime = m.h.Charge, t
+		} else if span := t.Sub(m.drainTime); span >= m.cfg.DrainWindow {
+			m.h.DrainRate = math.Max(0, (m.drainLevel-m.h.Charge)/span.Hours()*24)
+		}
+	}
+	m.h.Drain = m.h.DrainCurrent >= m.cfg.DrainCurrent || m.h.DrainRate >= m.cfg.DrainRate
+	if m.h.Drain && !m.drainLogged {
+		m.drainLogged = true
+		out = append(out, Event{Kind: Drain, Time: t, Voltage: m.voltage, Current: m.h.DrainCurrent, Rate: m.h.DrainRate})
+	}
+	return out
+}
+
+// sleepCurrent records the current while asleep and returns the lowest current over the last DrainCurrentTime
+// if it is a drain, or 0, so a drain that ended, e.g. a courtesy light that timed out, clears.
+func (m *Monitor) sleepCurrent(t time.Time) float64 {
+	m.currents = append(m.currents, reading{t, m.current})
+	// keep the last reading at or before the start of the window: it holds until the next one
+	from := t.Add(-m.cfg.DrainCurrentTime)
+	i := 0
+	for i+1 < len(m.currents) && !m.currents[i+1].t.After(from) {
+		i++
+	}
+	m.currents = m.currents[i:]
+	if m.currents[0].t.After(from) {
+		return 0
+	}
+	low := math.Inf(1)
+	for _, r := range m.currents {
+		low = math.Min(low, r.a)
+	}
+	if low < m.cfg.DrainCurrent {
+		return 0
+	}
+	return low
+}
+
+func (m *Monitor) runSample(t time.Time) []Event {
+	m.h.ChargingVoltage = m.voltage
+	if m.voltage >= m.cfg.ChargeLow && m.voltage <= m.cfg.ChargeHigh {
+		m.chargeOut = time.Time{}
+		return nil
+	}
+	if m.chargeOut.IsZero() {
+		m.chargeOut = t
+	}
+	if m.chargeFault || t.Sub(m.chargeOut) < m.cfg.ChargeDelay {
+		return nil
+	}
+	m.chargeFault, m.h.ChargingFault = true, true
+	return []Event{{Kind: ChargingFault, Time: t, Voltage: m.voltage}}
+}
+
+// Health returns the battery state with the no-start prediction.
+func (m *Monitor) Health() Health {
+	h := m.h
+	h.TimeToNoStart = m.timeToNoStart()
+	switch {
+	case h.Charge >= 0 && h.Charge <= m.cfg.MinStartCharge,
+		h.StateOfHealth >= 0 && h.StateOfHealth < 40,
+		h.TimeToNoStart > 0 && h.TimeToNoStart < 24*time.Hour:
+		h.Risk = High
+	case h.Charge >= 0 && h.Charge < 60,
+		m.fine && h.CrankVoltage > 0 && h.CrankVoltage < m.cfg.CrankWarn,
+		h.StateOfHealth >= 0 && h.StateOfHealth < 70,
+		h.TimeToNoStart > 0 && h.TimeToNoStart < 7*24*time.Hour,
+		h.ChargingFault, h.Drain:
+		h.Risk = Medium
+	}
+	switch {
+	case h.StateOfHealth >= 0 && h.StateOfHealth < 40:
+		h.Action = ActionReplace
+	case h.ChargingFault:
+		h.Action = ActionCharging
+	case h.Drain:
+		h.Action = ActionDrain
+	case h.Charge >= 0 && h.Charge < 60:
+		h.Action = ActionCharge
+	case h.Risk > Low:
+		h.Action = ActionTest
+	}
+	return h
+}
+
+// timeToNoStart predicts the time until the charge falls below MinStartCharge from the drain, or returns 0.
+func (m *Monitor) timeToNoStart() time.Duration {
+	h := m.h
+	if h.Charge < 0 || m.offStart.IsZero() {
+		return 0
+	}
+	left := h.Charge - m.cfg.MinStartCharge
+	if left <= 0 {
+		return 0
+	}
+	var hours float64
+	switch {
+	case h.DrainCurrent > 0:
+		hours = left / 100 * m.cfg.Capacity / h.DrainCurrent
+	case h.DrainRate > 0:
+		hours = left / h.DrainRate * 24
+	default:
+		return 0
+	}
+	return time.Duration(hours * float64(time.Hour))
+}
@@ -0,0 +1,456 @@
+// Package brakes estimates brake pad wear and brake fluid service on vehicles without wear sensors.
+//
+// The kinetic energy removed while braking, from VehicleSpeed deltas and the vehicle mass of the VehicleType
+// profile less what coasting would remove, is split over the wheels by the brake balance. Each wheel wears in
+// proportion to its energy, faster while its modelled rotor temperature is above HotTemperature. The fluid
+// level falls as the pads wear and the caliper pistons extend, so FluidLevelLow and BrakesWorn agree. The
+// remaining distance to a pad service is forecast from the wear rate since the pads were fitted, and the fluid
+// service from its age.
+//
+// The wear is published as BrakeMaintenance samples, one per wheel, with Source set to Source:
+//
+//	m, err := brakes.Open("/var/lib/vehicle/brakes.json", brakes.Config{})
+//	...
+//	defer m.Close()
+//	for smp := range samples {
+//		for _, s := range m.Update(smp) {
+//			// s.Value is a vehicledata.BrakeMaintenance
+//		}
+//	}
+package brakes
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+	"github.com/calvernaz/w3c-vehicle-data/internal/statefile"
+	"github.com/calvernaz/w3c-vehicle-data/types/vehicle-type"
+	"github.com/calvernaz/w3c-vehicle-data/types/zone"
+)
+
+// Source marks the samples produced by the model.
+const Source = "brakes"
+
+// Wheels are the zones of the modelled wheels.
+var Wheels = []string{"front-left", "front-right", "rear-left", "rear-right"}
+
+// A Profile describes the braking of a vehicle type.
+type Profile struct {
+	// Vehicle mass (Unit: kilograms)
+	Mass float64
+	// Share of the braking energy taken by the front axle
+	FrontShare float64
+	// Braking energy that wears a pad out completely (Unit: joules)
+	PadEnergy float64
+}
+
+// DefaultProfile returns the profile of a vehicle type. Pads are sized with the vehicle, so PadEnergy scales
+// with the mass.
+func DefaultProfile(t vehicle_type.VehicleType) Profile {
+	p := Profile{Mass: 1550, FrontShare: 0.7}
+	switch t {
+	case vehicle_type.PassengerCarMini:
+		p.Mass = 1000
+	case vehicle_type.PassengerCarLight:
+		p.Mass = 1200
+	case vehicle_type.PassengerCarCompact:
+		p.Mass = 1350
+	case vehicle_type.PassengerCarHeavy:
+		p.Mass = 1900
+	case vehicle_type.SportUtilityVehicle:
+		p.Mass = 2100
+	case vehicle_type.PickupTruck:
+		p.Mass, p.FrontShare = 2300, 0.65
+	case vehicle_type.Van:
+		p.Mass, p.FrontShare = 2500, 0.65
+	}
+	p.PadEnergy = 5e9 * p.Mass / 1550
+	return p
+}
+
+// Config configures a model. Zero values select the defaults noted on each field.
+type Config struct {
+	// Vehicle type until an Identification sample arrives; default PassengerCarMedium
+	VehicleType vehicle_type.VehicleType
+	// Profiles by vehicle type, overriding DefaultProfile
+	Profiles map[vehicle_type.VehicleType]Profile
+	// Deceleration of a coasting vehicle, not taken by the brakes (Unit: meters per second squared); default 0.3
+	CoastDeceleration float64
+	// Deceleration counted as braking while BrakeOperation is not reported (Unit: meters per second squared);
+	// default 1
+	BrakeDeceleration float64
+	// Heat capacity of a rotor (Unit: joules per kelvin); default 4000
+	HeatCapacity float64
+	// Time constant of rotor cooling; default 2 minutes
+	CoolingTime time.Duration
+	// Rotor temperature above which wear doubles every HotDoubling degrees (Unit: celsius); default 250
+	HotTemperature float64
+	// default 100
+	HotDoubling float64
+	// PadWear at which BrakesWorn is set (Unit: percentage); default 80
+	WornAt float64
+	// Fluid level drop with fully worn pads (Unit: percentage points); default 50
+	FluidWearDrop float64
+	// Fluid level below which FluidLevelLow is set (Unit: percentage); default 65
+	FluidLowAt float64
+	// Brake fluid service interval; default 2 years
+	FluidServiceTime time.Duration
+	// Distance since the pads were fitted before a forecast is made (Unit: meters); default 100 km
+	ForecastDistance float64
+	// Speed samples further apart than this are a gap; default 2s
+	MaxGap time.Duration
+	// Minimum time between automatic saves of the state file; default 1 minute
+	SaveInterval time.Duration
+}
+
+func (c *Config) defaults() {
+	if c.VehicleType == 0 {
+		c.VehicleType = vehicle_type.PassengerCarMedium
+	}
+	if c.CoastDeceleration == 0 {
+		c.CoastDeceleration = 0.3
+	}
+	if c.BrakeDeceleration == 0 {
+		c.BrakeDeceleration = 1
+	}
+	if c.HeatCapacity == 0 {
+		c.HeatCapacity = 4000
+	}
+	if c.CoolingTime == 0 {
+		c.CoolingTime = 2 * time.Minute
+	}
+	if c.HotTemperature == 0 {
+		c.HotTemperature = 250
+	}
+	if c.HotDoubling == 0 {
+		c.HotDoubling = 100
+	}
+	if c.WornAt == 0 {
+		c.WornAt = 80
+	}
+	if c.FluidWearDrop == 0 {
+		c.FluidWearDrop = 50
+	}
+	if c.FluidLowAt == 0 {
+		c.FluidLowAt = 65
+	}
+	if c.FluidServiceTime == 0 {
+		c.FluidServiceTime = 2 * 365 * 24 * time.Hour
+	}
+	if c.ForecastDistance == 0 {
+		c.ForecastDistance = 100e3
+	}
+	if c.MaxGap == 0 {
+		c.MaxGap = 2 * time.Second
+	}
+	if c.SaveInterval == 0 {
+		c.SaveInterval = time.Minute
+	}
+}
+
+// A Wheel is the pad state of one wheel.
+type Wheel struct {
+	Zone string
+	// Pad wear (Unit: percentage, 0%: new, 100%: completely worn)
+	PadWear float64
+	// Wear added by the model and distance driven since the pads were fitted
+	Added    float64
+	Distance float64
+	// Braking energy since the pads were fitted (Unit: joules)
+	Energy float64
+	// Time the pads were fitted, zero if unknown
+	Since time.Time
+	// Modelled rotor temperature (Unit: celsius); not saved
+	Temperature float64 `json:"-"`
+	// Forecast distance until PadWear reaches WornAt (Unit: meters), negative while unknown; not saved
+	Remaining float64 `json:"-"`
+	Worn      bool    `json:"-"`
+}
+
+// Status is the brake state.
+type Status struct {
+	Wheels []Wheel
+	// Time of the last fluid service, zero if unknown
+	FluidSince time.Time
+	// Time the fluid service is due, zero if unknown
+	FluidDue time.Time
+	// Fluid level (Unit: percentage), as reported or estimated from the pad wear
+	FluidLevel    float64
+	FluidLevelLow bool
+}
+
+// state is the content of the state file.
+type state struct {
+	Wheels     []*Wheel
+	FluidSince time.Time
+}
+
+// A Model estimates brake wear. It is not safe for concurrent use.
+type Model struct {
+	cfg     Config
+	path    string
+	profile Profile
+	st      state
+	now     time.Time
+
+	speed     float64
+	speedTime time.Time
+	pedal     bool
+	havePedal bool
+	ambient   float64
+	// last BrakeMaintenance read from the vehicle by zone
+	reported map[string]vehicledata.BrakeMaintenance
+	// last published values by zone
+	emitted  map[string]vehicledata.BrakeMaintenance
+	lastSave time.Time
+	unsaved  bool
+	err      error
+}
+
+// New returns a model for new pads that is not backed by a state file.
+func New(cfg Config) *Model {
+	cfg.defaults()
+	m := &Model{cfg: cfg, ambient: 20, reported: map[string]vehicledata.BrakeMaintenance{},
+		emitted: map[string]vehicledata.BrakeMaintenance{}}
+	m.profile = m.profileOf(cfg.VehicleType)
+	for _, z := range Wheels {
+		m.st.Wheels = append(m.st.Wheels, &Wheel{Zone: z, Temperature: m.ambient})
+	}
+	return m
+}
+
+// Open returns a model that saves its state to the file at path, restoring it if the file exists.
+func Open(path string, cfg Config) (*Model, error) {
+	m := New(cfg)
+	m.path = path
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var st state
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, fmt.Errorf("brakes: state file %s: %v", path, err)
+	}
+	for _, w := range st.Wheels {
+		if mw := m.wheel(w.Zone); mw != nil {
+			*mw = *w
+			mw.Temperature = m.ambient
+		}
+	}
+	m.st.FluidSince = st.FluidSince
+	return m, nil
+}
+
+// Status returns the brake state with the pad forecasts.
+func (m *Model) Status() Status {
+	st := Status{FluidSince: m.st.FluidSince}
+	if !st.FluidSince.IsZero() {
+		st.FluidDue = st.FluidSince.Add(m.cfg.FluidServiceTime)
+	}
+	st.FluidLevel = m.fluidLevel()
+	st.FluidLevelLow = st.FluidLevel < m.cfg.FluidLowAt
+	for _, w := range m.st.Wheels {
+		c := *w
+		c.Worn = c.PadWear >= m.cfg.WornAt
+		c.Remaining = -1
+		switch {
+		case c.Worn:
+			c.Remaining = 0
+		case c.Distance >= m.cfg.ForecastDistance && c.Added > 0:
+			c.Remaining = (m.cfg.WornAt - c.PadWear) * c.Distance / c.Added
+		}
+		st.Wheels = append(st.Wheels, c)
+	}
+	return st
+}
+
+// ReplacePads records new pads at time t on the wheels in zone z, e.g. "front" for both front wheels.
+func (m *Model) ReplacePads(z zone.Zone, t time.Time) {
+	for _, w := range m.st.Wheels {
+		if zone.Parse(w.Zone).Match(z) {
+			*w = Wheel{Zone: w.Zone, Since: t, Temperature: w.Temperature}
+		}
+	}
+	m.emitted = map[string]vehicledata.BrakeMaintenance{}
+	m.unsaved = true
+}
+
+// ServiceFluid records a brake fluid service at time t.
+func (m *Model) ServiceFluid(t time.Time) {
+	m.st.FluidSince, m.unsaved = t, true
+}
+
+// Update processes a sample. It returns BrakeMaintenance samples for the wheels whose pad wear changed by a
+// whole percent or whose flags changed.
+func (m *Model) Update(s vehicledata.Sample) []vehicledata.Sample {
+	if s.Source == Source {
+		return nil
+	}
+	if s.Time.After(m.now) {
+		m.now = s.Time
+	}
+	switch v := s.Value.(type) {
+	case vehicledata.Identification:
+		if v.VehicleType != 0 {
+			m.profile = m.profileOf(v.VehicleType)
+		}
+	case vehicledata.BrakeOperation:
+		m.pedal, m.havePedal = v.BrakePedalDepressed, true
+	case vehicledata.Temperature:
+		m.ambient = v.ExteriorTemperature
+	case vehicledata.BrakeMaintenance:
+		m.calibrate(v)
+	case vehicledata.VehicleSpeed:
+		m.speedSample(s.Time, float64(v.Speed)/3600)
+	default:
+		return nil
+	}
+	if m.path != "" && m.unsaved && m.now.Sub(m.lastSave) >= m.cfg.SaveInterval {
+		m.err = m.Save()
+	}
+	return m.emit()
+}
+
+func (m *Model) profileOf(t vehicle_type.VehicleType) Profile {
+	if p, ok := m.cfg.Profiles[t]; ok {
+		return p
+	}
+	return DefaultProfile(t)
+}
+
+func (m *Model) wheel(z string) *Wheel {
+	for _, w := range m.st.Wheels {
+		if w.Zone == z {
+			return w
+		}
+	}
+	return nil
+}
+
+// calibrate takes the pad wear and fluid level reported by the vehicle for a wheel.
+func (m *Model) calibrate(v vehicledata.BrakeMaintenance) {
+	z := v.Zone.String()
+	m.reported[z] = v
+	// the vehicle reports whole percent: the modeled wear only disagrees with it outside that percent, and the
+	// wear rate learned over the distance driven is kept
+	if w := m.wheel(z); w != nil && v.PadWear > 0 && float64(v.PadWear) != math.Floor(w.PadWear) {
+		w.PadWear = float64(v.PadWear)
+		m.unsaved = true
+	}
+}
+
+// speedSample integrates the braking energy; v is in meters per second.
+func (m *Model) speedSample(t time.Time, v float64) {
+	prev, prevTime := m.speed, m.speedTime
+	m.speed, m.speedTime = v, t
+	dt := t.Sub(prevTime)
+	if prevTime.IsZero() || dt <= 0 || dt > m.cfg.MaxGap {
+		return
+	}
+	d := (prev + v) / 2 * dt.Seconds()
+	cool := math.Exp(-dt.Seconds() / m.cfg.CoolingTime.Seconds())
+	decel := (prev - v) / dt.Seconds()
+	braking := m.pedal
+	if !m.havePedal {
+		braking = decel >= m.cfg.BrakeDeceleration
+	}
+	var energy float64
+	if braking && v < prev {
+		energy = math.Max(0, m.profile.Mass*((prev*prev-v*v)/2-m.cfg.CoastDeceleration*d))
+	}
+	for _, w := range m.st.Wheels {
+		w.Distance += d
+		w.Temperature = m.ambient + (w.Temperature-m.ambient)*cool
+		if energy == 0 {
+			continue
+		}
+		share := m.profile.FrontShare
+		if zone.Parse(w.Zone).Match(zone.Parse("rear")) {
+			share = 1 - share
+		}
+		e := energy * share / 2
+		w.Temperature += e / m.cfg.HeatCapacity
+		wear := e / m.profile.PadEnergy * 100
+		if w.Temperature > m.cfg.HotTemperature {
+			wear *= math.Pow(2, (w.Temperature-m.cfg.HotTemperature)/m.cfg.HotDoubling)
+		}
+		w.Energy += e
+		w.Added += wear
+		w.PadWear = math.Min(100, w.PadWear+wear)
+	}
+	m.unsaved = true
+}
+
+// fluidLevel returns the lowest fluid level reported, or the level estimated from the mean pad wear.
+func (m *Model) fluidLevel() float64 {
+	level, reported := 100.0, false
+	for _, r := range m.reported {
+		if r.FluidLevel > 0 && (!reported || float64(r.FluidLevel) < level) {
+			level, reported = float64(r.FluidLevel), true
+		}
+	}
+	if reported {
+		return level
+	}
+	var wear float64
+	for _, w := range m.st.Wheels {
+		wear += w.PadWear
+	}
+	return 100 - wear/float64(len(m.st.Wheels))*m.cfg.FluidWearDrop/100
+}
+
+// emit returns the BrakeMaintenance samples of the wheels that changed since they were last published.
+func (m *Model) emit() []vehicledata.Sample {
+	level := m.fluidLevel()
+	low := byte(0)
+	if level < m.cfg.FluidLowAt {
+		low = 1
+	}
+	var out []vehicledata.Sample
+	for _, w := range m.st.Wheels {
+		v := vehicledata.BrakeMaintenance{
+			FluidLevel:    byte(math.Round(level)),
+			FluidLevelLow: low,
+			PadWear:       byte(math.Floor(w.PadWear)),
+			BrakesWorn:    w.PadWear >= m.cfg.WornAt,
+			Zone:          zone.Parse(w.Zone),
+		}
+		if e, ok := m.emitted[w.Zone]; ok && e.FluidLevel == v.FluidLevel && e.FluidLevelLow == v.FluidLevelLow &&
+			e.PadWear == v.PadWear && e.BrakesWorn == v.BrakesWorn {
+			continue
+		}
+		m.emitted[w.Zone] = v
+		out = append(out, vehicledata.Sample{Time: m.now, Value: v, Source: Source})
+	}
+	return out
+}
+
+// Err returns the error of the last automatic save, if any.
+func (m *Model) Err() error {
+	return m.err
+}
+
+// Save writes the state to the state file. The file is replaced atomically, so a power loss leaves either the
+// old or the new state.
+func (m *Model) Save() error {
+	if m.path == "" {
+		return nil
+	}
+	if err := statefile.Save(m.path, m.st); err != nil {
+		return err
+	}
+	m.lastSave, m.unsaved = m.now, false
+	return nil
+}
+
+// Close saves the state.
+func (m *Model) Close() error {
+	return m.Save()
+}
@@ -0,0 +1,185 @@
+package cantrace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An ASCReader reads Vector ASC trace files. CAN and CAN FD data frames and remote frames are returned; error
+// frames, statistics and other events are skipped.
+type ASCReader struct {
+	sc   *bufio.Scanner
+	line int
+	// measurement start from the "date" header
+	start time.Time
+	// base of identifiers and data bytes, 16 or 10
+	base int
+	// true for "timestamps relative"
+	relative bool
+	last     time.Duration
+}
+
+// NewASCReader returns a reader for an ASC trace.
+func NewASCReader(r io.Reader) *ASCReader {
+	return &ASCReader{sc: bufio.NewScanner(r), base: 16}
+}
+
+// ascDateLayouts are the forms of the "date" header written by the Vector tools. The header is lower cased
+// before parsing to match the am/pm marker.
+var ascDateLayouts = []string{
+	"Mon Jan 2 03:04:05.000 pm 2006",
+	"Mon Jan 2 15:04:05.000 2006",
+	"Mon Jan 2 03:04:05 pm 2006",
+	"Mon Jan 2 15:04:05 2006",
+}
+
+// Next returns the next frame.
+func (a *ASCReader) Next() (Frame, error) {
+	for a.sc.Scan() {
+		a.line++
+		fields := strings.Fields(a.sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToLower(fields[0]) {
+		case "date":
+			a.parseDate(fields[1:])
+			continue
+		case "base":
+			// base hex|dec timestamps absolute|relative
+			if len(fields) > 1 && fields[1] == "dec" {
+				a.base = 10
+			}
+			for i, f := range fields {
+				if f == "timestamps" && i+1 < len(fields) {
+					a.relative = fields[i+1] == "relative"
+				}
+			}
+			continue
+		}
+		sec, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil || len(fields) < 4 {
+			// header, comment, begin/end of trigger block
+			continue
+		}
+		t := time.Duration(sec * float64(time.Second))
+		if a.relative {
+			t += a.last
+		}
+		a.last = t
+		f, ok, err := a.parseFrame(fields[1:])
+		if err != nil {
+			return Frame{}, fmt.Errorf("cantrace: asc line %d: %v", a.line, err)
+		}
+		if ok {
+			f.Time = a.start.Add(t)
+			return f, nil
+		}
+	}
+	if err := a.sc.Err(); err != nil {
+		return Frame{}, err
+	}
+	return Frame{}, io.EOF
+}
+
+func (a *ASCReader) parseDate(f []string) {
+	s := strings.ToLower(strings.Join(f, " "))
+	for _, layout := range ascDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			a.start = t
+			return
+		}
+	}
+}
+
+// parseFrame parses the fields of an event line after the timestamp. It returns false for events that are
+// not frames.
+func (a *ASCReader) parseFrame(f []string) (Frame, bool, error) {
+	if strings.EqualFold(f[0], "CANFD") {
+		return a.parseFD(f[1:])
+	}
+	// <channel> <id>[x] Rx|Tx d <dlc> <data...> | r
+	if _, err := strconv.Atoi(f[0]); err != nil || len(f) < 4 {
+		return Frame{}, false, nil
+	}
+	if f[2] != "Rx" && f[2] != "Tx" {
+		return Frame{}, false, nil
+	}
+	fr := Frame{Channel: f[0]}
+	var err error
+	if fr.ID, fr.Extended, err = a.parseID(f[1]); err != nil {
+		return Frame{}, false, err
+	}
+	switch f[3] {
+	case "r":
+		fr.Remote = true
+		return fr, true, nil
+	case "d":
+	default:
+		return Frame{}, false, nil
+	}
+	if len(f) < 5 {
+		return Frame{}, false, fmt.Errorf("missing DLC")
+	}
+	dlc, err := strconv.ParseUint(f[4], 16, 8)
+	if err != nil || dlc > 8 {
+		return Frame{}, false, fmt.Errorf("invalid DLC %q", f[4])
+	}
+	fr.Data, err = a.parseData(f[5:], int(dlc))
+	return fr, err == nil, err
+}
+
+// parseFD parses a CAN FD line after the CANFD keyword:
+// <channel> Rx|Tx <id>[x] [<name>] <brs> <esi> <dlc> <length> <data...>
+func (a *ASCReader) parseFD(f []string) (Frame, bool, error) {
+	if len(f) < 7 || (f[1] != "Rx" && f[1] != "Tx") {
+		return Frame{}, false, nil
+	}
+	fr := Frame{Channel: f[0], FD: true}
+	var err error
+	if fr.ID, fr.Extended, err = a.parseID(f[2]); err != nil {
+		return Frame{}, false, err
+	}
+	rest := f[3:]
+	if _, err := strconv.Atoi(rest[0]); err != nil {
+		// symbolic message name
+		rest = rest[1:]
+	}
+	if len(rest) < 4 {
+		return Frame{}, false, fmt.Errorf("short CAN FD frame")
+	}
+	n, err := strconv.Atoi(rest[3])
+	if err != nil || n > 64 {
+		return Frame{}, false, fmt.Errorf("invalid data length %q", rest[3])
+	}
+	fr.Data, err = a.parseData(rest[4:], n)
+	return fr, err == nil, err
+}
+
+func (a *ASCReader) parseID(s string) (uint32, bool, error) {
+	ext := strings.HasSuffix(s, "x")
+	id, err := strconv.ParseUint(strings.TrimSuffix(s, "x"), a.base, 32)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid identifier %q", s)
+	}
+	return uint32(id), ext, nil
+}
+
+func (a *ASCReader) parseData(f []string, n int) ([]byte, error) {
+	if len(f) < n {
+		return nil, fmt.Errorf("expected %d data bytes", n)
+	}
+	data := make([]byte, n)
+	for i := range data {
+		b, err := strconv.ParseUint(f[i], a.base, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data byte %q", f[i])
+		}
+		data[i] = byte(b)
+	}
+	return data, nil
+}
@@ -0,0 +1,263 @@
+package cantrace
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// BLF object types handled by BLFReader.
+const (
+	blfCANMessage     = 1
+	blfLogContainer   = 10
+	blfCANMessage2    = 86
+	blfCANFDMessage   = 100
+	blfCANFDMessage64 = 101
+)
+
+const (
+	blfFileHeaderSize = 144
+	// signature, header size, header version, object size and object type
+	blfBaseHeaderSize = 16
+	// compression method, reserved, uncompressed size, reserved
+	blfContainerHeaderSize = 16
+	blfExtendedID          = 0x80000000
+)
+
+// ErrBLFFormat is returned for data that is not a BLF file.
+var ErrBLFFormat = errors.New("cantrace: not a BLF file")
+
+// A BLFReader reads Vector binary logging format (BLF) files. CAN and CAN FD messages are returned, including
+// those inside compressed log containers; other objects are skipped.
+type BLFReader struct {
+	r     *bufio.Reader
+	start time.Time
+	// uncompressed log container data not yet consumed
+	inner []byte
+}
+
+// NewBLFReader reads the BLF file header from r and returns a reader positioned at the first object.
+func NewBLFReader(r io.Reader) (*BLFReader, error) {
+	b := &BLFReader{r: bufio.NewReader(r)}
+	h := make([]byte, blfFileHeaderSize)
+	if _, err := io.ReadFull(b.r, h); err != nil || string(h[:4]) != "LOGG" {
+		return nil, ErrBLFFormat
+	}
+	size := binary.LittleEndian.Uint32(h[4:])
+	if size < blfFileHeaderSize {
+		return nil, ErrBLFFormat
+	}
+	if _, err := b.r.Discard(int(size - blfFileHeaderSize)); err != nil {
+		return nil, ErrBLFFormat
+	}
+	b.start = systemTime(h[40:56])
+	return b, nil
+}
+
+// Start returns the measurement start time from the file header.
+func (b *BLFReader) Start() time.Time {
+	return b.start
+}
+
+// systemTime decodes a Windows SYSTEMTIME: year, month, day of week, day, hour, minute, second, milliseconds.
+func systemTime(b []byte) time.Time {
+	v := func(i int) int { return int(binary.LittleEndian.Uint16(b[2*i:])) }
+	if v(0) == 0 {
+		return time.Time{}
+	}
+	return time.Date(v(0), time.Month(v(1)), v(3), v(4), v(5), v(6), v(7)*int(time.Millisecond), time.UTC)
+}
+
+// Next returns the next frame.
+func (b *BLFReader) Next() (Frame, error) {
+	for {
+		if len(b.inner) >= blfBaseHeaderSize {
+			obj, n, ok := b.object(b.inner)
+			if ok {
+				b.inner = b.inner[n:]
+				if f, ok := b.frame(obj); ok {
+					return f, nil
+				}
+				continue
+			}
+		}
+		obj, err := b.readObject()
+		if err != nil {
+			return Frame{}, err
+		}
+		if binary.LittleEndian.Uint32(obj[12:]) != blfLogContainer {
+			if f, ok := b.frame(obj); ok {
+				return f, nil
+			}
+			continue
+		}
+		data, err := container(obj)
+		if err != nil {
+			return Frame{}, err
+		}
+		b.inner = append(b.inner, data...)
+	}
+}
+
+// readObject reads the next top level object from the file.
+func (b *BLFReader) readObject() ([]byte, error) {
+	for {
+		h, err := b.r.Peek(blfBaseHeaderSize)
+		if err == io.EOF || (err != nil && len(h) == 0) {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if string(h[:4]) != "LOBJ" {
+			// padding between objects
+			if _, err := b.r.Discard(1); err != nil {
+				return nil, io.EOF
+			}
+			continue
+		}
+		size := binary.LittleEndian.Uint32(h[8:])
+		if size < blfBaseHeaderSize || size > 1<<26 {
+			return nil, fmt.Errorf("cantrace: BLF object of invalid size %d", size)
+		}
+		obj := make([]byte, size)
+		if _, err := io.ReadFull(b.r, obj); err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return obj, nil
+	}
+}
+
+// object splits the next object off uncompressed container data. It returns the object, the number of bytes
+// consumed including padding, and false if the object is not complete yet.
+func (b *BLFReader) object(data []byte) ([]byte, int, bool) {
+	skip := bytes.Index(data, []byte("LOBJ"))
+	if skip < 0 {
+		return nil, 0, false
+	}
+	data = data[skip:]
+	if len(data) < blfBaseHeaderSize {
+		return nil, 0, false
+	}
+	size := int(binary.LittleEndian.Uint32(data[8:]))
+	if size < blfBaseHeaderSize {
+		// damaged object, skip its signature
+		return nil, skip + 4, true
+	}
+	if len(data) < size {
+		return nil, 0, false
+	}
+	n := skip + size + size%4
+	if n > skip+len(data) {
+		n = skip + len(data)
+	}
+	return data[:size], n, true
+}
+
+// container returns the uncompressed data of a log container object.
+func container(obj []byte) ([]byte, error) {
+	hsize := int(binary.LittleEndian.Uint16(obj[4:]))
+	if hsize < blfBaseHeaderSize || len(obj) < hsize+blfContainerHeaderSize {
+		return nil, fmt.Errorf("cantrace: BLF log container too short")
+	}
+	ch := obj[hsize:]
+	method := binary.LittleEndian.Uint16(ch)
+	data := ch[blfContainerHeaderSize:]
+	switch method {
+	case 0:
+		return data, nil
+	case 2:
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("cantrace: BLF log container: %v", err)
+		}
+		defer zr.Close()
+		out := make([]byte, 0, binary.LittleEndian.Uint32(ch[8:]))
+		buf := bytes.NewBuffer(out)
+		if _, err := io.Copy(buf, zr); err != nil {
+			return nil, fmt.Errorf("cantrace: BLF log container: %v", err)
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("cantrace: BLF log container compression %d not supported", method)
+}
+
+// frame converts a CAN object to a frame.
+func (b *BLFReader) frame(obj []byte) (Frame, bool) {
+	hsize := int(binary.LittleEndian.Uint16(obj[4:]))
+	typ := binary.LittleEndian.Uint32(obj[12:])
+	if len(obj) < hsize || hsize < 32 {
+		return Frame{}, false
+	}
+	// both header versions carry the object flags at 16 and the timestamp at 24
+	flags := binary.LittleEndian.Uint32(obj[16:])
+	ts := binary.LittleEndian.Uint64(obj[24:])
+	var t time.Duration
+	if flags == 1 {
+		// 10 microsecond resolution
+		t = time.Duration(ts) * 10 * time.Microsecond
+	} else {
+		t = time.Duration(ts)
+	}
+	body := obj[hsize:]
+	var f Frame
+	switch typ {
+	case blfCANMessage, blfCANMessage2:
+		// channel, flags, dlc, id, data[8]
+		if len(body) < 16 {
+			return Frame{}, false
+		}
+		dlc := int(body[3])
+		if dlc > 8 {
+			dlc = 8
+		}
+		f.Channel = strconv.Itoa(int(binary.LittleEndian.Uint16(body)))
+		f.Remote = body[2]&0x80 != 0
+		f.ID = binary.LittleEndian.Uint32(body[4:])
+		if !f.Remote {
+			f.Data = append([]byte(nil), body[8:8+dlc]...)
+		}
+	case blfCANFDMessage:
+		// channel, flags, dlc, id, frame length, arbitration bit count, FD flags, valid data bytes,
+		// reserved[5], data[64]
+		if len(body) < 20 {
+			return Frame{}, false
+		}
+		n := int(body[14])
+		if n > 64 || len(body) < 20+n {
+			return Frame{}, false
+		}
+		f.Channel = strconv.Itoa(int(binary.LittleEndian.Uint16(body)))
+		f.ID = binary.LittleEndian.Uint32(body[4:])
+		f.FD = body[13]&0x01 != 0
+		f.Remote = body[2]&0x80 != 0
+		f.Data = append([]byte(nil), body[20:20+n]...)
+	case blfCANFDMessage64:
+		// channel, dlc, valid data bytes, tx count, id, frame length, flags, ... , data at 40
+		if len(body) < 40 {
+			return Frame{}, false
+		}
+		n := int(body[2])
+		if n > 64 || len(body) < 40+n {
+			return Frame{}, false
+		}
+		fl := binary.LittleEndian.Uint32(body[12:])
+		f.Channel = strconv.Itoa(int(body[0]))
+		f.ID = binary.LittleEndian.Uint32(body[4:])
+		f.FD = fl&0x1000 != 0
+		f.Remote = fl&0x0010 != 0
+		f.Data = append([]byte(nil), body[40:40+n]...)
+	default:
+		return Frame{}, false
+	}
+	f.Extended = f.ID&blfExtendedID != 0
+	f.ID &^= blfExtendedID
+	f.Time = b.start.Add(t)
+	return f, true
+}
@@ -0,0 +1,103 @@
+package cantrace
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A CandumpReader reads log files written by "candump -l", one frame per line:
+//
+//	(1436509052.249713) vcan0 044#2A366C2BBA
+//	(1436509052.449847) vcan0 1F334455#R
+//	(1436509052.650004) can1 123##1DEADBEEF
+type CandumpReader struct {
+	sc   *bufio.Scanner
+	line int
+}
+
+// NewCandumpReader returns a reader for a candump log.
+func NewCandumpReader(r io.Reader) *CandumpReader {
+	return &CandumpReader{sc: bufio.NewScanner(r)}
+}
+
+// Next returns the next frame.
+func (c *CandumpReader) Next() (Frame, error) {
+	for c.sc.Scan() {
+		c.line++
+		line := strings.TrimSpace(c.sc.Text())
+		if line == "" {
+			continue
+		}
+		f, err := parseCandump(line)
+		if err != nil {
+			return Frame{}, fmt.Errorf("cantrace: candump line %d: %v", c.line, err)
+		}
+		return f, nil
+	}
+	if err := c.sc.Err(); err != nil {
+		return Frame{}, err
+	}
+	return Frame{}, io.EOF
+}
+
+func parseCandump(line string) (Frame, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || !strings.HasPrefix(fields[0], "(") || !strings.HasSuffix(fields[0], ")") {
+		return Frame{}, fmt.Errorf("expected (timestamp) interface frame")
+	}
+	var f Frame
+	ts := strings.Trim(fields[0], "()")
+	sec, frac := ts, ""
+	if i := strings.IndexByte(ts, '.'); i >= 0 {
+		sec, frac = ts[:i], ts[i+1:]
+	}
+	s, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return Frame{}, fmt.Errorf("invalid timestamp %q", ts)
+	}
+	var ns int64
+	if frac != "" {
+		if len(frac) > 9 {
+			frac = frac[:9]
+		}
+		if ns, err = strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 64); err != nil {
+			return Frame{}, fmt.Errorf("invalid timestamp %q", ts)
+		}
+	}
+	f.Time = time.Unix(s, ns)
+	f.Channel = fields[1]
+
+	frame := fields[2]
+	i := strings.IndexByte(frame, '#')
+	if i < 0 {
+		return Frame{}, fmt.Errorf("missing '#' in %q", frame)
+	}
+	id, data := frame[:i], frame[i+1:]
+	n, err := strconv.ParseUint(id, 16, 32)
+	if err != nil {
+		return Frame{}, fmt.Errorf("invalid identifier %q", id)
+	}
+	f.ID = uint32(n)
+	f.Extended = len(id) > 3
+	switch {
+	case strings.HasPrefix(data, "#"):
+		// CAN FD: ##<flags><data>
+		if len(data) < 2 {
+			return Frame{}, fmt.Errorf("missing CAN FD flags in %q", frame)
+		}
+		f.FD = true
+		data = data[2:]
+	case strings.HasPrefix(data, "R"):
+		f.Remote = true
+		return f, nil
+	}
+	if f.Data, err = hex.DecodeString(strings.Replace(data, ".", "", -1)); err != nil {
+		return Frame{}, fmt.Errorf("invalid data %q", data)
+	}
+	return f, nil
+}
@@ -0,0 +1,242 @@
+package cantrace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A Database holds CAN message layouts read from a DBC file.
+type Database struct {
+	// Messages by identifier; extended identifiers have bit 31 set as in the DBC file
+	Messages map[uint32]*Message
+}
+
+// A Message is a CAN message layout.
+type Message struct {
+	// Identifier, with bit 31 set for a 29 bit identifier
+	ID   uint32
+	Name string
+	// Payload length in bytes
+	Size    int
+	Signals []*Signal
+}
+
+// A Signal is a field of a CAN message.
+type Signal struct {
+	Name string
+	// Start bit: the least significant bit for little endian (Intel) signals and the most significant bit for
+	// big endian (Motorola) signals, in DBC bit numbering
+	Start  int
+	Length int
+	// Byte order: true for little endian (Intel, @1), false for big endian (Motorola, @0)
+	LittleEndian bool
+	Signed       bool
+	// Physical value = raw value * Factor + Offset
+	Factor float64
+	Offset float64
+	Unit   string
+	// Multiplexor is set on the multiplexer switch of the message (M). Multiplexed signals (m<n>) are present
+	// only in frames where the switch has the raw value MuxValue.
+	Multiplexor bool
+	Multiplexed bool
+	MuxValue    uint64
+}
+
+// dbcExtended marks 29 bit identifiers in DBC files.
+const dbcExtended = 0x80000000
+
+// ParseDBC reads the message (BO_) and signal (SG_) definitions of a DBC file. Other sections are ignored.
+// Multiplexed signals are decoded only from frames whose multiplexer switch selects them; extended
+// multiplexing (SG_MUL_VAL_) is not supported, so a signal that is both multiplexed and a switch follows its
+// own m<n> value only.
+func ParseDBC(r io.Reader) (*Database, error) {
+	db := &Database{Messages: map[uint32]*Message{}}
+	var msg *Message
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case strings.HasPrefix(line, "BO_ "):
+			// BO_ <id> <name>: <size> <transmitter>
+			f := strings.Fields(strings.Replace(line, ":", " ", 1))
+			if len(f) < 4 {
+				return nil, fmt.Errorf("cantrace: dbc line %d: invalid message", n)
+			}
+			id, err1 := strconv.ParseUint(f[1], 10, 32)
+			size, err2 := strconv.Atoi(f[3])
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("cantrace: dbc line %d: invalid message", n)
+			}
+			msg = &Message{ID: uint32(id), Name: f[2], Size: size}
+			db.Messages[msg.ID] = msg
+		case strings.HasPrefix(line, "SG_ "):
+			if msg == nil {
+				return nil, fmt.Errorf("cantrace: dbc line %d: signal outside a message", n)
+			}
+			s, err := parseSignal(line)
+			if err != nil {
+				return nil, fmt.Errorf("cantrace: dbc line %d: %v", n, err)
+			}
+			msg.Signals = append(msg.Signals, s)
+		case line == "":
+			msg = nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// parseSignal parses
+//
+//	SG_ <name> [M|m<n>] : <start>|<length>@<order><sign> (<factor>,<offset>) [<min>|<max>] "<unit>" <receivers>
+func parseSignal(line string) (*Signal, error) {
+	colon := strings.IndexByte(line, ':')
+	if colon < 0 {
+		return nil, fmt.Errorf("invalid signal")
+	}
+	head := strings.Fields(line[:colon])
+	if len(head) < 2 {
+		return nil, fmt.Errorf("invalid signal")
+	}
+	s := &Signal{Name: head[1]}
+	if len(head) > 2 {
+		mux := head[2]
+		switch {
+		case mux == "M":
+			s.Multiplexor = true
+		case strings.HasPrefix(mux, "m"):
+			v, err := strconv.ParseUint(strings.TrimSuffix(mux[1:], "M"), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("signal %s: invalid multiplexer %q", s.Name, mux)
+			}
+			s.Multiplexed, s.MuxValue = true, v
+		default:
+			return nil, fmt.Errorf("signal %s: invalid multiplexer %q", s.Name, mux)
+		}
+	}
+	f := strings.Fields(line[colon+1:])
+	if len(f) < 2 {
+		return nil, fmt.Errorf("signal %s: missing layout", s.Name)
+	}
+	// <start>|<length>@<order><sign>
+	layout := strings.NewReplacer("|", " ", "@", " ").Replace(f[0])
+	var spec string
+	if _, err := fmt.Sscan(layout, &s.Start, &s.Length, &spec); err != nil || len(spec) != 2 {
+		return nil, fmt.Errorf("signal %s: invalid layout %q", s.Name, f[0])
+	}
+	s.LittleEndian = spec[0] == '1'
+	s.Signed = spec[1] == '-'
+	if s.Length < 1 || s.Length > 64 {
+		return nil, fmt.Errorf("signal %s: invalid length %d", s.Name, s.Length)
+	}
+	// (<factor>,<offset>)
+	scale := strings.Split(strings.Trim(f[1], "()"), ",")
+	if len(scale) != 2 {
+		return nil, fmt.Errorf("signal %s: invalid scale %q", s.Name, f[1])
+	}
+	var err error
+	if s.Factor, err = strconv.ParseFloat(scale[0], 64); err != nil {
+		return nil, fmt.Errorf("signal %s: invalid factor %q", s.Name, scale[0])
+	}
+	if s.Offset, err = strconv.ParseFloat(scale[1], 64); err != nil {
+		return nil, fmt.Errorf("signal %s: invalid offset %q", s.Name, scale[1])
+	}
+	if q := strings.IndexByte(line[colon:], '"'); q >= 0 {
+		unit := line[colon+q+1:]
+		if e := strings.IndexByte(unit, '"'); e >= 0 {
+			s.Unit = unit[:e]
+		}
+	}
+	return s, nil
+}
+
+// Raw extracts the raw value of the signal from a frame payload. It returns false if the payload is too short.
+func (s *Signal) Raw(data []byte) (uint64, bool) {
+	var raw uint64
+	bit := s.Start
+	for i := 0; i < s.Length; i++ {
+		var pos int
+		if s.LittleEndian {
+			pos = s.Start + i
+		} else {
+			pos = bit
+		}
+		if pos/8 >= len(data) || pos < 0 {
+			return 0, false
+		}
+		b := uint64(data[pos/8]>>(uint(pos)%8)) & 1
+		if s.LittleEndian {
+			raw |= b << uint(i)
+		} else {
+			raw = raw<<1 | b
+			// walk from the most significant bit towards the next byte in DBC sawtooth numbering
+			if bit%8 == 0 {
+				bit += 15
+			} else {
+				bit--
+			}
+		}
+	}
+	return raw, true
+}
+
+// Present reports whether the signal s of the message is carried by a frame payload: always for a signal that
+// is not multiplexed, and for a multiplexed one when the multiplexer switch selects it.
+func (m *Message) Present(s *Signal, data []byte) bool {
+	if !s.Multiplexed {
+		return true
+	}
+	for _, sw := range m.Signals {
+		if sw.Multiplexor {
+			raw, ok := sw.Raw(data)
+			return ok && raw == s.MuxValue
+		}
+	}
+	return false
+}
+
+// Value returns the physical value of the signal in a frame payload.
+func (s *Signal) Value(data []byte) (float64, bool) {
+	raw, ok := s.Raw(data)
+	if !ok {
+		return 0, false
+	}
+	v := float64(raw)
+	if s.Signed && s.Length < 64 && raw&(1<<uint(s.Length-1)) != 0 {
+		v = float64(int64(raw) - int64(1)<<uint(s.Length))
+	} else if s.Signed {
+		v = float64(int64(raw))
+	}
+	return v*s.Factor + s.Offset, true
+}
+
+// Signal returns the signal with the given name, either "Signal" or "Message.Signal". An unqualified name
+// must be unique in the database.
+func (db *Database) Signal(name string) (*Message, *Signal, bool) {
+	msgName := ""
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		msgName, name = name[:i], name[i+1:]
+	}
+	var (
+		msg   *Message
+		sig   *Signal
+		found int
+	)
+	for _, m := range db.Messages {
+		if msgName != "" && m.Name != msgName {
+			continue
+		}
+		for _, s := range m.Signals {
+			if s.Name == name {
+				msg, sig = m, s
+				found++
+			}
+		}
+	}
+	return msg, sig, found == 1
+}
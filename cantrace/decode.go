@@ -0,0 +1,192 @@
+package cantrace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+)
+
+// A Decoder turns CAN frames into vehicle data samples using a DBC database and a list of bindings from CAN
+// signals to vehicle data fields. It keeps the last value of every bound signal, so a frame that updates one
+// field of a type (e.g. Door.Lock) yields a sample with the other fields as last seen.
+//
+// Bindings are read one per line:
+//
+//	# <type>[<zone>].<field> = <signal> [* <scale>] [+ <offset>] [map <raw>:<value> ...]
+//	EngineSpeed.Speed       = EngineSpeed
+//	VehicleSpeed.Speed      = ESP_Speed.VehicleSpeed * 1000
+//	Door[front-left].Status = DoorFL map 0:Closed 1:Open 2:Ajar
+//	LightStatus.Head        = LowBeam
+//
+// The signal's DBC factor and offset are applied first, then the binding's scale and offset, which typically
+// convert units (km/h to the meters per hour of VehicleSpeed above). A map translates raw signal values to
+// enumerated constants or booleans; unmapped raw values are ignored.
+type Decoder struct {
+	db       *Database
+	bindings map[uint32][]binding
+	state    map[string]interface{}
+}
+
+type binding struct {
+	msg    *Message
+	sig    *Signal
+	key    string
+	field  string
+	scale  float64
+	offset float64
+	values map[uint64]string
+}
+
+// NewDecoder returns a decoder for the signals of db bound by the bindings read from r.
+func NewDecoder(db *Database, r io.Reader) (*Decoder, error) {
+	d := &Decoder{db: db, bindings: map[uint32][]binding{}, state: map[string]interface{}{}}
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if err := d.bind(line); err != nil {
+			return nil, fmt.Errorf("cantrace: bindings line %d: %v", n, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *Decoder) bind(line string) error {
+	eq := strings.IndexByte(line, '=')
+	if eq < 0 {
+		return fmt.Errorf("expected <field> = <signal>")
+	}
+	path := strings.TrimSpace(line[:eq])
+	dot := strings.LastIndexByte(path, '.')
+	if dot <= 0 || dot < strings.LastIndexByte(path, ']') {
+		return fmt.Errorf("expected <type>.<field>, got %q", path)
+	}
+	b := binding{key: path[:dot], field: path[dot+1:], scale: 1}
+	v, ok := vehicledata.NewFromKey(b.key)
+	if !ok {
+		return fmt.Errorf("invalid signal %s", b.key)
+	}
+	if _, ok := vehicledata.Field(v, b.field); !ok {
+		return fmt.Errorf("%s has no scalar field %s", b.key, b.field)
+	}
+
+	f := strings.Fields(line[eq+1:])
+	if len(f) == 0 {
+		return fmt.Errorf("missing signal")
+	}
+	msg, sig, ok := d.db.Signal(f[0])
+	if !ok {
+		return fmt.Errorf("unknown or ambiguous signal %s", f[0])
+	}
+	b.msg, b.sig = msg, sig
+	for f = f[1:]; len(f) > 0; {
+		switch f[0] {
+		case "*", "+":
+			if len(f) < 2 {
+				return fmt.Errorf("missing number after %s", f[0])
+			}
+			x, err := strconv.ParseFloat(f[1], 64)
+			if err != nil {
+				return fmt.Errorf("invalid number %q", f[1])
+			}
+			if f[0] == "*" {
+				b.scale = x
+			} else {
+				b.offset = x
+			}
+			f = f[2:]
+		case "map":
+			b.values = map[uint64]string{}
+			for _, m := range f[1:] {
+				kv := strings.SplitN(m, ":", 2)
+				raw, err := strconv.ParseUint(kv[0], 0, 64)
+				if len(kv) != 2 || err != nil {
+					return fmt.Errorf("invalid map entry %q", m)
+				}
+				if err := vehicledata.ParseField(v, b.field, kv[1]); err != nil {
+					return err
+				}
+				b.values[raw] = kv[1]
+			}
+			f = nil
+		default:
+			return fmt.Errorf("unexpected %q", f[0])
+		}
+	}
+	d.bindings[msg.ID] = append(d.bindings[msg.ID], b)
+	return nil
+}
+
+// Decode returns the samples updated by a frame, one per vehicle data signal, timestamped with the frame time.
+func (d *Decoder) Decode(f Frame) []vehicledata.Sample {
+	if f.Remote {
+		return nil
+	}
+	id := f.ID
+	if f.Extended {
+		id |= dbcExtended
+	}
+	var (
+		samples []vehicledata.Sample
+		changed = map[string]bool{}
+		order   []string
+	)
+	for _, b := range d.bindings[id] {
+		if !b.msg.Present(b.sig, f.Data) {
+			continue
+		}
+		v, err := d.signal(b.key)
+		if err != nil {
+			continue
+		}
+		if b.values != nil {
+			raw, ok := b.sig.Raw(f.Data)
+			if !ok {
+				continue
+			}
+			text, ok := b.values[raw]
+			if !ok || vehicledata.ParseField(v, b.field, text) != nil {
+				continue
+			}
+		} else {
+			x, ok := b.sig.Value(f.Data)
+			if !ok || vehicledata.SetField(v, b.field, x*b.scale+b.offset) != nil {
+				continue
+			}
+		}
+		if !changed[b.key] {
+			changed[b.key] = true
+			order = append(order, b.key)
+		}
+	}
+	for _, key := range order {
+		samples = append(samples, vehicledata.Sample{Time: f.Time, Value: reflect.ValueOf(d.state[key]).Elem().Interface()})
+	}
+	return samples
+}
+
+// signal returns the current value of the signal with the given key.
+func (d *Decoder) signal(key string) (interface{}, error) {
+	if v, ok := d.state[key]; ok {
+		return v, nil
+	}
+	v, ok := vehicledata.NewFromKey(key)
+	if !ok {
+		return nil, fmt.Errorf("invalid signal %s", key)
+	}
+	d.state[key] = v
+	return v, nil
+}
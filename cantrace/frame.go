@@ -0,0 +1,52 @@
+// Package cantrace reads recorded CAN traces (candump log files, Vector ASC and Vector BLF) and decodes their
+// frames into vehicle data samples, so historic drives can be analysed with the same types used live.
+//
+// Decoding needs a signal definition: the message layout from a DBC file and bindings from CAN signals to
+// vehicle data fields, see Decoder.
+package cantrace
+
+import (
+	"io"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+)
+
+// A Frame is a CAN or CAN FD frame read from a trace.
+type Frame struct {
+	// Time the frame was received
+	Time time.Time
+	// Bus or channel the frame was seen on, e.g. "can0" or "1"
+	Channel string
+	// Arbitration ID
+	ID uint32
+	// True for a 29 bit identifier
+	Extended bool
+	// True for a remote transmission request
+	Remote bool
+	// True for a CAN FD frame
+	FD bool
+	// Payload, up to 8 bytes for CAN and 64 bytes for CAN FD
+	Data []byte
+}
+
+// A Reader reads frames from a trace in file order. Next returns io.EOF at the end of the trace.
+type Reader interface {
+	Next() (Frame, error)
+}
+
+// Samples reads every frame of r, decodes it with d and calls emit for each resulting sample.
+func Samples(r Reader, d *Decoder, emit func(vehicledata.Sample)) error {
+	for {
+		f, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, s := range d.Decode(f) {
+			emit(s)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+// Package crash detects vehicle crashes from Acceleration spikes, sudden VehicleSpeed drops and airbag
+// deployment reported by AirbagStatus, and records the signals around the crash.
+//
+// Acceleration follows ISO 8855: X forward, Y to the left. The impact direction is the direction the force
+// came from, opposite to the acceleration it caused; without an acceleration spike it is taken from the zones
+// of the deployed airbags.
+//
+// A crash is reported once its post-event window has been recorded, with the samples of all signals from
+// PreEvent before the crash to PostEvent after it, and the eCall minimum set of data. An impact may cut off the
+// signals, so Tick must be called periodically to end the window without a later sample; Flush reports a crash
+// at once with the signals recorded so far, e.g. before shutting down:
+//
+//	d := crash.NewDetector(crash.Config{})
+//	for {
+//		var crashes []crash.Crash
+//		select {
+//		case smp := <-samples:
+//			crashes = d.Update(smp)
+//		case now := <-ticker.C:
+//			crashes = d.Tick(now)
+//		}
+//		for _, c := range crashes {
+//			// c.MSD is ready for the eCall modem, c.Samples for the accident data recorder
+//		}
+//	}
+package crash
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+	"github.com/calvernaz/w3c-vehicle-data/ecall"
+	"github.com/calvernaz/w3c-vehicle-data/types/zone"
+)
+
+// Severity grades a crash.
+type Severity int
+
+const (
+	Minor Severity = iota + 1
+	Moderate
+	Severe
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Minor:
+		return "minor"
+	case Moderate:
+		return "moderate"
+	case Severe:
+		return "severe"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// Config configures a detector. Zero values select the defaults noted on each field.
+type Config struct {
+	// Horizontal acceleration of a minor, moderate and severe crash (Unit: meters per second squared, defaults
+	// 3, 6 and 10 g)
+	Acceleration [3]float64
+	// Speed change of a minor, moderate and severe crash (Unit: meters per second, defaults 8, 25 and 40 km/h)
+	DeltaV [3]float64
+	// Window in which a speed drop of at least the minor DeltaV is a crash (default 300 ms)
+	SpeedDropWindow time.Duration
+	// Signals recorded before and after the crash (defaults 10 s and 5 s)
+	PreEvent, PostEvent time.Duration
+}
+
+const g = 9.80665
+
+func (c *Config) defaults() {
+	if c.Acceleration == [3]float64{} {
+		c.Acceleration = [3]float64{3 * g, 6 * g, 10 * g}
+	}
+	if c.DeltaV == [3]float64{} {
+		c.DeltaV = [3]float64{8 / 3.6, 25 / 3.6, 40 / 3.6}
+	}
+	if c.SpeedDropWindow <= 0 {
+		c.SpeedDropWindow = 300 * time.Millisecond
+	}
+	if c.PreEvent <= 0 {
+		c.PreEvent = 10 * time.Second
+	}
+	if c.PostEvent <= 0 {
+		c.PostEvent = 5 * time.Second
+	}
+}
+
+// A Crash is a detected crash.
+type Crash struct {
+	// Time of the first trigger
+	Time     time.Time
+	Severity Severity
+	// Peak horizontal acceleration (Unit: meters per second squared)
+	PeakAcceleration float64
+	// Speed change during the impact, from the acceleration or the speed drop (Unit: meters per second)
+	DeltaV float64
+	// Impact direction clockwise from the front of the vehicle, -180 to 180 (Unit: degrees); NaN if unknown
+	Direction float64
+	// Impact zone: Front, Rear, Left or Right
+	Zone zone.Zone
+	// Signal keys of the deployed airbags, e.g. "AirbagStatus[front-left]"
+	Airbags []string
+	// Signals from PreEvent before to PostEvent after the crash
+	Samples []vehicledata.Sample
+	// eCall minimum set of data
+	MSD ecall.MSD
+}
+
+// A Detector detects crashes. It is not safe for concurrent use.
+type Detector struct {
+	cfg Config
+	msd *ecall.Builder
+
+	// recorded samples, oldest first
+	buf []vehicledata.Sample
+
+	deployed map[string]bool
+	speeds   []vehicledata.Sample
+
+	// impact integration: acceleration above the minor threshold
+	impact    bool
+	impactDV  [2]float64
+	lastAccel time.Time
+	accel     [2]float64
+	// crash being recorded
+	crash *Crash
+}
+
+// NewDetector returns a detector.
+func NewDetector(cfg Config) *Detector {
+	cfg.defaults()
+	return &Detector{cfg: cfg, msd: ecall.NewBuilder(), deployed: map[string]bool{}}
+}
+
+// MSD returns the builder of the eCall data, e.g. to update the vehicle position.
+func (d *Detector) MSD() *ecall.Builder {
+	return d.msd
+}
+
+// Update processes a sample and returns the crashes whose post-event window it completes.
+func (d *Detector) Update(s vehicledata.Sample) []Crash {
+	t := s.Time
+	d.msd.Update(s)
+	d.record(s)
+
+	switch v := s.Value.(type) {
+	case vehicledata.Acceleration:
+		d.acceleration(t, float64(v.X)/100, float64(v.Y)/100)
+	case vehicledata.VehicleSpeed:
+		d.speedDrop(s)
+	case vehicledata.AirbagStatus:
+		key := s.Key()
+		if v.Deployed && !d.deployed[key] {
+			c := d.trigger(t)
+			c.Airbags = append(c.Airbags, key)
+			if c.Severity < Moderate {
+				c.Severity = Moderate
+			}
+		}
+		d.deployed[key] = v.Deployed
+	}
+
+	return d.Tick(t)
+}
+
+// Tick returns the crash whose post-event window has ended at time now.
+func (d *Detector) Tick(now time.Time) []Crash {
+	if d.crash == nil || now.Sub(d.crash.Time) < d.cfg.PostEvent {
+		return nil
+	}
+	return []Crash{d.finish()}
+}
+
+// Flush returns the crash being recorded, if any, with the samples of its post-event window recorded so far.
+func (d *Detector) Flush() []Crash {
+	if d.crash == nil {
+		return nil
+	}
+	return []Crash{d.finish()}
+}
+
+// record appends a sample to the buffer and drops the samples no crash will need.
+func (d *Detector) record(s vehicledata.Sample) {
+	d.buf = append(d.buf, s)
+	keep := s.Time.Add(-d.cfg.PreEvent)
+	if d.crash != nil {
+		keep = d.crash.Time.Add(-d.cfg.PreEvent)
+	}
+	i := 0
+	for i < len(d.buf) && d.buf[i].Time.Before(keep) {
+		i++
+	}
+	if i > len(d.buf)/2 {
+		d.buf = append(d.buf[:0], d.buf[i:]...)
+	}
+}
+
+// trigger starts a crash at time t, or returns the crash being recorded.
+func (d *Detector) trigger(t time.Time) *Crash {
+	if d.crash == nil {
+		d.crash = &Crash{Time: t, Severity: Minor, Direction: math.NaN()}
+	}
+	return d.crash
+}
+
+// acceleration integrates an impact while the horizontal acceleration exceeds the minor threshold.
+func (d *Detector) acceleration(t time.Time, x, y float64) {
+	dt := 0.0
+	if !d.lastAccel.IsZero() && t.After(d.lastAccel) && t.Sub(d.lastAccel) < time.Second {
+		dt = t.Sub(d.lastAccel).Seconds()
+	}
+	d.lastAccel = t
+	mag := math.Hypot(x, y)
+	if mag < d.cfg.Acceleration[0] {
+		d.impact = false
+		d.accel = [2]float64{x, y}
+		return
+	}
+	if !d.impact {
+		d.impact, d.impactDV = true, [2]float64{}
+	}
+	// trapezoid over the previous sample, which may lie below the threshold
+	d.impactDV[0] += (d.accel[0] + x) / 2 * dt
+	d.impactDV[1] += (d.accel[1] + y) / 2 * dt
+	d.accel = [2]float64{x, y}
+
+	c := d.trigger(t)
+	if mag > c.PeakAcceleration {
+		c.PeakAcceleration = mag
+		c.Direction = direction(x, y)
+		c.Zone = zoneOf(c.Direction)
+	}
+	c.DeltaV = math.Max(c.DeltaV, math.Hypot(d.impactDV[0], d.impactDV[1]))
+	c.Severity = maxSeverity(c.Severity, grade(c.PeakAcceleration, d.cfg.Acceleration), grade(c.DeltaV, d.cfg.DeltaV))
+}
+
+// speedDrop detects a speed loss of at least the minor DeltaV within the speed drop window.
+func (d *Detector) speedDrop(s vehicledata.Sample) {
+	d.speeds = append(d.speeds, s)
+	i := 0
+	for i < len(d.speeds) && s.Time.Sub(d.speeds[i].Time) > d.cfg.SpeedDropWindow {
+		i++
+	}
+	d.speeds = d.speeds[i:]
+	v := float64(s.Value.(vehicledata.VehicleSpeed).Speed) / 3600
+	var drop float64
+	for _, p := range d.speeds {
+		drop = math.Max(drop, float64(p.Value.(vehicledata.VehicleSpeed).Speed)/3600-v)
+	}
+	if drop < d.cfg.DeltaV[0] {
+		return
+	}
+	c := d.trigger(s.Time)
+	c.DeltaV = math.Max(c.DeltaV, drop)
+	c.Severity = maxSeverity(c.Severity, grade(c.DeltaV, d.cfg.DeltaV))
+	if math.IsNaN(c.Direction) {
+		// losing speed this fast means a frontal impact
+		c.Direction, c.Zone = 0, zoneOf(0)
+	}
+}
+
+// finish completes the crash being recorded.
+func (d *Detector) finish() Crash {
+	c := *d.crash
+	d.crash = nil
+	if math.IsNaN(c.Direction) && len(c.Airbags) > 0 {
+		_, z := vehicledata.SplitKey(c.Airbags[0])
+		c.Zone = z
+	}
+	sort.Strings(c.Airbags)
+	from, to := c.Time.Add(-d.cfg.PreEvent), c.Time.Add(d.cfg.PostEvent)
+	for _, s := range d.buf {
+		if !s.Time.Before(from) && !s.Time.After(to) {
+			c.Samples = append(c.Samples, s)
+		}
+	}
+	c.MSD = d.msd.Build(c.Time, true)
+	return c
+}
+
+// direction returns the impact direction of an acceleration, clockwise from the front.
+func direction(x, y float64) float64 {
+	// the force came from the opposite of the acceleration; Y points left, so clockwise is -Y
+	return math.Atan2(y, -x) * 180 / math.Pi
+}
+
+func zoneOf(dir float64) zone.Zone {
+	switch {
+	case dir >= -45 && dir <= 45:
+		return zone.Parse("front")
+	case dir > 45 && dir < 135:
+		return zone.Parse("right")
+	case dir < -45 && dir > -135:
+		return zone.Parse("left")
+	}
+	return zone.Parse("rear")
+}
+
+func grade(x float64, levels [3]float64) Severity {
+	for i := len(levels) - 1; i >= 0; i-- {
+		if x >= levels[i] {
+			return Severity(i + 1)
+		}
+	}
+	return 0
+}
+
+func maxSeverity(s ...Severity) Severity {
+	m := s[0]
+	for _, x := range s[1:] {
+		if x > m {
+			m = x
+		}
+	}
+	return m
+}
@@ -0,0 +1,66 @@
+// Package derive computes the abstract vehicle data types, such as DrivingMode, from the signals they combine.
+//
+// A Deriver is fed every input sample in time order and returns the derived samples. Attach connects a deriver
+// to a subscription hub:
+//
+//	h := subscription.NewHub()
+//	defer derive.Attach(h, derive.NewDrivingMode(derive.DrivingModeConfig{}))()
+//	h.Subscribe("DrivingMode", subscription.Options{}, func(s vehicledata.Sample) { ... })
+package derive
+
+import (
+	"sync"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+	"github.com/calvernaz/w3c-vehicle-data/subscription"
+)
+
+// A Deriver computes vehicle data signals from other signals. Update is called with every input sample in time
+// order and returns the derived samples, if any. Derivers are not safe for concurrent use.
+type Deriver interface {
+	Update(s vehicledata.Sample) []vehicledata.Sample
+}
+
+// Attach feeds every sample published on h to d and publishes the derived samples on h. The returned function
+// detaches the deriver.
+func Attach(h *subscription.Hub, d Deriver) func() {
+	var mu sync.Mutex
+	return h.Subscribe("", subscription.Options{}, func(s vehicledata.Sample) {
+		mu.Lock()
+		out := d.Update(s)
+		mu.Unlock()
+		for _, smp := range out {
+			h.Publish(smp)
+		}
+	})
+}
+
+// A debouncer follows a boolean input that must hold a new value for a delay before the output changes.
+type debouncer struct {
+	out     bool
+	started bool
+	// time the input started to differ from out, zero if it agrees
+	pending time.Time
+}
+
+// update feeds the input at time now and reports whether the output changed. The first call sets the output
+// without delay and reports a change.
+func (b *debouncer) update(now time.Time, in bool, delay time.Duration) bool {
+	if !b.started {
+		b.started, b.out = true, in
+		return true
+	}
+	if in == b.out {
+		b.pending = time.Time{}
+		return false
+	}
+	if b.pending.IsZero() {
+		b.pending = now
+	}
+	if now.Sub(b.pending) < delay {
+		return false
+	}
+	b.out, b.pending = in, time.Time{}
+	return true
+}
@@ -0,0 +1,132 @@
+package derive
+
+import (
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+	"github.com/calvernaz/w3c-vehicle-data/types/parking-brake-status"
+	"github.com/calvernaz/w3c-vehicle-data/types/transmission-mode"
+	"github.com/calvernaz/w3c-vehicle-data/types/vehicle-power"
+)
+
+// DrivingModeConfig configures the DrivingMode deriver. Zero values select the defaults noted on each field.
+type DrivingModeConfig struct {
+	// Speed at or above which the vehicle is moving (Unit: meters per hour, default 8000)
+	EnterSpeed uint16
+	// Speed at or below which a moving vehicle has stopped (Unit: meters per hour, default 3000)
+	ExitSpeed uint16
+	// Time the inputs must call for driving mode before it is set (default 500 ms, negative for none)
+	EnterDebounce time.Duration
+	// Time the inputs must call for leaving driving mode before it is cleared (default 3 s, negative for none)
+	ExitDebounce time.Duration
+	// Also enter driving mode from standstill with power running, a drive or reverse gear selected and the
+	// parking brake released. By default being in gear only holds driving mode once the vehicle has moved.
+	StationaryInGear bool
+}
+
+func (c *DrivingModeConfig) defaults() {
+	if c.EnterSpeed == 0 {
+		c.EnterSpeed = 8000
+	}
+	if c.ExitSpeed == 0 {
+		c.ExitSpeed = 3000
+	}
+	if c.ExitSpeed > c.EnterSpeed {
+		c.ExitSpeed = c.EnterSpeed
+	}
+	if c.EnterDebounce == 0 {
+		c.EnterDebounce = 500 * time.Millisecond
+	}
+	if c.ExitDebounce == 0 {
+		c.ExitDebounce = 3 * time.Second
+	}
+}
+
+// DrivingMode derives DrivingMode.Mode from VehicleSpeed, Transmission.Mode, ParkingBrake.Status and
+// VehiclePowerModeType. Speed is compared against separate enter and exit thresholds, and a change of mode
+// takes effect only after the inputs have called for it for the debounce time. A stopped vehicle stays in
+// driving mode while it is in gear, e.g. waiting at traffic lights, and leaves it at once when put in park,
+// switched off or held by the parking brake. Inputs that have not been seen yet are treated as unknown and do
+// not prevent driving mode; a moving vehicle is always in driving mode.
+//
+// The mode is evaluated when an input sample arrives, so a debounced change is emitted with the first input
+// sample after the debounce time has passed.
+type DrivingMode struct {
+	cfg DrivingModeConfig
+
+	speed  uint16
+	moving bool
+	gear   transmission_mode.TransmissionMode
+	brake  parking_braking_status.ParkingBrakeStatus
+	power  vehicle_power.VehiclePowerMode
+
+	mode debouncer
+}
+
+// NewDrivingMode returns a DrivingMode deriver.
+func NewDrivingMode(cfg DrivingModeConfig) *DrivingMode {
+	cfg.defaults()
+	return &DrivingMode{cfg: cfg}
+}
+
+// Mode returns the current driving mode.
+func (d *DrivingMode) Mode() bool {
+	return d.mode.out
+}
+
+// Update processes an input sample and returns a DrivingMode sample when the mode changes. The first input
+// sample also returns the initial mode.
+func (d *DrivingMode) Update(s vehicledata.Sample) []vehicledata.Sample {
+	switch v := s.Value.(type) {
+	case vehicledata.VehicleSpeed:
+		d.speed = v.Speed
+	case vehicledata.Transmission:
+		d.gear = v.Mode
+	case vehicledata.ParkingBrake:
+		d.brake = v.Status
+	case vehicledata.VehiclePowerModeType:
+		d.power = v.Value
+	default:
+		return nil
+	}
+	switch {
+	case d.speed >= d.cfg.EnterSpeed:
+		d.moving = true
+	case d.speed <= d.cfg.ExitSpeed:
+		d.moving = false
+	}
+	want := d.moving || ((d.mode.out || d.cfg.StationaryInGear) && d.inGear())
+
+	delay := d.cfg.ExitDebounce
+	switch {
+	case want:
+		delay = d.cfg.EnterDebounce
+	case d.parked():
+		delay = 0
+	}
+	if !d.mode.update(s.Time, want, delay) {
+		return nil
+	}
+	return []vehicledata.Sample{{Time: s.Time, Value: vehicledata.DrivingMode{Mode: d.mode.out}}}
+}
+
+// parked reports whether the stopped vehicle is in park, switched off or held by the parking brake.
+func (d *DrivingMode) parked() bool {
+	return d.gear == transmission_mode.Park || d.brake == parking_braking_status.Active ||
+		d.power != 0 && d.power != vehicle_power.Running
+}
+
+// inGear reports whether the stationary vehicle is ready to drive off.
+func (d *DrivingMode) inGear() bool {
+	if d.power != 0 && d.power != vehicle_power.Running {
+		return false
+	}
+	if d.brake == parking_braking_status.Active {
+		return false
+	}
+	switch d.gear {
+	case transmission_mode.Reverse, transmission_mode.Low, transmission_mode.Drive, transmission_mode.Overdrive:
+		return true
+	}
+	return false
+}
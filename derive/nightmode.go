@@ -0,0 +1,159 @@
+package derive
+
+import (
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+	"github.com/calvernaz/w3c-vehicle-data/solar"
+)
+
+// NightModeConfig configures the NightMode deriver. Zero values select the defaults noted on each field.
+type NightModeConfig struct {
+	// Vehicle location for the sun position; nil uses the Dusk and Dawn clock times until SetLocation is called
+	Location *solar.Location
+	// Sun elevation below which it is night (Unit: degrees, default -3)
+	NightElevation float64
+	// Sun elevation above which it is day again (Unit: degrees, default 0)
+	DayElevation float64
+	// Local time of day at which night starts and ends without a location (defaults 19:00 and 07:00)
+	Dusk, Dawn time.Duration
+	// Time zone of Dusk and Dawn (default time.Local)
+	TimeZone *time.Location
+	// Exterior brightness below which it is night (Unit: lux, default 50)
+	NightBrightness float64
+	// Exterior brightness above which it is day again (Unit: lux, default 200)
+	DayBrightness float64
+	// Brightness readings older than this are ignored (default 10 s)
+	BrightnessMaxAge time.Duration
+	// Time the inputs must call for a change before the mode follows (default 10 s, negative for none)
+	Debounce time.Duration
+}
+
+func (c *NightModeConfig) defaults() {
+	if c.NightElevation == 0 && c.DayElevation == 0 {
+		c.NightElevation, c.DayElevation = -3, 0
+	}
+	if c.DayElevation < c.NightElevation {
+		c.DayElevation = c.NightElevation
+	}
+	if c.Dusk == 0 && c.Dawn == 0 {
+		c.Dusk, c.Dawn = 19*time.Hour, 7*time.Hour
+	}
+	if c.TimeZone == nil {
+		c.TimeZone = time.Local
+	}
+	if c.NightBrightness == 0 && c.DayBrightness == 0 {
+		c.NightBrightness, c.DayBrightness = 50, 200
+	}
+	if c.DayBrightness < c.NightBrightness {
+		c.DayBrightness = c.NightBrightness
+	}
+	if c.BrightnessMaxAge == 0 {
+		c.BrightnessMaxAge = 10 * time.Second
+	}
+	if c.Debounce == 0 {
+		c.Debounce = 10 * time.Second
+	}
+}
+
+// NightMode derives NightMode.Mode from the best available input, in order of preference:
+//
+//  1. a recent exterior brightness reading, see SetBrightness
+//  2. the head lights while automatic head lights are activated, from LightStatus
+//  3. the sun elevation at the vehicle location, or the Dusk and Dawn times without a location
+//
+// Brightness and sun elevation switch at separate night and day thresholds, and a change of mode takes effect
+// only after the selected input has called for it for the debounce time. Every input sample is a clock tick:
+// the mode is evaluated at the time of each sample passed to Update.
+type NightMode struct {
+	cfg NightModeConfig
+	loc *solar.Location
+
+	brightness     float64
+	brightnessTime time.Time
+	darkOutside    bool
+	// head light state, when automatic head lights are on
+	autoLights, head bool
+	sunDown          bool
+
+	mode debouncer
+}
+
+// NewNightMode returns a NightMode deriver.
+func NewNightMode(cfg NightModeConfig) *NightMode {
+	cfg.defaults()
+	d := &NightMode{cfg: cfg}
+	if cfg.Location != nil {
+		loc := *cfg.Location
+		d.loc = &loc
+	}
+	return d
+}
+
+// Mode returns the current night mode.
+func (d *NightMode) Mode() bool {
+	return d.mode.out
+}
+
+// SetLocation updates the vehicle location, e.g. from a GNSS receiver.
+func (d *NightMode) SetLocation(loc solar.Location) {
+	d.loc = &loc
+}
+
+// SetBrightness records an exterior brightness reading taken at t (Unit: lux).
+func (d *NightMode) SetBrightness(t time.Time, lux float64) {
+	d.brightness, d.brightnessTime = lux, t
+	switch {
+	case lux < d.cfg.NightBrightness:
+		d.darkOutside = true
+	case lux > d.cfg.DayBrightness:
+		d.darkOutside = false
+	}
+}
+
+// Update processes a sample and returns a NightMode sample when the mode changes. The first sample also returns
+// the initial mode. LightStatus samples of any zone update the head light input; all samples advance the clock.
+func (d *NightMode) Update(s vehicledata.Sample) []vehicledata.Sample {
+	if v, ok := s.Value.(vehicledata.LightStatus); ok {
+		d.autoLights, d.head = v.AutomaticHeadLights, v.Head
+	}
+	if _, ok := s.Value.(vehicledata.NightMode); ok {
+		return nil
+	}
+	return d.Tick(s.Time)
+}
+
+// Tick evaluates the mode at time now and returns a NightMode sample when it changes.
+func (d *NightMode) Tick(now time.Time) []vehicledata.Sample {
+	if !d.mode.update(now, d.night(now), d.cfg.Debounce) {
+		return nil
+	}
+	return []vehicledata.Sample{{Time: now, Value: vehicledata.NightMode{Mode: d.mode.out}}}
+}
+
+// night returns what the preferred input says at time now.
+func (d *NightMode) night(now time.Time) bool {
+	if !d.brightnessTime.IsZero() && now.Sub(d.brightnessTime) <= d.cfg.BrightnessMaxAge {
+		return d.darkOutside
+	}
+	if d.autoLights {
+		return d.head
+	}
+	if d.loc == nil {
+		tod := now.In(d.cfg.TimeZone)
+		since := time.Duration(tod.Hour())*time.Hour + time.Duration(tod.Minute())*time.Minute +
+			time.Duration(tod.Second())*time.Second
+		if d.cfg.Dusk > d.cfg.Dawn {
+			return since >= d.cfg.Dusk || since < d.cfg.Dawn
+		}
+		return since >= d.cfg.Dusk && since < d.cfg.Dawn
+	}
+	elevation, _ := solar.Position(now, *d.loc)
+	switch {
+	case elevation < d.cfg.NightElevation:
+		d.sunDown = true
+	case elevation > d.cfg.DayElevation:
+		d.sunDown = false
+	}
+	return d.sunDown
+}
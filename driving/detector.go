@@ -0,0 +1,215 @@
+package driving
+
+import (
+	"math"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+	"github.com/calvernaz/w3c-vehicle-data/types/vehicle-type"
+)
+
+// A Detector detects harsh driving events. It is not safe for concurrent use.
+type Detector struct {
+	cfg Config
+	th  Thresholds
+	// speed limit set with SetSpeedLimit, overriding the threshold
+	limit    float64
+	hasLimit bool
+
+	speed, prevSpeed         float64
+	speedTime, prevSpeedTime time.Time
+	accelTime                time.Time
+	yaw                      float64
+	yawTime                  time.Time
+	braked                   bool
+
+	braking, accelerating, cornering, speeding episode
+	lane                                       laneChange
+}
+
+// An episode tracks a value above a threshold.
+type episode struct {
+	active     bool
+	start, end time.Time
+	peak       float64
+	speed      float64
+	braked     bool
+}
+
+// feed updates the episode with value x at time t and reports whether an episode ended.
+func (p *episode) feed(t time.Time, x, enter, exit, speed float64, braked bool) bool {
+	if !p.active {
+		if x >= enter {
+			*p = episode{active: true, start: t, end: t, peak: x, speed: speed, braked: braked}
+		}
+		return false
+	}
+	if x >= exit {
+		p.end, p.peak, p.braked = t, math.Max(p.peak, x), p.braked || braked
+		return false
+	}
+	p.active, p.end = false, t
+	return true
+}
+
+// A laneChange tracks the two opposite swerves of a lane change.
+type laneChange struct {
+	// 0 waiting, 1 in the first swerve, 2 in the second swerve
+	phase int
+	sign  float64
+	start time.Time
+	// peaks of the two swerves
+	peak1, peak2 float64
+	speed        float64
+	braked       bool
+}
+
+// NewDetector returns a detector.
+func NewDetector(cfg Config) *Detector {
+	cfg.defaults()
+	d := &Detector{cfg: cfg}
+	d.th = d.thresholds(cfg.VehicleType)
+	return d
+}
+
+func (d *Detector) thresholds(t vehicle_type.VehicleType) Thresholds {
+	if th, ok := d.cfg.Thresholds[t]; ok {
+		return th
+	}
+	return DefaultThresholds(t)
+}
+
+// SetSpeedLimit sets the speed limit of the current road, e.g. from map data (Unit: meters per second); 0
+// disables speeding events. VehicleSpeed saturates at 65.535 km/h, so higher limits are never exceeded.
+func (d *Detector) SetSpeedLimit(limit float64) {
+	d.limit, d.hasLimit = limit, true
+}
+
+// SpeedLimit returns the speed limit in effect (Unit: meters per second), 0 while speeding is off.
+func (d *Detector) SpeedLimit() float64 {
+	if d.hasLimit {
+		return d.limit
+	}
+	return d.th.SpeedLimit
+}
+
+// Update processes a sample and returns the events it completes.
+func (d *Detector) Update(s vehicledata.Sample) []Event {
+	t := s.Time
+	var events []Event
+	switch v := s.Value.(type) {
+	case vehicledata.Identification:
+		if v.VehicleType != 0 {
+			d.th = d.thresholds(v.VehicleType)
+		}
+	case vehicledata.BrakeOperation:
+		d.braked = v.BrakePedalDepressed
+	case vehicledata.Acceleration:
+		d.accelTime = t
+		events = d.longitudinal(t, float64(v.X)/100, events)
+		events = d.lateral(t, math.Abs(float64(v.Y))/100, events)
+	case vehicledata.VehicleSpeed:
+		d.prevSpeed, d.prevSpeedTime = d.speed, d.speedTime
+		d.speed, d.speedTime = float64(v.Speed)/3600, t
+		if !d.fresh(d.accelTime, t) && d.fresh(d.prevSpeedTime, t) && t.After(d.prevSpeedTime) {
+			events = d.longitudinal(t, (d.speed-d.prevSpeed)/t.Sub(d.prevSpeedTime).Seconds(), events)
+		}
+		events = d.speedLimit(t, events)
+	case vehicledata.YawRate:
+		d.yaw, d.yawTime = float64(v.Value), t
+		if !d.fresh(d.accelTime, t) && d.fresh(d.speedTime, t) {
+			events = d.lateral(t, math.Abs(d.speed*d.yaw*math.Pi/180), events)
+		}
+		events = d.swerve(t, d.yaw, d.th.LaneChangeYawRate, events)
+	case vehicledata.SteeringWheel:
+		if !d.fresh(d.yawTime, t) {
+			events = d.swerve(t, float64(v.Angle), d.th.LaneChangeSteering, events)
+		}
+	}
+	return events
+}
+
+func (d *Detector) fresh(at, now time.Time) bool {
+	return !at.IsZero() && now.Sub(at) <= d.cfg.MaxAge
+}
+
+// longitudinal evaluates braking and acceleration from the longitudinal acceleration a.
+func (d *Detector) longitudinal(t time.Time, a float64, events []Event) []Event {
+	events = d.finish(t, &d.braking, HarshBraking, -a, d.th.Braking, d.cfg.MinDuration, events)
+	return d.finish(t, &d.accelerating, HarshAcceleration, a, d.th.Acceleration, d.cfg.MinDuration, events)
+}
+
+// lateral evaluates cornering from the lateral acceleration magnitude a.
+func (d *Detector) lateral(t time.Time, a float64, events []Event) []Event {
+	return d.finish(t, &d.cornering, HarshCornering, a, d.th.Cornering, d.cfg.MinDuration, events)
+}
+
+func (d *Detector) speedLimit(t time.Time, events []Event) []Event {
+	limit := d.SpeedLimit()
+	if limit <= 0 {
+		// an ongoing episode ends with the limit
+		return d.finish(t, &d.speeding, Speeding, math.Inf(-1), d.th.Speeding, d.cfg.MinSpeedingDuration, events)
+	}
+	return d.finish(t, &d.speeding, Speeding, d.speed-limit, d.th.Speeding, d.cfg.MinSpeedingDuration, events)
+}
+
+// finish feeds x to an episode and appends its event if it ended long and strong enough.
+func (d *Detector) finish(t time.Time, p *episode, kind Kind, x float64, l Levels, min time.Duration, events []Event) []Event {
+	enter := l[0]
+	exit := enter * d.cfg.Release
+	if kind == Speeding {
+		// speeding starts above the limit and ends at it
+		enter, exit = math.Max(enter, 1e-9), 0
+	}
+	if !p.feed(t, x, enter, exit, d.speed, d.braked) {
+		return events
+	}
+	sev := l.severity(p.peak)
+	if p.end.Sub(p.start) < min || sev == 0 {
+		return events
+	}
+	return append(events, Event{
+		Kind: kind, Severity: sev, Start: p.start, End: p.end, Peak: p.peak, Speed: p.speed, Braked: p.braked,
+	})
+}
+
+// swerve feeds a yaw rate or steering angle x to the lane change detector.
+func (d *Detector) swerve(t time.Time, x float64, l Levels, events []Event) []Event {
+	lc := &d.lane
+	low := l[0]
+	ax := math.Abs(x)
+	sign := math.Copysign(1, x)
+	if lc.phase == 1 && t.Sub(lc.start) > d.cfg.LaneChangeWindow {
+		// a single long swerve is a turn
+		lc.phase = 0
+	}
+	switch lc.phase {
+	case 0:
+		if ax >= low && d.speed >= d.th.LaneChangeSpeed {
+			*lc = laneChange{phase: 1, sign: sign, start: t, peak1: ax, speed: d.speed, braked: d.braked}
+		}
+	case 1:
+		switch {
+		case sign == lc.sign:
+			lc.peak1 = math.Max(lc.peak1, ax)
+		case ax >= low:
+			lc.phase, lc.peak2 = 2, ax
+		}
+	case 2:
+		if sign != lc.sign && ax >= low*d.cfg.Release {
+			lc.peak2 = math.Max(lc.peak2, ax)
+			break
+		}
+		lc.phase = 0
+		// the weaker swerve grades the maneuver
+		peak := math.Min(lc.peak1, lc.peak2)
+		if sev := l.severity(peak); sev > 0 {
+			events = append(events, Event{
+				Kind: LaneChange, Severity: sev, Start: lc.start, End: t, Peak: math.Max(lc.peak1, lc.peak2),
+				Speed: lc.speed, Braked: lc.braked || d.braked,
+			})
+		}
+	}
+	d.lane.braked = d.lane.braked || d.braked
+	return events
+}
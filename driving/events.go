@@ -0,0 +1,190 @@
+// Package driving detects harsh driving events for driver coaching: harsh braking, harsh acceleration, harsh
+// cornering, rapid lane changes and speeding.
+//
+// The longitudinal and lateral accelerations come from Acceleration; without fresh Acceleration samples they
+// are computed from the change of VehicleSpeed and from YawRate at the current speed. Lane changes are
+// detected as a swerve of YawRate, or of SteeringWheel.Angle without yaw rate, first to one side and then to
+// the other. Thresholds depend on the VehicleType of the Identification samples.
+//
+// Speeding is off by default: the default thresholds carry no speed limit, so no speeding events are reported
+// until a limit is set with Thresholds.SpeedLimit or Detector.SetSpeedLimit. VehicleSpeed saturates at
+// 65.535 km/h (about 18.2 m/s), so limits at or above that speed are never exceeded.
+//
+// Example:
+//
+//	d := driving.NewDetector(driving.Config{})
+//	for smp := range samples {
+//		for _, e := range d.Update(smp) {
+//			log.Printf("%v from %v to %v, peak %.1f, %v", e.Kind, e.Start, e.End, e.Peak, e.Severity)
+//		}
+//	}
+package driving
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data/types/vehicle-type"
+)
+
+// Kind is the kind of a driving event.
+type Kind int
+
+const (
+	HarshBraking Kind = iota + 1
+	HarshAcceleration
+	HarshCornering
+	LaneChange
+	Speeding
+)
+
+func (k Kind) String() string {
+	switch k {
+	case HarshBraking:
+		return "harsh braking"
+	case HarshAcceleration:
+		return "harsh acceleration"
+	case HarshCornering:
+		return "harsh cornering"
+	case LaneChange:
+		return "lane change"
+	case Speeding:
+		return "speeding"
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Severity grades an event.
+type Severity int
+
+const (
+	Low Severity = iota + 1
+	Medium
+	High
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Low:
+		return "low"
+	case Medium:
+		return "medium"
+	case High:
+		return "high"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// An Event is a harsh driving episode.
+type Event struct {
+	Kind     Kind
+	Severity Severity
+	// Time span of the episode
+	Start, End time.Time
+	// Peak value: acceleration magnitude for braking, acceleration and cornering (Unit: meters per second
+	// squared), yaw rate or steering angle for lane changes (Unit: degrees per second or degrees), speed above
+	// the limit for speeding (Unit: meters per second)
+	Peak float64
+	// Speed at the start of the episode (Unit: meters per second)
+	Speed float64
+	// True if the brake pedal was depressed during the episode
+	Braked bool
+}
+
+// Duration returns the length of the episode.
+func (e Event) Duration() time.Duration {
+	return e.End.Sub(e.Start)
+}
+
+// Levels are the thresholds of the low, medium and high severities.
+type Levels [3]float64
+
+// severity returns the severity of a peak, 0 below the low threshold.
+func (l Levels) severity(peak float64) Severity {
+	for i := len(l) - 1; i >= 0; i-- {
+		if peak >= l[i] {
+			return Severity(i + 1)
+		}
+	}
+	return 0
+}
+
+// Thresholds are the event thresholds for one vehicle type.
+type Thresholds struct {
+	// Deceleration, acceleration and lateral acceleration (Unit: meters per second squared)
+	Braking, Acceleration, Cornering Levels
+	// Yaw rate of each swerve of a lane change (Unit: degrees per second)
+	LaneChangeYawRate Levels
+	// Steering wheel angle of each swerve of a lane change, used without yaw rate (Unit: degrees)
+	LaneChangeSteering Levels
+	// Minimum speed for lane changes (Unit: meters per second)
+	LaneChangeSpeed float64
+	// Speed limit; 0 disables speeding until SetSpeedLimit is called (Unit: meters per second)
+	SpeedLimit float64
+	// Speed above the limit (Unit: meters per second)
+	Speeding Levels
+}
+
+// DefaultThresholds returns the thresholds for a vehicle type. Vehicles with a high center of gravity get lower
+// acceleration thresholds.
+func DefaultThresholds(t vehicle_type.VehicleType) Thresholds {
+	th := Thresholds{
+		Braking:            Levels{3.5, 4.5, 6},
+		Acceleration:       Levels{3, 3.8, 4.5},
+		Cornering:          Levels{3.5, 4.5, 5.5},
+		LaneChangeYawRate:  Levels{6, 9, 12},
+		LaneChangeSteering: Levels{25, 40, 60},
+		LaneChangeSpeed:    50 / 3.6,
+		Speeding:           Levels{0, 10 / 3.6, 20 / 3.6},
+	}
+	switch t {
+	case vehicle_type.SportUtilityVehicle, vehicle_type.PickupTruck:
+		th.Braking = Levels{3, 4, 5.5}
+		th.Acceleration = Levels{2.5, 3.3, 4}
+		th.Cornering = Levels{3, 3.8, 4.5}
+		th.LaneChangeYawRate = Levels{5, 7.5, 10}
+	case vehicle_type.Van:
+		th.Braking = Levels{2.8, 3.8, 5}
+		th.Acceleration = Levels{2.2, 3, 3.6}
+		th.Cornering = Levels{2.5, 3.2, 4}
+		th.LaneChangeYawRate = Levels{4, 6, 8}
+	}
+	return th
+}
+
+// Config configures a detector. Zero values select the defaults noted on each field.
+type Config struct {
+	// Vehicle type until an Identification sample arrives (default PassengerCarMedium)
+	VehicleType vehicle_type.VehicleType
+	// Thresholds by vehicle type, overriding DefaultThresholds
+	Thresholds map[vehicle_type.VehicleType]Thresholds
+	// Minimum duration of acceleration events (default 300 ms) and of speeding (default 5 s)
+	MinDuration, MinSpeedingDuration time.Duration
+	// An episode ends when its value falls below this share of the low threshold (default 0.8)
+	Release float64
+	// Maximum time between the two swerves of a lane change (default 3 s)
+	LaneChangeWindow time.Duration
+	// Samples older than this are not used (default 1 s)
+	MaxAge time.Duration
+}
+
+func (c *Config) defaults() {
+	if c.VehicleType == 0 {
+		c.VehicleType = vehicle_type.PassengerCarMedium
+	}
+	if c.MinDuration <= 0 {
+		c.MinDuration = 300 * time.Millisecond
+	}
+	if c.MinSpeedingDuration <= 0 {
+		c.MinSpeedingDuration = 5 * time.Second
+	}
+	if c.Release <= 0 || c.Release > 1 {
+		c.Release = 0.8
+	}
+	if c.LaneChangeWindow <= 0 {
+		c.LaneChangeWindow = 3 * time.Second
+	}
+	if c.MaxAge <= 0 {
+		c.MaxAge = time.Second
+	}
+}
@@ -0,0 +1,282 @@
+// Package ecall builds the minimum set of data (MSD) of an eCall, the emergency call placed automatically by a
+// vehicle after a crash, as defined by EN 15722.
+//
+// A Builder collects the vehicle data that goes into the MSD (Identification, FuelConfiguration and Seat
+// occupancy) from the sample stream and combines it with the position of the vehicle:
+//
+//	b := ecall.NewBuilder()
+//	for smp := range samples {
+//		b.Update(smp)
+//	}
+//	b.SetPosition(ecall.Position{Latitude: 48.1372, Longitude: 11.5756, Heading: 90, Trusted: true})
+//	msd := b.Build(time.Now(), true)
+//	data, err := ecall.Encode(msd)
+package ecall
+
+import (
+	"strings"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+	"github.com/calvernaz/w3c-vehicle-data/types/fuel-type"
+	"github.com/calvernaz/w3c-vehicle-data/types/occupant-status"
+	"github.com/calvernaz/w3c-vehicle-data/types/vehicle-type"
+)
+
+// VehicleClass is the vehicle type of the MSD, the vehicle category of UNECE R.E.3.
+type VehicleClass int
+
+const (
+	PassengerVehicleClassM1 VehicleClass = iota + 1
+	BusesAndCoachesClassM2
+	BusesAndCoachesClassM3
+	LightCommercialVehiclesClassN1
+	HeavyDutyVehiclesClassN2
+	HeavyDutyVehiclesClassN3
+	MotorcyclesClassL1e
+	MotorcyclesClassL2e
+	MotorcyclesClassL3e
+	MotorcyclesClassL4e
+	MotorcyclesClassL5e
+	MotorcyclesClassL6e
+	MotorcyclesClassL7e
+)
+
+// Control is the control block of the MSD.
+type Control struct {
+	// Call triggered by the vehicle (true) or by an occupant (false)
+	AutomaticActivation bool
+	// Test call
+	TestCall bool
+	// The position is reliable
+	PositionCanBeTrusted bool
+	VehicleType          VehicleClass
+}
+
+// VIN is a vehicle identification number split into its ISO 3779 parts.
+type VIN struct {
+	// World manufacturer identifier, 3 characters
+	WMI string
+	// Vehicle descriptor section, 6 characters
+	VDS string
+	// Model year, 1 character
+	ModelYear string
+	// Plant and sequential number, 7 characters
+	SeqPlant string
+}
+
+// ParseVIN splits a 17 character VIN. Missing characters are padded with '0'.
+func ParseVIN(s string) VIN {
+	s = strings.ToUpper(s)
+	if len(s) < 17 {
+		s += strings.Repeat("0", 17-len(s))
+	}
+	return VIN{WMI: s[0:3], VDS: s[3:9], ModelYear: s[9:10], SeqPlant: s[10:17]}
+}
+
+// String returns the 17 character VIN.
+func (v VIN) String() string {
+	return v.WMI + v.VDS + v.ModelYear + v.SeqPlant
+}
+
+// Propulsion lists the energy storage types present in the vehicle.
+type Propulsion struct {
+	GasolineTank, DieselTank, CompressedNaturalGas, LiquidPropaneGas, ElectricEnergyStorage, HydrogenStorage,
+	Other bool
+}
+
+// Position is a WGS 84 vehicle position.
+type Position struct {
+	// Latitude and longitude, north and east positive (Unit: degrees)
+	Latitude, Longitude float64
+	// Direction of travel clockwise from true north (Unit: degrees)
+	Heading float64
+	// The position is reliable
+	Trusted bool
+}
+
+// Location is a position in MSD units.
+type Location struct {
+	// Latitude and longitude (Unit: milliarcseconds); InvalidCoordinate if unknown
+	Latitude, Longitude int32
+}
+
+// InvalidCoordinate marks an unknown latitude or longitude.
+const InvalidCoordinate = 2147483647
+
+// UnknownDirection marks an unknown vehicle direction.
+const UnknownDirection = 255
+
+// Delta is a recent position relative to the previous one.
+type Delta struct {
+	// Latitude and longitude offsets (Unit: 100 milliarcseconds, range -512 to 511)
+	Latitude, Longitude int16
+}
+
+// MSD is the minimum set of data of an eCall, version 2 of EN 15722.
+type MSD struct {
+	MessageIdentifier uint8
+	Control           Control
+	VIN               VIN
+	Propulsion        Propulsion
+	// Time of the incident (Unit: seconds since 1970-01-01 UTC)
+	Timestamp uint32
+	Location  Location
+	// Direction of travel (Unit: 2 degrees clockwise from north, 0 to 179); UnknownDirection if unknown
+	Direction uint8
+	// Recent positions before the incident, optional
+	RecentLocation1, RecentLocation2 *Delta
+	// Number of occupants, optional
+	Passengers *uint8
+	// Additional data: object identifier and encoded content, optional
+	AdditionalOID  string
+	AdditionalData []byte
+}
+
+// A Builder collects the vehicle data of the MSD. It is not safe for concurrent use.
+type Builder struct {
+	id         vehicledata.Identification
+	fuel       vehicledata.FuelConfiguration
+	seats      map[string]vehicledata.Seat
+	pos        *Position
+	recent     []Position
+	messageID  uint8
+	hasSeats   bool
+	hasFuelCfg bool
+}
+
+// NewBuilder returns a builder.
+func NewBuilder() *Builder {
+	return &Builder{seats: map[string]vehicledata.Seat{}}
+}
+
+// Update records Identification, FuelConfiguration and Seat samples.
+func (b *Builder) Update(s vehicledata.Sample) {
+	switch v := s.Value.(type) {
+	case vehicledata.Identification:
+		b.id = v
+	case vehicledata.FuelConfiguration:
+		b.fuel, b.hasFuelCfg = v, true
+	case vehicledata.Seat:
+		b.seats[v.Zone.String()], b.hasSeats = v, true
+	}
+}
+
+// SetPosition records the current position. The two previous positions become the recent locations of the MSD.
+func (b *Builder) SetPosition(p Position) {
+	if b.pos != nil {
+		b.recent = append([]Position{*b.pos}, b.recent...)
+		if len(b.recent) > 2 {
+			b.recent = b.recent[:2]
+		}
+	}
+	b.pos = &p
+}
+
+// Build returns the MSD of an incident at time t. Every call uses the next message identifier, starting at 1.
+func (b *Builder) Build(t time.Time, automatic bool) MSD {
+	b.messageID++
+	m := MSD{
+		MessageIdentifier: b.messageID,
+		Control: Control{
+			AutomaticActivation: automatic,
+			VehicleType:         vehicleClass(b.id.VehicleType),
+		},
+		VIN:       ParseVIN(b.id.VIN),
+		Timestamp: uint32(t.Unix()),
+		Location:  Location{Latitude: InvalidCoordinate, Longitude: InvalidCoordinate},
+		Direction: UnknownDirection,
+	}
+	if b.id.VIN == "" && b.id.WMI != "" {
+		m.VIN = ParseVIN(b.id.WMI)
+	}
+	if b.hasFuelCfg {
+		m.Propulsion = propulsion(b.fuel.FuelType)
+	}
+	if b.pos != nil {
+		p := *b.pos
+		m.Control.PositionCanBeTrusted = p.Trusted
+		m.Location = Location{Latitude: mas(p.Latitude), Longitude: mas(p.Longitude)}
+		h := p.Heading
+		for h < 0 {
+			h += 360
+		}
+		m.Direction = uint8(int(h+1) / 2 % 180)
+		prev := p
+		for i, r := range b.recent {
+			d := &Delta{
+				Latitude:  clampDelta((mas(r.Latitude) - mas(prev.Latitude)) / 100),
+				Longitude: clampDelta((mas(r.Longitude) - mas(prev.Longitude)) / 100),
+			}
+			if i == 0 {
+				m.RecentLocation1 = d
+			} else {
+				m.RecentLocation2 = d
+			}
+			prev = r
+		}
+	}
+	if b.hasSeats {
+		var n uint8
+		for _, s := range b.seats {
+			// an occupied seat, or a fastened belt where occupancy is unknown
+			switch {
+			case s.Occupant == occupant_status.Adult, s.Occupant == occupant_status.Child:
+				n++
+			case s.Occupant == 0 && s.SeatBelt:
+				n++
+			}
+		}
+		m.Passengers = &n
+	}
+	return m
+}
+
+// vehicleClass maps a vehicle type to its vehicle category. Unknown types are passenger cars.
+func vehicleClass(t vehicle_type.VehicleType) VehicleClass {
+	switch t {
+	case vehicle_type.PickupTruck, vehicle_type.Van:
+		return LightCommercialVehiclesClassN1
+	}
+	return PassengerVehicleClassM1
+}
+
+func propulsion(types []fuel_type.FuelType) Propulsion {
+	var p Propulsion
+	for _, t := range types {
+		switch t {
+		case fuel_type.Gasoline:
+			p.GasolineTank = true
+		case fuel_type.Diesel:
+			p.DieselTank = true
+		case fuel_type.CNG:
+			p.CompressedNaturalGas = true
+		case fuel_type.LPG:
+			p.LiquidPropaneGas = true
+		case fuel_type.Electric:
+			p.ElectricEnergyStorage = true
+		default:
+			p.Other = true
+		}
+	}
+	return p
+}
+
+// mas converts degrees to milliarcseconds.
+func mas(deg float64) int32 {
+	x := deg * 3600000
+	if x < 0 {
+		return int32(x - 0.5)
+	}
+	return int32(x + 0.5)
+}
+
+func clampDelta(x int32) int16 {
+	switch {
+	case x < -512:
+		return -512
+	case x > 511:
+		return 511
+	}
+	return int16(x)
+}
@@ -0,0 +1,406 @@
+package ecall
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is the MSD format version written by Encode, the id of the ECallMessage.
+const Version = 2
+
+// ErrFormat is returned by Decode for data that is not a valid encoded MSD.
+var ErrFormat = errors.New("ecall: malformed MSD")
+
+// Encode encodes an MSD as an ECallMessage in ASN.1 unaligned PER, the transmission format of EN 15722. The
+// encoding follows this definition of version 2 of the MSD module:
+//
+//	ECallMessage ::= SEQUENCE {
+//		id  INTEGER(0..255),
+//		msd OCTET STRING (CONTAINING MSDMessage)
+//	}
+//	MSDMessage ::= SEQUENCE {
+//		msdStructure           MSDStructure,
+//		optionalAdditionalData AdditionalData OPTIONAL,
+//		...
+//	}
+//	MSDStructure ::= SEQUENCE {
+//		messageIdentifier            INTEGER(0..255),
+//		control                      ControlType,
+//		vehicleIdentificationNumber  VIN,
+//		vehiclePropulsionStorageType VehiclePropulsionStorageType,
+//		timestamp                    INTEGER(0..4294967295),
+//		vehicleLocation              VehicleLocation,
+//		vehicleDirection             INTEGER(0..255),
+//		recentVehicleLocationN1      VehicleLocationDelta OPTIONAL,
+//		recentVehicleLocationN2      VehicleLocationDelta OPTIONAL,
+//		numberOfPassengers           INTEGER(0..255) OPTIONAL,
+//		...
+//	}
+//	ControlType ::= SEQUENCE {
+//		automaticActivation, testCall, positionCanBeTrusted BOOLEAN,
+//		vehicleType VehicleType
+//	}
+//	VehicleType ::= ENUMERATED { passengerVehicleClassM1(1), ..., motorcyclesClassL7e(13), ... }
+//	VIN ::= SEQUENCE {
+//		isowmi PrintableString (SIZE(3)), isovds PrintableString (SIZE(6)),
+//		isovisModelyear PrintableString (SIZE(1)), isovisSeqPlant PrintableString (SIZE(7))
+//	} -- each FROM("A".."H"|"J".."N"|"P"|"R".."Z"|"0".."9")
+//	VehiclePropulsionStorageType ::= SEQUENCE {
+//		gasolineTankPresent, dieselTankPresent, compressedNaturalGas, liquidPropaneGas,
+//		electricEnergyStorage, hydrogenStorage, otherStorage BOOLEAN DEFAULT FALSE,
+//		...
+//	}
+//	VehicleLocation ::= SEQUENCE {
+//		positionLatitude, positionLongitude INTEGER(-2147483648..2147483647)
+//	}
+//	VehicleLocationDelta ::= SEQUENCE {
+//		latitudeDelta, longitudeDelta INTEGER(-512..511)
+//	}
+//	AdditionalData ::= SEQUENCE { oid RELATIVE-OID, data OCTET STRING }
+func Encode(m MSD) ([]byte, error) {
+	var w bitWriter
+	if err := encodeMessage(&w, m); err != nil {
+		return nil, err
+	}
+	msd := w.bytes()
+	var out bitWriter
+	out.uint(Version, 8)
+	if err := out.length(len(msd)); err != nil {
+		return nil, err
+	}
+	out.octets(msd)
+	return out.bytes(), nil
+}
+
+// Decode decodes an ECallMessage written by Encode. Messages with extension additions are rejected.
+func Decode(b []byte) (MSD, error) {
+	r := bitReader{b: b}
+	if id := r.uint(8); id != Version {
+		if r.err != nil {
+			return MSD{}, ErrFormat
+		}
+		return MSD{}, fmt.Errorf("ecall: unsupported MSD version %d", id)
+	}
+	n := r.length()
+	inner := r.octets(n)
+	if r.err != nil {
+		return MSD{}, ErrFormat
+	}
+	ir := bitReader{b: inner}
+	m, err := decodeMessage(&ir)
+	if err != nil {
+		return MSD{}, err
+	}
+	if ir.err != nil {
+		return MSD{}, ErrFormat
+	}
+	return m, nil
+}
+
+func encodeMessage(w *bitWriter, m MSD) error {
+	hasAdditional := m.AdditionalOID != ""
+	// MSDMessage: extension bit, presence of optionalAdditionalData
+	w.bool(false)
+	w.bool(hasAdditional)
+
+	// MSDStructure: extension bit, presence of the optional components
+	w.bool(false)
+	w.bool(m.RecentLocation1 != nil)
+	w.bool(m.RecentLocation2 != nil)
+	w.bool(m.Passengers != nil)
+	w.uint(uint64(m.MessageIdentifier), 8)
+
+	c := m.Control
+	w.bool(c.AutomaticActivation)
+	w.bool(c.TestCall)
+	w.bool(c.PositionCanBeTrusted)
+	if c.VehicleType < PassengerVehicleClassM1 || c.VehicleType > MotorcyclesClassL7e {
+		return fmt.Errorf("ecall: invalid vehicle type %d", c.VehicleType)
+	}
+	// extensible enumeration: extension bit, index of the value in the root
+	w.bool(false)
+	w.uint(uint64(c.VehicleType-PassengerVehicleClassM1), 4)
+
+	for _, part := range []struct {
+		s    string
+		size int
+	}{{m.VIN.WMI, 3}, {m.VIN.VDS, 6}, {m.VIN.ModelYear, 1}, {m.VIN.SeqPlant, 7}} {
+		if len(part.s) != part.size {
+			return fmt.Errorf("ecall: invalid VIN %q", m.VIN.String())
+		}
+		for i := 0; i < len(part.s); i++ {
+			x := strings.IndexByte(vinAlphabet, part.s[i])
+			if x < 0 {
+				return fmt.Errorf("ecall: invalid VIN character %q", part.s[i])
+			}
+			w.uint(uint64(x), 6)
+		}
+	}
+
+	p := m.Propulsion
+	flags := []bool{p.GasolineTank, p.DieselTank, p.CompressedNaturalGas, p.LiquidPropaneGas,
+		p.ElectricEnergyStorage, p.HydrogenStorage, p.Other}
+	// extension bit, presence bits of the DEFAULT FALSE components, then the present (true) values
+	w.bool(false)
+	for _, f := range flags {
+		w.bool(f)
+	}
+	for _, f := range flags {
+		if f {
+			w.bool(true)
+		}
+	}
+
+	w.uint(uint64(m.Timestamp), 32)
+	w.uint(uint64(int64(m.Location.Latitude)+1<<31), 32)
+	w.uint(uint64(int64(m.Location.Longitude)+1<<31), 32)
+	w.uint(uint64(m.Direction), 8)
+	for _, d := range []*Delta{m.RecentLocation1, m.RecentLocation2} {
+		if d == nil {
+			continue
+		}
+		if d.Latitude < -512 || d.Latitude > 511 || d.Longitude < -512 || d.Longitude > 511 {
+			return fmt.Errorf("ecall: location delta out of range")
+		}
+		w.uint(uint64(d.Latitude+512), 10)
+		w.uint(uint64(d.Longitude+512), 10)
+	}
+	if m.Passengers != nil {
+		w.uint(uint64(*m.Passengers), 8)
+	}
+
+	if hasAdditional {
+		oid, err := relativeOID(m.AdditionalOID)
+		if err != nil {
+			return err
+		}
+		for _, b := range [][]byte{oid, m.AdditionalData} {
+			if err := w.length(len(b)); err != nil {
+				return err
+			}
+			w.octets(b)
+		}
+	}
+	return nil
+}
+
+func decodeMessage(r *bitReader) (MSD, error) {
+	var m MSD
+	if r.bool() {
+		return MSD{}, fmt.Errorf("ecall: MSD extensions are not supported")
+	}
+	hasAdditional := r.bool()
+	if r.bool() {
+		return MSD{}, fmt.Errorf("ecall: MSD extensions are not supported")
+	}
+	has1, has2, hasPassengers := r.bool(), r.bool(), r.bool()
+	m.MessageIdentifier = uint8(r.uint(8))
+
+	m.Control.AutomaticActivation = r.bool()
+	m.Control.TestCall = r.bool()
+	m.Control.PositionCanBeTrusted = r.bool()
+	if r.bool() {
+		return MSD{}, fmt.Errorf("ecall: unknown vehicle type extension")
+	}
+	m.Control.VehicleType = VehicleClass(r.uint(4)) + PassengerVehicleClassM1
+	if m.Control.VehicleType > MotorcyclesClassL7e {
+		return MSD{}, ErrFormat
+	}
+
+	vin := make([]byte, 17)
+	for i := range vin {
+		x := r.uint(6)
+		if x >= uint64(len(vinAlphabet)) {
+			return MSD{}, ErrFormat
+		}
+		vin[i] = vinAlphabet[x]
+	}
+	m.VIN = ParseVIN(string(vin))
+
+	if r.bool() {
+		return MSD{}, fmt.Errorf("ecall: MSD extensions are not supported")
+	}
+	var present [7]bool
+	for i := range present {
+		present[i] = r.bool()
+	}
+	var flags [7]bool
+	for i := range flags {
+		if present[i] {
+			flags[i] = r.bool()
+		}
+	}
+	m.Propulsion = Propulsion{flags[0], flags[1], flags[2], flags[3], flags[4], flags[5], flags[6]}
+
+	m.Timestamp = uint32(r.uint(32))
+	m.Location.Latitude = int32(int64(r.uint(32)) - 1<<31)
+	m.Location.Longitude = int32(int64(r.uint(32)) - 1<<31)
+	m.Direction = uint8(r.uint(8))
+	delta := func() *Delta {
+		return &Delta{Latitude: int16(r.uint(10)) - 512, Longitude: int16(r.uint(10)) - 512}
+	}
+	if has1 {
+		m.RecentLocation1 = delta()
+	}
+	if has2 {
+		m.RecentLocation2 = delta()
+	}
+	if hasPassengers {
+		n := uint8(r.uint(8))
+		m.Passengers = &n
+	}
+
+	if hasAdditional {
+		oid := r.octets(r.length())
+		m.AdditionalData = r.octets(r.length())
+		if r.err != nil {
+			return MSD{}, ErrFormat
+		}
+		s, err := parseRelativeOID(oid)
+		if err != nil {
+			return MSD{}, err
+		}
+		m.AdditionalOID = s
+	}
+	return m, nil
+}
+
+// vinAlphabet is the permitted alphabet of the VIN strings in canonical order; characters are encoded as their
+// index in 6 bits.
+const vinAlphabet = "0123456789ABCDEFGHJKLMNPRSTUVWXYZ"
+
+// relativeOID returns the contents octets of a relative object identifier such as "4.1".
+func relativeOID(s string) ([]byte, error) {
+	var out []byte
+	for _, part := range strings.Split(s, ".") {
+		x, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("ecall: invalid object identifier %q", s)
+		}
+		var rev []byte
+		rev = append(rev, byte(x&0x7f))
+		for x >>= 7; x > 0; x >>= 7 {
+			rev = append(rev, byte(x&0x7f)|0x80)
+		}
+		for i := len(rev) - 1; i >= 0; i-- {
+			out = append(out, rev[i])
+		}
+	}
+	return out, nil
+}
+
+func parseRelativeOID(b []byte) (string, error) {
+	var parts []string
+	var x uint64
+	for i, c := range b {
+		x = x<<7 | uint64(c&0x7f)
+		if c&0x80 == 0 {
+			parts = append(parts, strconv.FormatUint(x, 10))
+			x = 0
+		} else if i == len(b)-1 || x > 1<<32 {
+			return "", ErrFormat
+		}
+	}
+	return strings.Join(parts, "."), nil
+}
+
+// A bitWriter writes a big-endian bit stream.
+type bitWriter struct {
+	buf []byte
+	n   int
+}
+
+func (w *bitWriter) bool(b bool) {
+	if b {
+		w.uint(1, 1)
+	} else {
+		w.uint(0, 1)
+	}
+}
+
+// uint writes the low n bits of x.
+func (w *bitWriter) uint(x uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		if x>>uint(i)&1 == 1 {
+			w.buf[w.n/8] |= 0x80 >> uint(w.n%8)
+		}
+		w.n++
+	}
+}
+
+// length writes an unconstrained length determinant.
+func (w *bitWriter) length(n int) error {
+	switch {
+	case n < 128:
+		w.uint(uint64(n), 8)
+	case n < 16384:
+		w.uint(2, 2)
+		w.uint(uint64(n), 14)
+	default:
+		return fmt.Errorf("ecall: %d octets is too long", n)
+	}
+	return nil
+}
+
+func (w *bitWriter) octets(b []byte) {
+	for _, c := range b {
+		w.uint(uint64(c), 8)
+	}
+}
+
+// bytes returns the stream padded with zero bits to a whole octet.
+func (w *bitWriter) bytes() []byte {
+	return w.buf
+}
+
+// A bitReader reads a big-endian bit stream. Reading past the end sets err and returns zero bits.
+type bitReader struct {
+	b   []byte
+	n   int
+	err error
+}
+
+func (r *bitReader) uint(n int) uint64 {
+	var x uint64
+	for i := 0; i < n; i++ {
+		if r.n >= len(r.b)*8 {
+			r.err = ErrFormat
+			return 0
+		}
+		x = x<<1 | uint64(r.b[r.n/8]>>uint(7-r.n%8)&1)
+		r.n++
+	}
+	return x
+}
+
+func (r *bitReader) bool() bool {
+	return r.uint(1) == 1
+}
+
+func (r *bitReader) length() int {
+	if r.uint(1) == 0 {
+		return int(r.uint(7))
+	}
+	if r.uint(1) == 0 {
+		return int(r.uint(14))
+	}
+	r.err = ErrFormat
+	return 0
+}
+
+func (r *bitReader) octets(n int) []byte {
+	if n > len(r.b) {
+		r.err = ErrFormat
+		return nil
+	}
+	out := make([]byte, n)
+	for i := range out {
+		out[i] = byte(r.uint(8))
+	}
+	return out
+}
@@ -0,0 +1,106 @@
+package ecall
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func testMSD() MSD {
+	passengers := uint8(2)
+	return MSD{
+		MessageIdentifier: 1,
+		Control:           Control{AutomaticActivation: true, PositionCanBeTrusted: true, VehicleType: PassengerVehicleClassM1},
+		VIN:               ParseVIN("WP0ZZZ99ZTS392124"),
+		Propulsion:        Propulsion{GasolineTank: true},
+		Timestamp:         1700000000,
+		// 48.1 N, 11.5 E
+		Location:        Location{Latitude: 173160000, Longitude: 41400000},
+		Direction:       28,
+		RecentLocation1: &Delta{Latitude: -10, Longitude: 5},
+		Passengers:      &passengers,
+		AdditionalOID:   "4.1",
+		AdditionalData:  []byte{1, 2},
+	}
+}
+
+// knownVector is testMSD encoded bit by bit from the ASN.1 definition in the Encode documentation.
+const knownVector = "0228540681d5c08208092606990c908108440b2a9f88045291b20413bdb600e3ed028101020081008100"
+
+func TestEncodeKnownVector(t *testing.T) {
+	want, _ := hex.DecodeString(knownVector)
+	b, err := Encode(testMSD())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, want) {
+		t.Errorf("Encode = %x, want %x", b, want)
+	}
+	m, err := Decode(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, testMSD()) {
+		t.Errorf("Decode = %+v, want %+v", m, testMSD())
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	full := testMSD()
+	full.Control = Control{TestCall: true, VehicleType: MotorcyclesClassL7e}
+	full.Propulsion = Propulsion{true, true, true, true, true, true, true}
+	full.RecentLocation2 = &Delta{Latitude: 511, Longitude: -512}
+	full.AdditionalOID = "1.2.840.113549"
+	full.AdditionalData = bytes.Repeat([]byte{0xa5}, 200)
+
+	minimal := MSD{
+		Control:   Control{VehicleType: LightCommercialVehiclesClassN1},
+		VIN:       ParseVIN(""),
+		Location:  Location{Latitude: InvalidCoordinate, Longitude: -InvalidCoordinate - 1},
+		Direction: UnknownDirection,
+	}
+
+	for _, m := range []MSD{testMSD(), full, minimal} {
+		b, err := Encode(m)
+		if err != nil {
+			t.Fatalf("Encode(%+v): %v", m, err)
+		}
+		got, err := Decode(b)
+		if err != nil {
+			t.Fatalf("Decode(%x): %v", b, err)
+		}
+		if !reflect.DeepEqual(got, m) {
+			t.Errorf("round trip of %+v = %+v", m, got)
+		}
+	}
+}
+
+func TestEncodeInvalid(t *testing.T) {
+	for name, f := range map[string]func(*MSD){
+		"vehicle type": func(m *MSD) { m.Control.VehicleType = 0 },
+		"vin":          func(m *MSD) { m.VIN = ParseVIN("WP0ZZZ99ZTS39212I") },
+		"delta":        func(m *MSD) { m.RecentLocation1 = &Delta{Latitude: 512} },
+		"oid":          func(m *MSD) { m.AdditionalOID = "4.x" },
+	} {
+		m := testMSD()
+		f(&m)
+		if _, err := Encode(m); err == nil {
+			t.Errorf("%s: Encode succeeded", name)
+		}
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	b, _ := hex.DecodeString(knownVector)
+	if _, err := Decode(b[:len(b)-4]); err != ErrFormat {
+		t.Errorf("truncated: err = %v, want ErrFormat", err)
+	}
+	other := append([]byte{Version + 1}, b[1:]...)
+	if _, err := Decode(other); err == nil || err == ErrFormat {
+		t.Errorf("version %d: err = %v, want unsupported version", Version+1, err)
+	}
+	if _, err := Decode(nil); err != ErrFormat {
+		t.Errorf("empty: err = %v, want ErrFormat", err)
+	}
+}
@@ -0,0 +1,138 @@
+package vehicledata
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/calvernaz/w3c-vehicle-data/types/zone"
+)
+
+// Field returns the named field of a vehicle data value as a number. Booleans are returned as 0 or 1 and
+// enumerated values as their constant value. It returns false if the field does not exist or is not scalar.
+func Field(v interface{}, name string) (float64, bool) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return 0, false
+	}
+	f := rv.FieldByName(name)
+	if !f.IsValid() || !f.CanInterface() {
+		return 0, false
+	}
+	switch f.Kind() {
+	case reflect.Bool:
+		if f.Bool() {
+			return 1, true
+		}
+		return 0, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(f.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(f.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return f.Float(), true
+	}
+	return 0, false
+}
+
+// Fields returns the names of the scalar fields of a vehicle data value, in declaration order.
+func Fields(v interface{}) []string {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+	var names []string
+	for i := 0; i < rv.NumField(); i++ {
+		if _, ok := Field(v, rv.Type().Field(i).Name); ok {
+			names = append(names, rv.Type().Field(i).Name)
+		}
+	}
+	return names
+}
+
+// SetField sets the named field of the vehicle data value pointed to by v to f. The value is rounded for
+// integer fields and clamped to the range of the field type; booleans are set to true for any non-zero value.
+func SetField(v interface{}, name string, f float64) error {
+	fv, err := settable(v, name)
+	if err != nil {
+		return err
+	}
+	switch fv.Kind() {
+	case reflect.Bool:
+		fv.SetBool(f != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		max := int64(1)<<(uint(fv.Type().Bits())-1) - 1
+		switch r := math.Round(f); {
+		case r >= float64(max):
+			fv.SetInt(max)
+		case r <= float64(-max-1):
+			fv.SetInt(-max - 1)
+		default:
+			fv.SetInt(int64(r))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		max := ^uint64(0) >> (64 - uint(fv.Type().Bits()))
+		switch r := math.Round(f); {
+		case r >= float64(max):
+			fv.SetUint(max)
+		case r <= 0:
+			fv.SetUint(0)
+		default:
+			fv.SetUint(uint64(r))
+		}
+	case reflect.Float32, reflect.Float64:
+		fv.SetFloat(f)
+	default:
+		return fmt.Errorf("vehicledata: field %s.%s is not scalar", TypeName(v), name)
+	}
+	return nil
+}
+
+// ParseField sets the named field of the vehicle data value pointed to by v from its text form. Numbers,
+// true/false, enumerated constant names (e.g. "Open") and zones (e.g. "front-left") are accepted.
+func ParseField(v interface{}, name, s string) error {
+	fv, err := settable(v, name)
+	if err != nil {
+		return err
+	}
+	s = strings.TrimSpace(s)
+	switch {
+	case fv.Type() == reflect.TypeOf(zone.Zone{}):
+		fv.Set(reflect.ValueOf(zone.Parse(s)))
+		return nil
+	case fv.Kind() == reflect.String:
+		fv.SetString(strings.Trim(s, `"`))
+		return nil
+	case fv.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("vehicledata: %s.%s: %v", TypeName(v), name, err)
+		}
+		fv.SetBool(b)
+		return nil
+	}
+	if c, ok := enumValue(fv.Type(), s); ok {
+		fv.SetInt(c)
+		return nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("vehicledata: %s.%s: invalid value %q", TypeName(v), name, s)
+	}
+	return SetField(v, name, f)
+}
+
+// settable returns the named field of the struct pointed to by v.
+func settable(v interface{}, name string) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("vehicledata: %T is not a pointer to a vehicle data type", v)
+	}
+	fv := rv.Elem().FieldByName(name)
+	if !fv.IsValid() || !fv.CanSet() {
+		return reflect.Value{}, fmt.Errorf("vehicledata: %s has no field %s", TypeName(v), name)
+	}
+	return fv, nil
+}
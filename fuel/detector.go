@@ -0,0 +1,331 @@
+package fuel
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+	"github.com/calvernaz/w3c-vehicle-data/types/parking-brake-status"
+	"github.com/calvernaz/w3c-vehicle-data/types/vehicle-power"
+)
+
+// EventType is the type of a fuel event.
+type EventType int
+
+const (
+	// Fuel was added while the vehicle stood still
+	Refuel EventType = iota + 1
+	// Fuel was lost while the vehicle was parked: possible theft or leak
+	Loss
+	// The level sensor reported an implausible value
+	SensorFault
+)
+
+func (t EventType) String() string {
+	switch t {
+	case Refuel:
+		return "refuel"
+	case Loss:
+		return "loss"
+	case SensorFault:
+		return "sensor fault"
+	}
+	return fmt.Sprintf("EventType(%d)", int(t))
+}
+
+// An Event is a detected change of the fuel level.
+type Event struct {
+	Type EventType
+	// Time of detection, and the time the change began
+	Time, Start time.Time
+	// Filtered level before and after the change (Unit: percentage)
+	From, To float64
+	// Volume added (positive) or lost (negative) (Unit: liters)
+	Volume float64
+	// Description of a sensor fault
+	Detail string
+}
+
+// DetectorConfig configures a detector. Zero values select the defaults noted on each field.
+type DetectorConfig struct {
+	// Usable tank capacity (Unit: liters, default 50)
+	TankCapacity float64
+	// Level rise that is a refuel (Unit: percentage, default 5)
+	RefuelThreshold float64
+	// Level drop while parked that is a loss (Unit: percentage, default 3)
+	LossThreshold float64
+	// Longitudinal or lateral acceleration above which the fuel sloshes and level readings are ignored; while
+	// stationary the same acceleration means the vehicle stands on a slope (Unit: centimeters per second
+	// squared, default 100)
+	SloshAcceleration int64
+	// Time the acceleration must stay below SloshAcceleration before readings are used again (default 3 s)
+	SettleTime time.Duration
+	// Time the filtered level must stop rising while the vehicle stands before a refuel is reported
+	// (default 1 min)
+	RefuelSettleTime time.Duration
+	// Number of readings in the median filter of the level (default 5)
+	Median int
+	// Raw level change between two readings that is a sensor fault while driving (Unit: percentage,
+	// default 20)
+	JumpThreshold float64
+	// Distance driven with an unchanged level that is a stuck sensor (Unit: meters, default 150000)
+	StuckDistance float64
+}
+
+func (c *DetectorConfig) defaults() {
+	def := func(x *float64, d float64) {
+		if *x <= 0 {
+			*x = d
+		}
+	}
+	def(&c.TankCapacity, 50)
+	def(&c.RefuelThreshold, 5)
+	def(&c.LossThreshold, 3)
+	def(&c.JumpThreshold, 20)
+	def(&c.StuckDistance, 150000)
+	if c.SloshAcceleration <= 0 {
+		c.SloshAcceleration = 100
+	}
+	if c.SettleTime <= 0 {
+		c.SettleTime = 3 * time.Second
+	}
+	if c.RefuelSettleTime <= 0 {
+		c.RefuelSettleTime = time.Minute
+	}
+	if c.Median <= 0 {
+		c.Median = 5
+	}
+}
+
+// A Detector detects refuelling, fuel loss while parked and level sensor faults from Fuel.Level, VehicleSpeed,
+// VehiclePowerModeType, ParkingBrake and Acceleration. Level readings taken while the fuel sloshes, during
+// acceleration, braking or cornering, are dropped, and the remaining readings are median filtered. Readings
+// taken while standing on a slope are kept aside and settle a refuel only if no level reading follows them.
+// A refuel is reported once the level has stopped rising for RefuelSettleTime, when the ignition is turned
+// off or when the vehicle drives off, whichever comes first. It is not safe for concurrent use.
+type Detector struct {
+	cfg DetectorConfig
+
+	moving bool
+	power  vehicle_power.VehiclePowerMode
+	brake  parking_braking_status.ParkingBrakeStatus
+	// time of the last acceleration above the slosh limit
+	sloshTime time.Time
+
+	// recent stable readings and the filtered level
+	readings []float64
+	level    float64
+	hasLevel bool
+	raw      float64
+	hasRaw   bool
+	// recent readings taken while standing on a slope since the last stable reading
+	slope []float64
+
+	// filtered level when the vehicle stopped and when it was parked, with their times
+	stopLevel, parkLevel float64
+	stopTime, parkTime   time.Time
+	stopped, parked      bool
+	lossReported         bool
+	// time the filtered level last rose while stopped
+	riseTime time.Time
+
+	// stuck sensor detection
+	speed, distance float64
+	speedTime       time.Time
+	stuckLevel      float64
+	stuckReported   bool
+}
+
+// NewDetector returns a detector.
+func NewDetector(cfg DetectorConfig) *Detector {
+	cfg.defaults()
+	return &Detector{cfg: cfg}
+}
+
+// Level returns the filtered fuel level and false before the first stable reading.
+func (d *Detector) Level() (float64, bool) {
+	return d.level, d.hasLevel
+}
+
+// Update processes a sample and returns the events it completes.
+func (d *Detector) Update(s vehicledata.Sample) []Event {
+	var events []Event
+	switch v := s.Value.(type) {
+	case vehicledata.Acceleration:
+		if abs(v.X) > d.cfg.SloshAcceleration || abs(v.Y) > d.cfg.SloshAcceleration {
+			d.sloshTime = s.Time
+		}
+	case vehicledata.VehicleSpeed:
+		events = d.speedSample(s.Time, float64(v.Speed)/3600)
+	case vehicledata.VehiclePowerModeType:
+		off := v.Value == vehicle_power.Off && d.power != vehicle_power.Off
+		d.power = v.Value
+		events = d.parkState(s.Time)
+		if off {
+			events = append(events, d.settle(s.Time)...)
+		}
+	case vehicledata.ParkingBrake:
+		d.brake = v.Status
+		events = d.parkState(s.Time)
+	case vehicledata.Fuel:
+		if s.Source == Source {
+			return nil
+		}
+		events = d.levelSample(s.Time, float64(v.Level))
+	}
+	if !d.riseTime.IsZero() && s.Time.Sub(d.riseTime) >= d.cfg.RefuelSettleTime {
+		events = append(events, d.settle(s.Time)...)
+	}
+	return events
+}
+
+func (d *Detector) speedSample(t time.Time, v float64) []Event {
+	if !d.speedTime.IsZero() {
+		if dt := t.Sub(d.speedTime); dt > 0 && dt < time.Minute {
+			d.distance += (d.speed + v) / 2 * dt.Seconds()
+		}
+	}
+	d.speed, d.speedTime = v, t
+	var events []Event
+	switch moving := v >= 0.5; {
+	case moving && !d.moving:
+		events = d.depart(t)
+	case !moving && d.moving:
+		d.stopped, d.stopLevel, d.stopTime = d.hasLevel, d.level, t
+		d.slope, d.riseTime = nil, time.Time{}
+	}
+	d.moving = v >= 0.5
+	return append(events, d.parkState(t)...)
+}
+
+// depart completes a stop.
+func (d *Detector) depart(t time.Time) []Event {
+	events := d.settle(t)
+	d.stopped, d.slope = false, nil
+	return events
+}
+
+// settle reports a level rise since the vehicle stopped as a refuel. Later rises during the same stop are
+// measured from the settled level.
+func (d *Detector) settle(t time.Time) []Event {
+	d.riseTime = time.Time{}
+	level, ok := d.standingLevel()
+	if !d.stopped || !ok || level-d.stopLevel < d.cfg.RefuelThreshold {
+		return nil
+	}
+	e := d.event(Refuel, t, d.stopTime, d.stopLevel, level)
+	d.stopLevel, d.stopTime = level, t
+	if d.parked && !d.parkTime.IsZero() && level > d.parkLevel {
+		d.parkLevel = level
+	}
+	return []Event{e}
+}
+
+// standingLevel returns the level of a standing vehicle: the filtered level, or the median of the readings
+// taken on a slope if none was taken on level ground since.
+func (d *Detector) standingLevel() (float64, bool) {
+	if len(d.slope) > 0 {
+		return median(d.slope), true
+	}
+	return d.level, d.hasLevel
+}
+
+// parkState tracks whether the vehicle is parked: standing with the parking brake on or the power not running.
+func (d *Detector) parkState(t time.Time) []Event {
+	parked := !d.moving && (d.brake == parking_braking_status.Active ||
+		d.power != 0 && d.power != vehicle_power.Running)
+	if parked && !d.parked {
+		d.parked, d.parkTime, d.lossReported = true, t, false
+		d.parkLevel = d.level
+		if !d.hasLevel {
+			d.parkTime = time.Time{}
+		}
+	}
+	if !parked {
+		d.parked = false
+	}
+	if !d.stopped && !d.moving && d.hasLevel {
+		d.stopped, d.stopLevel, d.stopTime = true, d.level, t
+	}
+	return nil
+}
+
+func (d *Detector) levelSample(t time.Time, raw float64) []Event {
+	var events []Event
+	if raw > 100 {
+		events = append(events, d.fault(t, fmt.Sprintf("level %.0f%% out of range", raw)))
+		return events
+	}
+	if d.hasRaw && d.moving && math.Abs(raw-d.raw) >= d.cfg.JumpThreshold {
+		events = append(events, d.fault(t, fmt.Sprintf("level jumped from %.0f%% to %.0f%% while driving", d.raw, raw)))
+	}
+	d.raw, d.hasRaw = raw, true
+	if !d.sloshTime.IsZero() && t.Sub(d.sloshTime) < d.cfg.SettleTime {
+		if !d.moving {
+			d.slope = appendReading(d.slope, raw, d.cfg.Median)
+		}
+		return events
+	}
+	d.slope = nil
+	d.readings = appendReading(d.readings, raw, d.cfg.Median)
+	prev, had := d.level, d.hasLevel
+	d.level, d.hasLevel = median(d.readings), true
+	if !had {
+		d.stuckLevel = d.level
+		d.parkState(t)
+		if d.parked && d.parkTime.IsZero() {
+			d.parkLevel, d.parkTime = d.level, t
+		}
+		return events
+	}
+
+	if d.stopped && !d.moving && d.level > prev {
+		d.riseTime = t
+	}
+	if d.moving && d.level-prev >= d.cfg.RefuelThreshold {
+		events = append(events, d.fault(t, fmt.Sprintf("level rose from %.0f%% to %.0f%% while driving", prev, d.level)))
+	}
+	if d.parked && !d.parkTime.IsZero() && !d.lossReported && d.parkLevel-d.level >= d.cfg.LossThreshold {
+		d.lossReported = true
+		events = append(events, d.event(Loss, t, d.parkTime, d.parkLevel, d.level))
+	}
+	if d.level != d.stuckLevel {
+		d.stuckLevel, d.distance, d.stuckReported = d.level, 0, false
+	} else if !d.stuckReported && d.distance >= d.cfg.StuckDistance {
+		d.stuckReported = true
+		events = append(events, d.fault(t, fmt.Sprintf("level stuck at %.0f%% for %.0f km", d.level, d.distance/1000)))
+	}
+	return events
+}
+
+func (d *Detector) event(typ EventType, t, start time.Time, from, to float64) Event {
+	return Event{Type: typ, Time: t, Start: start, From: from, To: to, Volume: (to - from) / 100 * d.cfg.TankCapacity}
+}
+
+func (d *Detector) fault(t time.Time, detail string) Event {
+	return Event{Type: SensorFault, Time: t, Start: t, From: d.level, To: d.level, Detail: detail}
+}
+
+// appendReading appends x to the readings, keeping the last n.
+func appendReading(readings []float64, x float64, n int) []float64 {
+	readings = append(readings, x)
+	if len(readings) > n {
+		readings = readings[1:]
+	}
+	return readings
+}
+
+func median(x []float64) float64 {
+	sorted := append([]float64(nil), x...)
+	sort.Float64s(sorted)
+	return sorted[len(sorted)/2]
+}
+
+func abs(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
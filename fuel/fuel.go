@@ -0,0 +1,313 @@
+// Package fuel estimates the computed values of Fuel: InstantConsumption, AverageConsumption and Range.
+//
+// Fuel use is taken from the FuelConsumedSinceRestart flow counter, or from drops of Fuel.Level and the tank
+// capacity on vehicles without one. Distance is integrated from VehicleSpeed. The range prediction keeps two
+// consumption models, one for city driving and one for highway driving, each learnt from the driving segments
+// of its kind, and weights them with the recent share of city driving.
+//
+// The estimates are published as Fuel samples with Source set to Source, carrying the latest Fuel.Level and
+// restart values from the vehicle:
+//
+//	e := fuel.New(fuel.Config{TankCapacity: 50})
+//	for smp := range samples {
+//		for _, s := range e.Update(smp) {
+//			// s.Value is a vehicledata.Fuel
+//		}
+//	}
+package fuel
+
+import (
+	"math"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+)
+
+// Source marks the samples produced by the estimator.
+const Source = "fuel"
+
+// Config configures an estimator. Zero values select the defaults noted on each field.
+type Config struct {
+	// Usable tank capacity (Unit: liters, default 50)
+	TankCapacity float64
+	// Initial consumption of the city and highway models (Unit: milliliters per 100 kilometers, defaults 8000
+	// and 6000)
+	CityConsumption, HighwayConsumption float64
+	// Average speed of a segment above which it is highway driving (Unit: meters per second, default 16.7,
+	// i.e. 60 km/h, below the 65.5 km/h at which VehicleSpeed saturates)
+	HighwaySpeed float64
+	// Length of the segments that update the models with the flow counter (Unit: meters, default 500)
+	SegmentDistance float64
+	// Drop of Fuel.Level that ends a segment without the flow counter (Unit: percentage, default 2)
+	LevelStep uint16
+	// Weight of a new segment in the models and in the city share, from 0 to 1 (default 0.1)
+	Learning float64
+	// Time window of the instant consumption (default 5 s)
+	InstantWindow time.Duration
+	// Speed samples further apart than this are a gap that is not integrated (default 5 s)
+	MaxGap time.Duration
+	// Minimum time between output samples (default 1 s)
+	EmitInterval time.Duration
+}
+
+func (c *Config) defaults() {
+	def := func(x *float64, d float64) {
+		if *x <= 0 {
+			*x = d
+		}
+	}
+	def(&c.TankCapacity, 50)
+	def(&c.CityConsumption, 8000)
+	def(&c.HighwayConsumption, 6000)
+	def(&c.HighwaySpeed, 60/3.6)
+	def(&c.SegmentDistance, 500)
+	def(&c.Learning, 0.1)
+	if c.LevelStep == 0 {
+		c.LevelStep = 2
+	}
+	if c.Learning > 1 {
+		c.Learning = 1
+	}
+	if c.InstantWindow <= 0 {
+		c.InstantWindow = 5 * time.Second
+	}
+	if c.MaxGap <= 0 {
+		c.MaxGap = 5 * time.Second
+	}
+	if c.EmitInterval <= 0 {
+		c.EmitInterval = time.Second
+	}
+}
+
+// Model is the learnt state of the range prediction; store it to keep the learning across restarts.
+type Model struct {
+	// Consumption in city and highway driving (Unit: milliliters per 100 kilometers)
+	City, Highway float64
+	// Recent share of city driving, from 0 to 1
+	CityShare float64
+}
+
+// Consumption returns the consumption predicted for the recent driving mix (Unit: milliliters per 100
+// kilometers).
+func (m Model) Consumption() float64 {
+	return m.CityShare*m.City + (1-m.CityShare)*m.Highway
+}
+
+// An Estimator computes fuel consumption and range. It is not safe for concurrent use.
+type Estimator struct {
+	cfg   Config
+	model Model
+
+	// latest Fuel from the vehicle
+	in   vehicledata.Fuel
+	seen bool
+
+	// speed integration
+	speed     float64
+	speedTime time.Time
+	distance  float64
+
+	// flow counter
+	counter  bool
+	consumed uint64
+	fuel     float64
+
+	// average consumption since the last reset
+	avgDistance, avgFuel float64
+
+	// current segment
+	segDistance float64
+	segTime     time.Duration
+	segFuel     float64
+	segLevel    uint16
+
+	// recent (time, distance, fuel) points for the instant consumption
+	window []point
+
+	now      time.Time
+	out      vehicledata.Fuel
+	lastEmit time.Time
+}
+
+type point struct {
+	t              time.Time
+	distance, fuel float64
+}
+
+// New returns an estimator.
+func New(cfg Config) *Estimator {
+	cfg.defaults()
+	return &Estimator{
+		cfg:   cfg,
+		model: Model{City: cfg.CityConsumption, Highway: cfg.HighwayConsumption, CityShare: 0.5},
+	}
+}
+
+// Model returns the learnt consumption models.
+func (e *Estimator) Model() Model {
+	return e.model
+}
+
+// SetModel restores consumption models, e.g. saved before the last power cycle.
+func (e *Estimator) SetModel(m Model) {
+	if m.City > 0 && m.Highway > 0 && m.CityShare >= 0 && m.CityShare <= 1 {
+		e.model = m
+	}
+}
+
+// SetAverageConsumption implements setting Fuel.AverageConsumption: setting it to any value resets the
+// average to 0, as the specification requires.
+func (e *Estimator) SetAverageConsumption(uint64) {
+	e.avgDistance, e.avgFuel = 0, 0
+	e.lastEmit = time.Time{}
+}
+
+// Fuel returns the current estimate.
+func (e *Estimator) Fuel() vehicledata.Fuel {
+	f := e.in
+	f.InstantConsumption = uint64(math.Round(e.instant()))
+	f.AverageConsumption = 0
+	if e.avgDistance > 0 {
+		f.AverageConsumption = uint64(math.Round(e.avgFuel / e.avgDistance * 100000))
+	}
+	f.Range = 0
+	if e.seen {
+		remaining := float64(e.in.Level) / 100 * e.cfg.TankCapacity * 1000
+		f.Range = uint64(remaining / e.model.Consumption() * 100000)
+	}
+	return f
+}
+
+// Update processes a sample and returns a Fuel sample at most every EmitInterval while the estimate changes.
+func (e *Estimator) Update(s vehicledata.Sample) []vehicledata.Sample {
+	if s.Source == Source {
+		return nil
+	}
+	if s.Time.After(e.now) {
+		e.now = s.Time
+	}
+	switch v := s.Value.(type) {
+	case vehicledata.VehicleSpeed:
+		e.speedSample(s.Time, float64(v.Speed)/3600)
+	case vehicledata.Fuel:
+		e.fuelSample(v)
+	default:
+		return nil
+	}
+	if !e.seen {
+		return nil
+	}
+	out := e.Fuel()
+	if out == e.out || (!e.lastEmit.IsZero() && e.now.Sub(e.lastEmit) < e.cfg.EmitInterval) {
+		return nil
+	}
+	e.out, e.lastEmit = out, e.now
+	return []vehicledata.Sample{{Time: e.now, Value: out, Source: Source}}
+}
+
+func (e *Estimator) speedSample(t time.Time, v float64) {
+	prev, prevTime := e.speed, e.speedTime
+	e.speed, e.speedTime = v, t
+	if prevTime.IsZero() {
+		return
+	}
+	dt := t.Sub(prevTime)
+	if dt <= 0 || dt > e.cfg.MaxGap {
+		return
+	}
+	d := (prev + v) / 2 * dt.Seconds()
+	e.distance += d
+	e.segDistance += d
+	e.segTime += dt
+	if !e.counter {
+		// without a flow counter the average follows the level segments
+		e.addPoint(t)
+		return
+	}
+	e.avgDistance += d
+	e.addPoint(t)
+	if e.segDistance >= e.cfg.SegmentDistance {
+		e.endSegment(e.segFuel)
+	}
+}
+
+func (e *Estimator) fuelSample(v vehicledata.Fuel) {
+	wasSeen := e.seen
+	e.in, e.seen = v, true
+	if v.FuelConsumedSinceRestart > 0 {
+		e.counter = true
+	}
+	if e.counter {
+		// the counter restarts from zero with the engine
+		if v.FuelConsumedSinceRestart >= e.consumed {
+			used := float64(v.FuelConsumedSinceRestart - e.consumed)
+			e.fuel += used
+			e.avgFuel += used
+			e.segFuel += used
+		}
+		e.consumed = v.FuelConsumedSinceRestart
+		return
+	}
+	switch {
+	case !wasSeen || v.Level >= e.segLevel+e.cfg.LevelStep:
+		// first level or refuelling: start a new segment
+		e.segLevel, e.segDistance, e.segTime = v.Level, 0, 0
+	case e.segLevel >= v.Level+e.cfg.LevelStep:
+		used := float64(e.segLevel-v.Level) / 100 * e.cfg.TankCapacity * 1000
+		e.fuel += used
+		e.avgFuel += used
+		e.avgDistance += e.segDistance
+		e.segLevel = v.Level
+		e.endSegment(used)
+	}
+}
+
+// endSegment updates the models with the current segment and starts the next one.
+func (e *Estimator) endSegment(used float64) {
+	if e.segDistance > 0 && e.segTime > 0 {
+		c := used / e.segDistance * 100000
+		a := e.cfg.Learning
+		city := 0.0
+		if e.segDistance/e.segTime.Seconds() < e.cfg.HighwaySpeed {
+			city = 1
+			e.model.City += a * (c - e.model.City)
+		} else {
+			e.model.Highway += a * (c - e.model.Highway)
+		}
+		e.model.CityShare += a * (city - e.model.CityShare)
+	}
+	e.segDistance, e.segTime, e.segFuel = 0, 0, 0
+}
+
+// addPoint records the distance and fuel at t and drops the points that left the instant window.
+func (e *Estimator) addPoint(t time.Time) {
+	e.window = append(e.window, point{t, e.distance, e.fuel})
+	i := 0
+	for i < len(e.window)-2 && t.Sub(e.window[i+1].t) >= e.cfg.InstantWindow {
+		i++
+	}
+	e.window = e.window[i:]
+}
+
+// instant returns the instant consumption: measured over the instant window with the flow counter, or the
+// model of the current speed without it. It is 0 while the vehicle stands still.
+func (e *Estimator) instant() float64 {
+	if e.speed < 1 {
+		return 0
+	}
+	if !e.counter {
+		if e.speed < e.cfg.HighwaySpeed {
+			return e.model.City
+		}
+		return e.model.Highway
+	}
+	if len(e.window) < 2 {
+		return 0
+	}
+	first, last := e.window[0], e.window[len(e.window)-1]
+	d := last.distance - first.distance
+	if d <= 0 {
+		return 0
+	}
+	return (last.fuel - first.fuel) / d * 100000
+}
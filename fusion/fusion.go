@@ -0,0 +1,236 @@
+// Package fusion estimates the longitudinal speed and acceleration of the vehicle from all the signals that
+// measure them: VehicleSpeed, WheelSpeed and WheelTick of every wheel, and Acceleration.X.
+//
+// A Kalman filter with the state (speed, acceleration) and a constant acceleration motion model combines the
+// measurements according to their noise. Wheel measurements that disagree with the prediction beyond the
+// gate are rejected, and the wheel is flagged as locked when it is slower than the vehicle or spinning when it
+// is faster, so a locking wheel under braking or a spinning driven wheel does not pull the estimate.
+//
+// The estimate is published as VehicleSpeed samples with Source set to Source; the filter ignores its own
+// output, so it can be attached to the hub that carries its inputs.
+package fusion
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+	"github.com/calvernaz/w3c-vehicle-data/internal/wheelradius"
+)
+
+// Source marks the samples produced by the filter.
+const Source = "fusion"
+
+// Config configures a filter. Zero values select the defaults noted on each field.
+type Config struct {
+	// Measurement noise, as standard deviation, of VehicleSpeed, of one wheel's WheelSpeed or WheelTick speed
+	// (Unit: meters per second, defaults 0.5 and 0.3) and of Acceleration.X (Unit: meters per second squared,
+	// default 0.5)
+	SpeedNoise, WheelNoise, AccelerationNoise float64
+	// Process noise: standard deviation of the jerk (Unit: meters per second cubed, default 2)
+	Jerk float64
+	// Measurements whose innovation exceeds this many standard deviations are rejected (default 3)
+	Gate float64
+	// Relative deviation from the estimate above which a rejected wheel is flagged locked or spinning
+	// (default 0.1)
+	SlipRatio float64
+	// Wheel ticks per revolution and wheel radius for WheelTick (defaults 48 and 300 millimeters); the radius
+	// follows WheelConfiguration samples
+	TicksPerRevolution float64
+	WheelRadius        uint16
+	// Measurements older than this are not used for slip flags (default 1 s)
+	MaxAge time.Duration
+	// Minimum time between output samples (default 100 ms)
+	OutputInterval time.Duration
+}
+
+func (c *Config) defaults() {
+	def := func(x *float64, d float64) {
+		if *x <= 0 {
+			*x = d
+		}
+	}
+	def(&c.SpeedNoise, 0.5)
+	def(&c.WheelNoise, 0.3)
+	def(&c.AccelerationNoise, 0.5)
+	def(&c.Jerk, 2)
+	def(&c.Gate, 3)
+	def(&c.SlipRatio, 0.1)
+	def(&c.TicksPerRevolution, 48)
+	if c.WheelRadius == 0 {
+		c.WheelRadius = 300
+	}
+	if c.MaxAge <= 0 {
+		c.MaxAge = time.Second
+	}
+	if c.OutputInterval <= 0 {
+		c.OutputInterval = 100 * time.Millisecond
+	}
+}
+
+// An Estimate is the state of the filter.
+type Estimate struct {
+	Time time.Time
+	// Longitudinal speed (Unit: meters per second) and its standard deviation
+	Speed, SpeedStdDev float64
+	// Longitudinal acceleration (Unit: meters per second squared) and its standard deviation
+	Acceleration, AccelerationStdDev float64
+	// Signal keys of the wheels whose last measurement was rejected as locked or spinning,
+	// e.g. "WheelSpeed[rear-left]"
+	Locked, Spinning []string
+}
+
+// A Filter fuses speed measurements. It is not safe for concurrent use.
+type Filter struct {
+	cfg Config
+
+	init bool
+	t    time.Time
+	// state and covariance
+	v, a float64
+	p    [2][2]float64
+
+	radii  wheelradius.Radii
+	wheels map[string]*wheelState
+
+	lastOut time.Time
+}
+
+type wheelState struct {
+	time             time.Time
+	locked, spinning bool
+}
+
+// New returns a filter.
+func New(cfg Config) *Filter {
+	cfg.defaults()
+	return &Filter{cfg: cfg, wheels: map[string]*wheelState{}, radii: wheelradius.Radii{Default: cfg.WheelRadius}}
+}
+
+// Estimate returns the current estimate.
+func (f *Filter) Estimate() Estimate {
+	e := Estimate{
+		Time:               f.t,
+		Speed:              math.Max(f.v, 0),
+		SpeedStdDev:        math.Sqrt(f.p[0][0]),
+		Acceleration:       f.a,
+		AccelerationStdDev: math.Sqrt(f.p[1][1]),
+	}
+	for key, w := range f.wheels {
+		if f.t.Sub(w.time) > f.cfg.MaxAge {
+			continue
+		}
+		if w.locked {
+			e.Locked = append(e.Locked, key)
+		}
+		if w.spinning {
+			e.Spinning = append(e.Spinning, key)
+		}
+	}
+	sort.Strings(e.Locked)
+	sort.Strings(e.Spinning)
+	return e
+}
+
+// Update processes a sample and returns a fused VehicleSpeed sample at most every OutputInterval.
+func (f *Filter) Update(s vehicledata.Sample) []vehicledata.Sample {
+	if s.Source == Source {
+		return nil
+	}
+	switch v := s.Value.(type) {
+	case vehicledata.VehicleSpeed:
+		if v.Speed == math.MaxUint16 {
+			// saturated: the true speed is higher
+			return nil
+		}
+		f.measure(s.Time, 0, float64(v.Speed)/3600, f.cfg.SpeedNoise)
+	case vehicledata.WheelSpeed:
+		f.wheel(s.Time, s.Key(), float64(v.Speed)/3600)
+	case vehicledata.WheelTick:
+		f.wheel(s.Time, s.Key(), float64(v.Value)/f.cfg.TicksPerRevolution*2*math.Pi*f.radii.Radius(v.Zone))
+	case vehicledata.Acceleration:
+		f.measure(s.Time, 1, float64(v.X)/100, f.cfg.AccelerationNoise)
+	case vehicledata.WheelConfiguration:
+		f.radii.Set(v)
+		return nil
+	default:
+		return nil
+	}
+	if !f.init || (!f.lastOut.IsZero() && f.t.Sub(f.lastOut) < f.cfg.OutputInterval) {
+		return nil
+	}
+	f.lastOut = f.t
+	speed := math.Min(math.Max(f.v, 0)*3600, math.MaxUint16)
+	return []vehicledata.Sample{{Time: f.t, Value: vehicledata.VehicleSpeed{Speed: uint16(math.Round(speed))}, Source: Source}}
+}
+
+// wheel applies a wheel speed measurement with slip gating.
+func (f *Filter) wheel(t time.Time, key string, speed float64) {
+	w, ok := f.wheels[key]
+	if !ok {
+		w = &wheelState{}
+		f.wheels[key] = w
+	}
+	w.time = t
+	accepted := f.measure(t, 0, speed, f.cfg.WheelNoise)
+	dev := speed - f.v
+	slip := !accepted && math.Abs(dev) > f.cfg.SlipRatio*math.Max(f.v, 1)
+	w.locked, w.spinning = slip && dev < 0, slip && dev > 0
+}
+
+// measure predicts the state to time t and applies a measurement z of state component i with noise sigma.
+// It reports whether the measurement passed the gate.
+func (f *Filter) measure(t time.Time, i int, z, sigma float64) bool {
+	r := sigma * sigma
+	if !f.init {
+		if i != 0 {
+			return false
+		}
+		f.init, f.t, f.v, f.a = true, t, z, 0
+		f.p = [2][2]float64{{r, 0}, {0, 4}}
+		return true
+	}
+	f.predict(t)
+	y := z - f.component(i)
+	s := f.p[i][i] + r
+	if y*y > f.cfg.Gate*f.cfg.Gate*s {
+		return false
+	}
+	k0, k1 := f.p[0][i]/s, f.p[1][i]/s
+	f.v += k0 * y
+	f.a += k1 * y
+	pi0, pi1 := f.p[i][0], f.p[i][1]
+	f.p[0][0] -= k0 * pi0
+	f.p[0][1] -= k0 * pi1
+	f.p[1][0] -= k1 * pi0
+	f.p[1][1] -= k1 * pi1
+	return true
+}
+
+func (f *Filter) component(i int) float64 {
+	if i == 0 {
+		return f.v
+	}
+	return f.a
+}
+
+// predict advances the state to time t. Samples older than the state are applied at the state time.
+func (f *Filter) predict(t time.Time) {
+	if !t.After(f.t) {
+		return
+	}
+	dt := t.Sub(f.t).Seconds()
+	f.t = t
+	f.v += f.a * dt
+	// P = F P F' + Q with F = [1 dt; 0 1] and white jerk noise
+	p := f.p
+	p00 := p[0][0] + dt*(p[1][0]+p[0][1]) + dt*dt*p[1][1]
+	p01 := p[0][1] + dt*p[1][1]
+	p10 := p[1][0] + dt*p[1][1]
+	q := f.cfg.Jerk * f.cfg.Jerk
+	f.p = [2][2]float64{
+		{p00 + q*dt*dt*dt/3, p01 + q*dt*dt/2},
+		{p10 + q*dt*dt/2, p[1][1] + q*dt},
+	}
+}
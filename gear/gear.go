@@ -0,0 +1,229 @@
+// Package gear estimates the engaged gear of vehicles that do not broadcast Transmission.Gear, from the ratio
+// of EngineSpeed to the wheel speed given by VehicleSpeed and the wheel radius.
+//
+// The gear ratios are not part of TransmissionConfiguration, so they are configured here, together with the
+// final drive ratio. The transmission type follows TransmissionConfiguration samples: a ratio between gears
+// is reported as clutch slip for manual transmissions and as torque converter slip for automatic ones.
+//
+// Example for a six speed manual gearbox:
+//
+//	e := gear.New(gear.Config{
+//		Ratios:     []float64{3.77, 2.05, 1.32, 0.97, 0.76, 0.63},
+//		FinalDrive: 4.06,
+//		Type:       transmission_gear.Manual,
+//	})
+package gear
+
+import (
+	"math"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+	"github.com/calvernaz/w3c-vehicle-data/types/transmission-gear"
+	"github.com/calvernaz/w3c-vehicle-data/types/transmission-mode"
+)
+
+// Source marks the samples produced by the estimator.
+const Source = "gear"
+
+// Config configures an estimator. Zero values select the defaults noted on each field.
+type Config struct {
+	// Gearbox ratios of the forward gears, first gear first
+	Ratios []float64
+	// Final drive (axle) ratio (default 1, for Ratios that already include it)
+	FinalDrive float64
+	// Transmission type until a TransmissionConfiguration sample arrives (default automatic)
+	Type transmission_gear.TransmissionGearType
+	// Wheel radius until a WheelConfiguration sample arrives (Unit: millimeters, default 300)
+	WheelRadius uint16
+	// Relative deviation from a gear ratio that still matches the gear (default 0.05)
+	Tolerance float64
+	// Speed below which no gear is estimated (Unit: meters per second, default 2)
+	MinSpeed float64
+	// Time a new gear must be observed before it is reported, and a mismatch before slip is flagged (defaults
+	// 300 ms and 1 s)
+	GearDelay, SlipDelay time.Duration
+	// Samples further apart than this are not combined (default 1 s)
+	MaxAge time.Duration
+}
+
+func (c *Config) defaults() {
+	if c.FinalDrive <= 0 {
+		c.FinalDrive = 1
+	}
+	if c.Type == 0 {
+		c.Type = transmission_gear.Automatic
+	}
+	if c.WheelRadius == 0 {
+		c.WheelRadius = 300
+	}
+	if c.Tolerance <= 0 {
+		c.Tolerance = 0.05
+	}
+	if c.MinSpeed <= 0 {
+		c.MinSpeed = 2
+	}
+	if c.GearDelay <= 0 {
+		c.GearDelay = 300 * time.Millisecond
+	}
+	if c.SlipDelay <= 0 {
+		c.SlipDelay = time.Second
+	}
+	if c.MaxAge <= 0 {
+		c.MaxAge = time.Second
+	}
+}
+
+// Status is the state of the estimator.
+type Status struct {
+	// Estimated gear, 0 if unknown
+	Gear byte
+	// Confidence in Gear, from 0 to 1
+	Confidence float64
+	// Observed overall ratio of engine speed to wheel speed, 0 if unknown
+	Ratio float64
+	// Relative slip against the estimated gear: observed ratio / gear ratio - 1
+	Slip float64
+	// The ratio has matched no gear for SlipDelay: the clutch of a manual transmission is slipping
+	ClutchSlip bool
+	// The engine has turned faster than the estimated gear allows for SlipDelay: the torque converter of an
+	// automatic transmission is slipping
+	ConverterSlip bool
+}
+
+// An Estimator infers the gear. It is not safe for concurrent use.
+type Estimator struct {
+	cfg Config
+
+	rpm, speed         float64
+	rpmTime, speedTime time.Time
+	radius             float64
+	mode               transmission_mode.TransmissionMode
+
+	status Status
+	// candidate gear and the time it was first observed
+	candidate     byte
+	candidateTime time.Time
+	// time since which the ratio mismatches, zero while it matches
+	mismatch time.Time
+
+	out vehicledata.Transmission
+	has bool
+}
+
+// New returns an estimator.
+func New(cfg Config) *Estimator {
+	cfg.defaults()
+	return &Estimator{cfg: cfg, radius: float64(cfg.WheelRadius) / 1000}
+}
+
+// Status returns the current estimate.
+func (e *Estimator) Status() Status {
+	return e.status
+}
+
+// Update processes a sample and returns a Transmission sample when the estimated gear or mode changes.
+// Transmission samples from the vehicle supply the mode; their gear is ignored.
+func (e *Estimator) Update(s vehicledata.Sample) []vehicledata.Sample {
+	if s.Source == Source {
+		return nil
+	}
+	switch v := s.Value.(type) {
+	case vehicledata.EngineSpeed:
+		e.rpm, e.rpmTime = float64(v.Speed), s.Time
+	case vehicledata.VehicleSpeed:
+		e.speed, e.speedTime = float64(v.Speed)/3600, s.Time
+	case vehicledata.Transmission:
+		e.mode = v.Mode
+	case vehicledata.TransmissionConfiguration:
+		if v.TransmissionGearType != 0 {
+			e.cfg.Type = v.TransmissionGearType
+		}
+		return nil
+	case vehicledata.WheelConfiguration:
+		if v.WheelRadius > 0 {
+			e.radius = float64(v.WheelRadius) / 1000
+		}
+		return nil
+	default:
+		return nil
+	}
+	e.estimate(s.Time)
+
+	out := vehicledata.Transmission{Gear: e.status.Gear, Mode: e.mode}
+	if out.Mode == 0 && out.Gear > 0 {
+		out.Mode = transmission_mode.Drive
+	}
+	if e.has && out == e.out {
+		return nil
+	}
+	e.out, e.has = out, true
+	return []vehicledata.Sample{{Time: s.Time, Value: out, Source: Source}}
+}
+
+// estimate updates the status at time t.
+func (e *Estimator) estimate(t time.Time) {
+	switch e.mode {
+	case transmission_mode.Park, transmission_mode.Neutral, transmission_mode.Reverse:
+		e.reset()
+		return
+	}
+	if len(e.cfg.Ratios) == 0 || t.Sub(e.rpmTime) > e.cfg.MaxAge || t.Sub(e.speedTime) > e.cfg.MaxAge ||
+		e.speed < e.cfg.MinSpeed || e.rpm <= 0 {
+		e.reset()
+		return
+	}
+	// engine angular speed over wheel angular speed
+	ratio := e.rpm * 2 * math.Pi / 60 / (e.speed / e.radius)
+	e.status.Ratio = ratio
+
+	best, bestErr := 0, math.Inf(1)
+	for i, r := range e.cfg.Ratios {
+		if d := math.Abs(math.Log(ratio / (r * e.cfg.FinalDrive))); d < bestErr {
+			best, bestErr = i, d
+		}
+	}
+	if e.cfg.Type == transmission_gear.Automatic {
+		// a slipping converter makes the engine faster than the gear: take the highest gear ratio that the
+		// observed ratio does not fall short of
+		for i := len(e.cfg.Ratios) - 1; i >= 0; i-- {
+			if ratio >= e.cfg.Ratios[i]*e.cfg.FinalDrive*(1-e.cfg.Tolerance) {
+				best = i
+			}
+		}
+	}
+	slip := ratio/(e.cfg.Ratios[best]*e.cfg.FinalDrive) - 1
+	match := math.Abs(slip) <= e.cfg.Tolerance
+
+	gear := byte(best + 1)
+	if gear != e.candidate {
+		e.candidate, e.candidateTime = gear, t
+	}
+	if (match || e.cfg.Type == transmission_gear.Automatic) && t.Sub(e.candidateTime) >= e.cfg.GearDelay {
+		e.status.Gear = gear
+	}
+	e.status.Slip = slip
+	e.status.Confidence = 0
+	if e.status.Gear == gear {
+		e.status.Confidence = math.Max(0, 1-math.Abs(slip)/e.cfg.Tolerance)
+		if e.cfg.Type == transmission_gear.Automatic && slip > 0 {
+			// converter slip is expected: lower the confidence slowly
+			e.status.Confidence = 1 / (1 + slip/e.cfg.Tolerance)
+		}
+	}
+
+	if match {
+		e.mismatch = time.Time{}
+	} else if e.mismatch.IsZero() {
+		e.mismatch = t
+	}
+	slipping := !e.mismatch.IsZero() && t.Sub(e.mismatch) >= e.cfg.SlipDelay
+	e.status.ClutchSlip = slipping && e.cfg.Type == transmission_gear.Manual
+	e.status.ConverterSlip = slipping && e.cfg.Type == transmission_gear.Automatic && slip > 0
+}
+
+// reset clears the estimate while no gear can be inferred.
+func (e *Estimator) reset() {
+	e.status = Status{}
+	e.candidate, e.mismatch = 0, time.Time{}
+}
@@ -0,0 +1,116 @@
+package history
+
+import (
+	"sort"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+	"github.com/calvernaz/w3c-vehicle-data/record"
+)
+
+// A Query selects samples by type, zone and time window.
+type Query struct {
+	// Vehicle data type name, e.g. "Tire"; empty selects every type
+	Type string
+	// Zone, e.g. "rear-right"; empty selects every zone
+	Zone string
+	// Time window, both ends inclusive; a zero time leaves that end open
+	From, To time.Time
+}
+
+func (q Query) match(smp vehicledata.Sample) bool {
+	if q.Type != "" && smp.Name() != q.Type {
+		return false
+	}
+	if q.Zone != "" {
+		if z, ok := vehicledata.ZoneOf(smp.Value); !ok || z.String() != q.Zone {
+			return false
+		}
+	}
+	if !q.From.IsZero() && smp.Time.Before(q.From) {
+		return false
+	}
+	return q.To.IsZero() || !smp.Time.After(q.To)
+}
+
+// Query returns the samples matching q in time order. Windows that start within the memory buffers are
+// answered from memory, others from the segments on disk.
+func (s *Store) Query(q Query) ([]vehicledata.Sample, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	if !q.From.Before(s.memSince) && (!q.From.IsZero() || s.memSince.IsZero()) {
+		return s.queryMemory(q), nil
+	}
+	return s.queryDisk(q)
+}
+
+// GetHistory returns the samples of one signal between begin and end, like the W3C getHistory method. key is
+// a type name with an optional zone, e.g. "VehicleSpeed" or "Tire[rear-right]".
+func (s *Store) GetHistory(key string, begin, end time.Time) ([]vehicledata.Sample, error) {
+	name, z := vehicledata.SplitKey(key)
+	return s.Query(Query{Type: name, Zone: z.String(), From: begin, To: end})
+}
+
+// Latest returns the most recent sample of the signal with the given key.
+func (s *Store) Latest(key string) (vehicledata.Sample, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.rings[key]
+	if !ok || len(r.buf) == 0 {
+		return vehicledata.Sample{}, false
+	}
+	return r.buf[(r.start+len(r.buf)-1)%len(r.buf)], true
+}
+
+func (s *Store) queryMemory(q Query) []vehicledata.Sample {
+	var out []vehicledata.Sample
+	for _, r := range s.rings {
+		r.each(func(smp vehicledata.Sample) {
+			if q.match(smp) {
+				out = append(out, smp)
+			}
+		})
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Time.Before(out[j].Time) })
+	return out
+}
+
+func (s *Store) queryDisk(q Query) ([]vehicledata.Sample, error) {
+	segs, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	var out []vehicledata.Sample
+	for i, seg := range segs {
+		if !q.To.IsZero() && seg.start.After(q.To) {
+			break
+		}
+		if i+1 < len(segs) && !q.From.IsZero() && !segs[i+1].start.After(q.From) {
+			continue
+		}
+		r, err := record.Open(seg.path)
+		if err != nil {
+			return nil, err
+		}
+		if !q.From.IsZero() {
+			if err := r.Seek(q.From); err != nil {
+				r.Close()
+				return nil, err
+			}
+		}
+		for {
+			smp, err := r.Next()
+			if err != nil || (!q.To.IsZero() && smp.Time.After(q.To)) {
+				break
+			}
+			if q.match(smp) {
+				out = append(out, smp)
+			}
+		}
+		r.Close()
+	}
+	return out, nil
+}
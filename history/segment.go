@@ -0,0 +1,230 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+	"github.com/calvernaz/w3c-vehicle-data/record"
+)
+
+const (
+	segmentExt     = ".vdlog"
+	downsampledTag = "-ds"
+)
+
+// A segment is a log file holding the samples from its start up to the start of the next segment.
+type segment struct {
+	path        string
+	start       time.Time
+	downsampled bool
+	// size of the log and its index in bytes
+	size int64
+}
+
+func (s *Store) segmentPath(start time.Time, downsampled bool) string {
+	name := fmt.Sprintf("%020d", start.UnixNano())
+	if downsampled {
+		name += downsampledTag
+	}
+	return filepath.Join(s.dir, name+segmentExt)
+}
+
+// segments lists the segments of the store in time order.
+func (s *Store) segments() ([]segment, error) {
+	names, err := filepath.Glob(filepath.Join(s.dir, "*"+segmentExt))
+	if err != nil {
+		return nil, err
+	}
+	var segs []segment
+	for _, path := range names {
+		base := strings.TrimSuffix(filepath.Base(path), segmentExt)
+		seg := segment{path: path, downsampled: strings.HasSuffix(base, downsampledTag)}
+		ns, err := strconv.ParseInt(strings.TrimSuffix(base, downsampledTag), 10, 64)
+		if err != nil {
+			continue
+		}
+		seg.start = time.Unix(0, ns)
+		for _, p := range []string{path, path + ".idx"} {
+			if fi, err := os.Stat(p); err == nil {
+				seg.size += fi.Size()
+			}
+		}
+		segs = append(segs, seg)
+	}
+	sort.Slice(segs, func(i, j int) bool {
+		if segs[i].start.Equal(segs[j].start) {
+			return segs[i].downsampled
+		}
+		return segs[i].start.Before(segs[j].start)
+	})
+	// a crash during downsampling may leave the original next to its complete downsampled copy
+	out := segs[:0]
+	for i, seg := range segs {
+		if i > 0 && seg.start.Equal(segs[i-1].start) && segs[i-1].downsampled {
+			if err := removeSegment(seg.path); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		out = append(out, seg)
+	}
+	return out, nil
+}
+
+// rotate closes the current segment and starts a new one for samples from t, then applies retention and
+// downsampling to the older segments.
+func (s *Store) rotate(t time.Time) error {
+	start := t.Truncate(s.opts.SegmentDuration)
+	if s.w != nil {
+		if err := s.w.Close(); err != nil {
+			return err
+		}
+		s.w = nil
+		if !start.After(s.cur.start) {
+			start = t
+		}
+	}
+	w, err := record.Create(s.segmentPath(start, false))
+	if err != nil {
+		return err
+	}
+	s.w = w
+	s.cur = segment{path: s.segmentPath(start, false), start: start}
+	return s.maintain(t)
+}
+
+// Maintain applies retention and downsampling as of now. It runs automatically whenever a new segment is
+// started; call it periodically when samples arrive rarely.
+func (s *Store) Maintain(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	return s.maintain(now)
+}
+
+func (s *Store) maintain(now time.Time) error {
+	segs, err := s.segments()
+	if err != nil {
+		return err
+	}
+	// the current segment is never touched
+	var old []segment
+	var total int64
+	for _, seg := range segs {
+		if seg.path != s.cur.path {
+			old = append(old, seg)
+		}
+		total += seg.size
+	}
+	end := func(i int) time.Time {
+		if i+1 < len(old) {
+			return old[i+1].start
+		}
+		return s.cur.start
+	}
+
+	keep := old[:0]
+	for i, seg := range old {
+		switch {
+		case s.opts.MaxAge > 0 && end(i).Before(now.Add(-s.opts.MaxAge)):
+			if err := removeSegment(seg.path); err != nil {
+				return err
+			}
+			total -= seg.size
+			continue
+		case s.opts.DownsampleAfter > 0 && !seg.downsampled && end(i).Before(now.Add(-s.opts.DownsampleAfter)):
+			ds, err := s.downsample(seg)
+			if err != nil {
+				return err
+			}
+			total += ds.size - seg.size
+			seg = ds
+		}
+		keep = append(keep, seg)
+	}
+	for len(keep) > 0 && s.opts.MaxBytes > 0 && total > s.opts.MaxBytes {
+		if err := removeSegment(keep[0].path); err != nil {
+			return err
+		}
+		total -= keep[0].size
+		keep = keep[1:]
+	}
+	return nil
+}
+
+func removeSegment(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(path + ".idx"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// downsample rewrites a segment keeping the last sample of every signal per downsampling interval. The new
+// segment is written to a temporary file and renamed into place, so a crash leaves either segment intact.
+func (s *Store) downsample(seg segment) (segment, error) {
+	r, err := record.Open(seg.path)
+	if err != nil {
+		return segment{}, err
+	}
+	type bucket struct {
+		key  string
+		time time.Time
+	}
+	latest := map[bucket]vehicledata.Sample{}
+	for {
+		smp, err := r.Next()
+		if err != nil {
+			break
+		}
+		latest[bucket{smp.Key(), smp.Time.Truncate(s.opts.DownsampleInterval)}] = smp
+	}
+	r.Close()
+	kept := make([]vehicledata.Sample, 0, len(latest))
+	for _, smp := range latest {
+		kept = append(kept, smp)
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].Time.Before(kept[j].Time) })
+
+	ds := segment{path: s.segmentPath(seg.start, true), start: seg.start, downsampled: true}
+	tmp := ds.path + ".tmp"
+	removeSegment(tmp)
+	w, err := record.Create(tmp)
+	if err != nil {
+		return segment{}, err
+	}
+	for _, smp := range kept {
+		if err := w.Write(smp); err != nil {
+			w.Close()
+			return segment{}, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return segment{}, err
+	}
+	if err := os.Rename(tmp+".idx", ds.path+".idx"); err != nil {
+		return segment{}, err
+	}
+	if err := os.Rename(tmp, ds.path); err != nil {
+		return segment{}, err
+	}
+	if err := removeSegment(seg.path); err != nil {
+		return segment{}, err
+	}
+	for _, p := range []string{ds.path, ds.path + ".idx"} {
+		if fi, err := os.Stat(p); err == nil {
+			ds.size += fi.Size()
+		}
+	}
+	return ds, nil
+}
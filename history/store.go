@@ -0,0 +1,193 @@
+// Package history is an embedded time-series store for vehicle data samples, providing the getHistory(begin,
+// end) semantics of the W3C Vehicle API.
+//
+// Recent samples of every signal are kept in per-signal ring buffers in memory. All samples are also appended
+// to segment files on disk, one per SegmentDuration, written with package record so a crash or power loss
+// costs at most the torn last record. Segments older than DownsampleAfter are rewritten keeping one sample per
+// signal per DownsampleInterval, and segments beyond the retention limits are deleted.
+package history
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+	"github.com/calvernaz/w3c-vehicle-data/record"
+)
+
+// Options configures a store. Zero values select the defaults noted on each field.
+type Options struct {
+	// Samples kept in memory per signal (default 1024)
+	MemorySamples int
+	// Time span of a segment file (default 1 hour)
+	SegmentDuration time.Duration
+	// Segments ending before this age are deleted; 0 keeps them forever
+	MaxAge time.Duration
+	// Oldest segments are deleted while the store is larger than this many bytes; 0 means no limit
+	MaxBytes int64
+	// Segments ending before this age are downsampled; 0 disables downsampling
+	DownsampleAfter time.Duration
+	// Interval of the samples kept by downsampling (default 1 minute)
+	DownsampleInterval time.Duration
+}
+
+func (o *Options) defaults() {
+	if o.MemorySamples <= 0 {
+		o.MemorySamples = 1024
+	}
+	if o.SegmentDuration <= 0 {
+		o.SegmentDuration = time.Hour
+	}
+	if o.DownsampleInterval <= 0 {
+		o.DownsampleInterval = time.Minute
+	}
+}
+
+// ErrClosed is returned by operations on a closed store.
+var ErrClosed = errors.New("history: store is closed")
+
+// A Store keeps the history of vehicle data signals. It is safe for concurrent use.
+type Store struct {
+	mu   sync.Mutex
+	dir  string
+	opts Options
+	// current segment, nil before the first sample
+	w    *record.Writer
+	cur  segment
+	last time.Time
+	// ring buffers by signal key
+	rings map[string]*ring
+	// memory holds every sample at or after memSince
+	memSince time.Time
+	closed   bool
+}
+
+// Open opens the store in directory dir, creating it if needed. Samples of the most recent segment are loaded
+// back into memory.
+func Open(dir string, opts Options) (*Store, error) {
+	opts.defaults()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &Store{dir: dir, opts: opts, rings: map[string]*ring{}}
+	segs, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	if len(segs) == 0 {
+		return s, nil
+	}
+	last := segs[len(segs)-1]
+	if last.downsampled {
+		// the samples of a downsampled segment are gone from memory: only accept samples after its end
+		s.memSince = last.start.Add(s.opts.SegmentDuration)
+		s.last = s.memSince
+		return s, nil
+	}
+	s.memSince = last.start
+	r, err := record.Open(last.path)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		smp, err := r.Next()
+		if err != nil {
+			break
+		}
+		s.remember(smp)
+		s.last = smp.Time
+	}
+	r.Close()
+	if s.w, err = record.Create(last.path); err != nil {
+		return nil, err
+	}
+	s.cur = last
+	return s, nil
+}
+
+// Add stores a sample. Samples must be added in time order.
+func (s *Store) Add(smp vehicledata.Sample) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if smp.Time.Before(s.last) {
+		return record.ErrOutOfOrder
+	}
+	if s.w == nil || !smp.Time.Before(s.cur.start.Add(s.opts.SegmentDuration)) {
+		if err := s.rotate(smp.Time); err != nil {
+			return err
+		}
+	}
+	if err := s.w.Write(smp); err != nil {
+		return err
+	}
+	s.remember(smp)
+	s.last = smp.Time
+	return nil
+}
+
+// remember adds a sample to the ring buffer of its signal.
+func (s *Store) remember(smp vehicledata.Sample) {
+	key := smp.Key()
+	r, ok := s.rings[key]
+	if !ok {
+		r = &ring{buf: make([]vehicledata.Sample, 0, s.opts.MemorySamples)}
+		s.rings[key] = r
+	}
+	if old, evicted := r.push(smp); evicted && !old.Time.Before(s.memSince) {
+		s.memSince = old.Time.Add(1)
+	}
+}
+
+// Sync commits the current segment to stable storage.
+func (s *Store) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.w == nil {
+		return nil
+	}
+	return s.w.Sync()
+}
+
+// Close syncs and closes the store.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.w == nil {
+		return nil
+	}
+	return s.w.Close()
+}
+
+// A ring is a fixed size buffer of the most recent samples of one signal.
+type ring struct {
+	buf   []vehicledata.Sample
+	start int
+}
+
+// push appends a sample and returns the evicted one, if any.
+func (r *ring) push(smp vehicledata.Sample) (vehicledata.Sample, bool) {
+	if len(r.buf) < cap(r.buf) {
+		r.buf = append(r.buf, smp)
+		return vehicledata.Sample{}, false
+	}
+	old := r.buf[r.start]
+	r.buf[r.start] = smp
+	r.start = (r.start + 1) % len(r.buf)
+	return old, true
+}
+
+// each calls fn with the samples of the ring in time order.
+func (r *ring) each(fn func(vehicledata.Sample)) {
+	for i := range r.buf {
+		fn(r.buf[(r.start+i)%len(r.buf)])
+	}
+}
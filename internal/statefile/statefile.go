@@ -0,0 +1,34 @@
+// Package statefile writes the JSON state files of the components that persist their state across restarts.
+package statefile
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// Save writes v as JSON to the file at path. The file is replaced atomically: the data is written to a
+// temporary file next to it, synced and renamed over it, so a power loss leaves either the old or the new
+// state.
+func Save(path string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
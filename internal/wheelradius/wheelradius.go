@@ -0,0 +1,37 @@
+// Package wheelradius keeps the wheel radii reported by WheelConfiguration samples, by zone.
+package wheelradius
+
+import (
+	"github.com/calvernaz/w3c-vehicle-data"
+	"github.com/calvernaz/w3c-vehicle-data/types/zone"
+)
+
+// Radii holds wheel configurations by zone. The zero value has no configurations.
+type Radii struct {
+	// Radius of wheels without a configuration (Unit: millimeters)
+	Default uint16
+	configs []vehicledata.WheelConfiguration
+}
+
+// Set records a wheel configuration, replacing the one for the same zone.
+func (r *Radii) Set(c vehicledata.WheelConfiguration) {
+	for i := range r.configs {
+		if r.configs[i].Zone.String() == c.Zone.String() {
+			r.configs[i] = c
+			return
+		}
+	}
+	r.configs = append(r.configs, c)
+}
+
+// Radius returns the radius of the wheel in zone z in meters: that of the most specific configuration whose
+// zone contains z, e.g. "rear" for "rear-left", or Default.
+func (r *Radii) Radius(z zone.Zone) float64 {
+	mm, best := float64(r.Default), -1
+	for _, c := range r.configs {
+		if z.Match(c.Zone) && len(c.Zone.Value) > best {
+			mm, best = float64(c.WheelRadius), len(c.Zone.Value)
+		}
+	}
+	return mm / 1000
+}
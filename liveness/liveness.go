@@ -0,0 +1,310 @@
+// Package liveness detects signals that stopped updating and data providers that stopped sending heartbeats,
+// so a value frozen by a dead CAN node is reported unavailable instead of displayed as current.
+//
+// Every signal has an expected update period, configured per type or signal key or learned from the intervals
+// between its samples. A signal is stale once no sample arrived for Factor periods, and unavailable while it
+// is stale or its provider is dead. Tick must be called periodically so signals go stale without new samples.
+package liveness
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+)
+
+// A Provider supplies a set of signals, e.g. a CAN node or a deriver.
+type Provider struct {
+	// Expected heartbeat period; default 1s
+	Period time.Duration
+	// Type names or signal keys supplied by the provider. A sample of one of them, or with the provider name as
+	// its Source, counts as a heartbeat.
+	Signals []string
+}
+
+// Config configures a Monitor. Zero values select the defaults noted on each field.
+type Config struct {
+	// Expected update periods by type name or signal key, the key taking precedence. A negative period
+	// disables monitoring of the signal.
+	Periods map[string]time.Duration
+	// Intervals averaged before a period is learned for a signal without a configured one; default 10,
+	// negative disables learning
+	LearnSamples int
+	// Periods without an update before a signal or provider is stale; default 3
+	Factor float64
+	// Shortest timeout; default 100ms
+	MinTimeout time.Duration
+	Providers  map[string]Provider
+}
+
+func (c *Config) defaults() {
+	if c.LearnSamples == 0 {
+		c.LearnSamples = 10
+	}
+	if c.Factor == 0 {
+		c.Factor = 3
+	}
+	if c.MinTimeout == 0 {
+		c.MinTimeout = 100 * time.Millisecond
+	}
+	for name, p := range c.Providers {
+		if p.Period == 0 {
+			p.Period = time.Second
+			c.Providers[name] = p
+		}
+	}
+}
+
+// An Event reports a signal or provider becoming unavailable or available again.
+type Event struct {
+	// Signal key, or provider name if Provider is set
+	Key      string
+	Provider bool
+	// True if the signal became unavailable or the provider dead
+	Lost bool
+	Time time.Time
+	// Time of the last sample or heartbeat
+	Last time.Time
+}
+
+// SignalHealth is the health of a signal.
+type SignalHealth struct {
+	Key string
+	// Expected update period, zero while it is being learned
+	Period  time.Duration
+	Learned bool
+	Last    time.Time
+	Age     time.Duration
+	Updates int
+	Stale   bool
+	// Name of the provider of the signal, if any
+	Provider  string
+	Available bool
+}
+
+// ProviderHealth is the health of a provider.
+type ProviderHealth struct {
+	Name   string
+	Period time.Duration
+	// Time of the last heartbeat, zero if none arrived
+	Last  time.Time
+	Alive bool
+}
+
+// A Report is the health of all signals and providers.
+type Report struct {
+	Time      time.Time
+	Signals   []SignalHealth
+	Providers []ProviderHealth
+	// True if every signal is available and every provider alive
+	Healthy bool
+}
+
+type signal struct {
+	sample     vehicledata.Sample
+	period     time.Duration
+	configured bool
+	// mean interval and number of intervals averaged, for learning
+	mean      time.Duration
+	learned   int
+	updates   int
+	stale     bool
+	provider  string
+	available bool
+}
+
+type provider struct {
+	Provider
+	last  time.Time
+	alive bool
+}
+
+// A Monitor tracks the liveness of signals and providers and holds the latest value of every signal. It is safe
+// for concurrent use.
+type Monitor struct {
+	mu        sync.Mutex
+	cfg       Config
+	signals   map[string]*signal
+	providers map[string]*provider
+}
+
+// NewMonitor returns a monitor with the providers of cfg, dead until their first heartbeat.
+func NewMonitor(cfg Config) *Monitor {
+	providers := map[string]Provider{}
+	for name, p := range cfg.Providers {
+		providers[name] = p
+	}
+	cfg.Providers = providers
+	cfg.defaults()
+	m := &Monitor{cfg: cfg, signals: map[string]*signal{}, providers: map[string]*provider{}}
+	for name, p := range cfg.Providers {
+		m.providers[name] = &provider{Provider: p}
+	}
+	return m
+}
+
+// Update records a sample and returns the events it causes.
+func (m *Monitor) Update(s vehicledata.Sample) []Event {
+	if s.Value == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, name := s.Key(), s.Name()
+	sig := m.signals[key]
+	if sig == nil {
+		sig = &signal{provider: m.providerOf(name, key)}
+		if p, ok := m.cfg.Periods[key]; ok {
+			sig.period, sig.configured = p, true
+		} else if p, ok := m.cfg.Periods[name]; ok {
+			sig.period, sig.configured = p, true
+		}
+		m.signals[key] = sig
+	} else if d := s.Time.Sub(sig.sample.Time); !sig.configured && m.cfg.LearnSamples > 0 && d > 0 && !sig.stale &&
+		(sig.period == 0 || d <= m.timeout(sig.period)) {
+		// an outage is not an update interval
+		m.learn(sig, d)
+	}
+	sig.sample, sig.stale = s, false
+	sig.updates++
+	var out []Event
+	for pname, p := range m.providers {
+		if pname == s.Source || pname == sig.provider {
+			out = append(out, m.heartbeat(pname, p, s.Time)...)
+		}
+	}
+	return append(out, m.refresh(s.Time)...)
+}
+
+// Heartbeat records a heartbeat of the named provider and returns the events it causes. Heartbeats of unknown
+// providers are ignored.
+func (m *Monitor) Heartbeat(name string, t time.Time) []Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := m.providers[name]
+	if p == nil {
+		return nil
+	}
+	return append(m.heartbeat(name, p, t), m.refresh(t)...)
+}
+
+// Tick checks for timeouts at time now and returns the events they cause.
+func (m *Monitor) Tick(now time.Time) []Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []Event
+	for name, p := range m.providers {
+		if p.alive && now.Sub(p.last) > m.timeout(p.Period) {
+			p.alive = false
+			out = append(out, Event{Key: name, Provider: true, Lost: true, Time: now, Last: p.last})
+		}
+	}
+	for _, sig := range m.signals {
+		if !sig.stale && sig.period > 0 && now.Sub(sig.sample.Time) > m.timeout(sig.period) {
+			sig.stale = true
+		}
+	}
+	return append(out, m.refresh(now)...)
+}
+
+// Get returns the latest sample of the signal key and whether it is available: known, not stale and with its
+// provider alive.
+func (m *Monitor) Get(key string) (vehicledata.Sample, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sig := m.signals[key]
+	if sig == nil {
+		return vehicledata.Sample{}, false
+	}
+	return sig.sample, sig.available
+}
+
+// Report returns the health of all signals and providers at time now, ordered by key and name.
+func (m *Monitor) Report(now time.Time) Report {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := Report{Time: now, Healthy: true}
+	for key, sig := range m.signals {
+		r.Signals = append(r.Signals, SignalHealth{
+			Key:       key,
+			Period:    sig.period,
+			Learned:   !sig.configured && sig.period > 0,
+			Last:      sig.sample.Time,
+			Age:       now.Sub(sig.sample.Time),
+			Updates:   sig.updates,
+			Stale:     sig.stale,
+			Provider:  sig.provider,
+			Available: sig.available,
+		})
+		r.Healthy = r.Healthy && sig.available
+	}
+	for name, p := range m.providers {
+		r.Providers = append(r.Providers, ProviderHealth{Name: name, Period: p.Period, Last: p.last, Alive: p.alive})
+		r.Healthy = r.Healthy && p.alive
+	}
+	sort.Slice(r.Signals, func(i, j int) bool { return r.Signals[i].Key < r.Signals[j].Key })
+	sort.Slice(r.Providers, func(i, j int) bool { return r.Providers[i].Name < r.Providers[j].Name })
+	return r
+}
+
+// learn averages the interval d into the period of sig: a running mean until LearnSamples intervals are
+// seen, then an exponential average.
+func (m *Monitor) learn(sig *signal, d time.Duration) {
+	if sig.learned < m.cfg.LearnSamples {
+		sig.learned++
+	}
+	sig.mean += (d - sig.mean) / time.Duration(sig.learned)
+	if sig.learned == m.cfg.LearnSamples {
+		sig.period = sig.mean
+	}
+}
+
+func (m *Monitor) heartbeat(name string, p *provider, t time.Time) []Event {
+	if t.After(p.last) {
+		p.last = t
+	}
+	if p.alive {
+		return nil
+	}
+	p.alive = true
+	return []Event{{Key: name, Provider: true, Time: t, Last: p.last}}
+}
+
+// refresh updates the availability of every signal and returns the changes.
+func (m *Monitor) refresh(now time.Time) []Event {
+	var out []Event
+	for key, sig := range m.signals {
+		avail := !sig.stale
+		if p := m.providers[sig.provider]; p != nil && !p.alive {
+			avail = false
+		}
+		if avail == sig.available {
+			continue
+		}
+		sig.available = avail
+		out = append(out, Event{Key: key, Lost: !avail, Time: now, Last: sig.sample.Time})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+// providerOf returns the name of the provider supplying the signal, or "".
+func (m *Monitor) providerOf(name, key string) string {
+	for pname, p := range m.providers {
+		for _, s := range p.Signals {
+			if s == key || s == name {
+				return pname
+			}
+		}
+	}
+	return ""
+}
+
+func (m *Monitor) timeout(period time.Duration) time.Duration {
+	t := time.Duration(m.cfg.Factor * float64(period))
+	if t < m.cfg.MinTimeout {
+		return m.cfg.MinTimeout
+	}
+	return t
+}
@@ -0,0 +1,336 @@
+// Package odometer computes the Odometer of vehicles that do not broadcast one, by integrating the wheel tick
+// rates of WheelTick with the wheel radii of WheelConfiguration.
+//
+// The vehicle speed is taken as the median of the wheel speeds, so a single spinning or locked wheel does not
+// distort the distance. Wheels deviating from the median are flagged as slipping, and the distance is checked
+// against VehicleSpeed over longer stretches to detect a wheel radius that does not match the tires fitted.
+// Without fresh wheel ticks the distance falls back to VehicleSpeed.
+//
+// The total distance is saved to a state file so it survives power cycles:
+//
+//	o, err := odometer.Open("/var/lib/vehicle/odometer.json", odometer.Config{TicksPerRevolution: 48})
+//	...
+//	defer o.Close()
+//	for smp := range samples {
+//		for _, s := range o.Update(smp) {
+//			// s.Value is a vehicledata.Odometer
+//		}
+//	}
+package odometer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+	"github.com/calvernaz/w3c-vehicle-data/internal/statefile"
+	"github.com/calvernaz/w3c-vehicle-data/internal/wheelradius"
+	"github.com/calvernaz/w3c-vehicle-data/types/vehicle-power"
+	"github.com/calvernaz/w3c-vehicle-data/types/zone"
+)
+
+// Config configures an odometer. Zero values select the defaults noted on each field.
+type Config struct {
+	// Wheel ticks per wheel revolution (default 48)
+	TicksPerRevolution float64
+	// Wheel radius used until a WheelConfiguration sample arrives (Unit: millimeters, default 300)
+	WheelRadius uint16
+	// Samples further apart than this are a gap that is not integrated (default 2 s)
+	MaxGap time.Duration
+	// Relative deviation of a wheel from the median wheel speed that counts as slip (default 0.15)
+	SlipRatio float64
+	// Wheel speed below which slip is not evaluated (Unit: meters per second, default 2)
+	SlipMinSpeed float64
+	// Distance over which the wheel distance is compared with VehicleSpeed (Unit: meters, default 2000)
+	CheckDistance float64
+	// Relative difference between wheel distance and VehicleSpeed that flags a radius mismatch (default 0.03)
+	RadiusTolerance float64
+	// Minimum time between Odometer samples (default 1 s)
+	EmitInterval time.Duration
+	// Minimum time between automatic saves of the state file (default 1 minute)
+	SaveInterval time.Duration
+}
+
+func (c *Config) defaults() {
+	if c.TicksPerRevolution <= 0 {
+		c.TicksPerRevolution = 48
+	}
+	if c.WheelRadius == 0 {
+		c.WheelRadius = 300
+	}
+	if c.MaxGap <= 0 {
+		c.MaxGap = 2 * time.Second
+	}
+	if c.SlipRatio <= 0 {
+		c.SlipRatio = 0.15
+	}
+	if c.SlipMinSpeed <= 0 {
+		c.SlipMinSpeed = 2
+	}
+	if c.CheckDistance <= 0 {
+		c.CheckDistance = 2000
+	}
+	if c.RadiusTolerance <= 0 {
+		c.RadiusTolerance = 0.03
+	}
+	if c.EmitInterval <= 0 {
+		c.EmitInterval = time.Second
+	}
+	if c.SaveInterval <= 0 {
+		c.SaveInterval = time.Minute
+	}
+}
+
+// Status reports the plausibility of the wheel inputs.
+type Status struct {
+	// Signal keys of the wheels currently slipping, e.g. "WheelTick[rear-left]"
+	Slipping []string
+	// Wheel distance divided by the VehicleSpeed distance over the last completed check, 0 before the first
+	RadiusRatio float64
+	// True if RadiusRatio is off by more than RadiusTolerance: the configured radius does not match the tires
+	RadiusMismatch bool
+}
+
+// An Odometer integrates wheel ticks into distance. It is not safe for concurrent use.
+type Odometer struct {
+	cfg  Config
+	path string
+
+	// wheel tick rates by zone key, with the time of the last sample
+	wheels map[string]*wheel
+	// wheel radii by zone
+	radii wheelradius.Radii
+
+	// VehicleSpeed in meters per second
+	refSpeed     float64
+	refTime      time.Time
+	refSaturated bool
+
+	// current speed estimate in meters per second, and the time of the last integration
+	speed     float64
+	fromTicks bool
+	last      time.Time
+
+	sinceStart float64
+	total      float64
+	power      vehicle_power.VehiclePowerMode
+
+	// radius check accumulators
+	checkWheel, checkRef float64
+	status               Status
+
+	now      time.Time
+	lastEmit time.Time
+	emitted  [2]uint64
+	lastSave time.Time
+	unsaved  bool
+	err      error
+}
+
+type wheel struct {
+	zone zone.Zone
+	rate float64
+	time time.Time
+}
+
+// state is the content of the state file.
+type state struct {
+	// Total distance (Unit: meters)
+	Total float64
+}
+
+// New returns an odometer that is not backed by a state file, starting at total meters.
+func New(cfg Config, total float64) *Odometer {
+	cfg.defaults()
+	return &Odometer{cfg: cfg, wheels: map[string]*wheel{}, total: total, radii: wheelradius.Radii{Default: cfg.WheelRadius}}
+}
+
+// Open returns an odometer that saves its total distance to the state file at path, restoring it if the file
+// exists.
+func Open(path string, cfg Config) (*Odometer, error) {
+	o := New(cfg, 0)
+	o.path = path
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var st state
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, fmt.Errorf("odometer: state file %s: %v", path, err)
+	}
+	o.total = st.Total
+	return o, nil
+}
+
+// Odometer returns the current distances.
+func (o *Odometer) Odometer() vehicledata.Odometer {
+	return vehicledata.Odometer{DistanceSinceStart: uint64(o.sinceStart), DistanceTotal: uint64(o.total)}
+}
+
+// Status returns the plausibility of the wheel inputs.
+func (o *Odometer) Status() Status {
+	st := o.status
+	st.Slipping = append([]string(nil), o.status.Slipping...)
+	return st
+}
+
+// Update processes a sample. It returns an Odometer sample at most every EmitInterval while a distance
+// changes by a whole meter. Switching the power mode to running starts a new DistanceSinceStart.
+func (o *Odometer) Update(s vehicledata.Sample) []vehicledata.Sample {
+	if s.Time.After(o.now) {
+		o.now = s.Time
+	}
+	switch v := s.Value.(type) {
+	case vehicledata.WheelTick:
+		w, ok := o.wheels[v.Zone.String()]
+		if !ok {
+			w = &wheel{zone: v.Zone}
+			o.wheels[v.Zone.String()] = w
+		}
+		w.rate, w.time = float64(v.Value), s.Time
+	case vehicledata.WheelConfiguration:
+		o.radii.Set(v)
+		return nil
+	case vehicledata.VehicleSpeed:
+		o.refSpeed, o.refTime = float64(v.Speed)/3600, s.Time
+		o.refSaturated = v.Speed == math.MaxUint16
+	case vehicledata.VehiclePowerModeType:
+		if v.Value == vehicle_power.Running && o.power != vehicle_power.Running {
+			o.sinceStart = 0
+		}
+		if v.Value != vehicle_power.Running && o.power == vehicle_power.Running && o.path != "" {
+			o.err = o.Save()
+		}
+		o.power = v.Value
+		return o.emit()
+	default:
+		return nil
+	}
+	o.integrate(s.Time)
+	if o.path != "" && o.unsaved && o.now.Sub(o.lastSave) >= o.cfg.SaveInterval {
+		o.err = o.Save()
+	}
+	return o.emit()
+}
+
+// emit returns an Odometer sample if a distance changed and the emit interval has passed.
+func (o *Odometer) emit() []vehicledata.Sample {
+	od := o.Odometer()
+	cur := [2]uint64{od.DistanceSinceStart, od.DistanceTotal}
+	if cur == o.emitted && !o.lastEmit.IsZero() {
+		return nil
+	}
+	if !o.lastEmit.IsZero() && o.now.Sub(o.lastEmit) < o.cfg.EmitInterval {
+		return nil
+	}
+	o.emitted, o.lastEmit = cur, o.now
+	return []vehicledata.Sample{{Time: o.now, Value: od}}
+}
+
+// integrate advances the distance to time t with the trapezoid rule and updates the speed estimate.
+func (o *Odometer) integrate(t time.Time) {
+	prev, prevTicks := o.speed, o.fromTicks
+	var speeds []float64
+	for _, w := range o.wheels {
+		if t.Sub(w.time) > o.cfg.MaxGap {
+			continue
+		}
+		speeds = append(speeds, o.wheelSpeed(w))
+	}
+	refFresh := !o.refTime.IsZero() && t.Sub(o.refTime) <= o.cfg.MaxGap
+	switch {
+	case len(speeds) > 0:
+		o.speed, o.fromTicks = median(speeds), true
+		o.flagSlip(t)
+	case refFresh:
+		o.speed, o.fromTicks = o.refSpeed, false
+	default:
+		o.speed, o.fromTicks = 0, false
+	}
+
+	if o.last.IsZero() || t.Sub(o.last) > o.cfg.MaxGap || !t.After(o.last) {
+		o.last = t
+		return
+	}
+	dt := t.Sub(o.last).Seconds()
+	o.last = t
+	d := (prev + o.speed) / 2 * dt
+	if d <= 0 {
+		return
+	}
+	o.sinceStart += d
+	o.total += d
+	o.unsaved = true
+
+	if prevTicks && o.fromTicks && refFresh && !o.refSaturated && len(o.status.Slipping) == 0 &&
+		o.refSpeed > o.cfg.SlipMinSpeed {
+		o.checkWheel += d
+		o.checkRef += o.refSpeed * dt
+		if o.checkRef >= o.cfg.CheckDistance {
+			o.status.RadiusRatio = o.checkWheel / o.checkRef
+			o.status.RadiusMismatch = math.Abs(o.status.RadiusRatio-1) > o.cfg.RadiusTolerance
+			o.checkWheel, o.checkRef = 0, 0
+		}
+	}
+}
+
+// flagSlip marks the fresh wheels whose speed deviates from the median speed.
+func (o *Odometer) flagSlip(t time.Time) {
+	o.status.Slipping = o.status.Slipping[:0]
+	for _, w := range o.wheels {
+		if t.Sub(w.time) > o.cfg.MaxGap {
+			continue
+		}
+		v := o.wheelSpeed(w)
+		slip := math.Max(v, o.speed) >= o.cfg.SlipMinSpeed &&
+			math.Abs(v-o.speed) > o.cfg.SlipRatio*math.Max(o.speed, o.cfg.SlipMinSpeed)
+		if slip {
+			o.status.Slipping = append(o.status.Slipping, vehicledata.Key(vehicledata.WheelTick{Zone: w.zone}))
+		}
+	}
+	sort.Strings(o.status.Slipping)
+}
+
+// wheelSpeed returns the circumferential speed of a wheel in meters per second.
+func (o *Odometer) wheelSpeed(w *wheel) float64 {
+	return w.rate / o.cfg.TicksPerRevolution * 2 * math.Pi * o.radii.Radius(w.zone)
+}
+
+func median(x []float64) float64 {
+	sort.Float64s(x)
+	n := len(x)
+	if n%2 == 1 {
+		return x[n/2]
+	}
+	return (x[n/2-1] + x[n/2]) / 2
+}
+
+// Err returns the error of the last automatic save, if any.
+func (o *Odometer) Err() error {
+	return o.err
+}
+
+// Save writes the total distance to the state file. The file is replaced atomically, so a power loss leaves
+// either the old or the new state.
+func (o *Odometer) Save() error {
+	if o.path == "" {
+		return nil
+	}
+	if err := statefile.Save(o.path, state{Total: o.total}); err != nil {
+		return err
+	}
+	o.lastSave, o.unsaved = o.now, false
+	return nil
+}
+
+// Close saves the total distance.
+func (o *Odometer) Close() error {
+	return o.Save()
+}
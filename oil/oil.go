@@ -0,0 +1,418 @@
+// Package oil estimates the engine oil life on vehicles that do not report EngineOil.LifeRemaining and
+// EngineOil.Change.
+//
+// Oil wear is counted in engine revolutions, weighted by the oil temperature (cold oil collects fuel and water,
+// hot oil oxidises) and by idling, plus a fixed cost per cold start. The life used is the largest of this wear
+// against the rated revolutions, the distance against the service distance and the time against the service
+// time, all since the last oil change. The state is saved to a file so it survives power cycles:
+//
+//	l, err := oil.Open("/var/lib/vehicle/oil.json", oil.Config{Grade: oil.Synthetic})
+//	...
+//	defer l.Close()
+//	for smp := range samples {
+//		for _, s := range l.Update(smp) {
+//			// s.Value is a vehicledata.EngineOil
+//		}
+//	}
+//	l.Reset(time.Now()) // after an oil change
+//
+// Vehicles that report only the oil level send an oil temperature of 0, so 0 is taken at face value only after
+// the vehicle reported another oil temperature and while the coolant is cold; otherwise the coolant
+// temperature stands in for it.
+package oil
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+	"github.com/calvernaz/w3c-vehicle-data/internal/statefile"
+)
+
+// Source marks the samples produced by the estimator.
+const Source = "oil"
+
+// Grade of the engine oil, scaling the rated revolutions and service distance.
+type Grade int
+
+const (
+	Synthetic Grade = iota
+	Mineral
+	SemiSynthetic
+	LongLife
+)
+
+var grades = [...]struct {
+	name   string
+	factor float64
+}{
+	{"synthetic", 1},
+	{"mineral", 0.5},
+	{"semi-synthetic", 0.75},
+	{"long-life", 2},
+}
+
+func (g Grade) String() string {
+	if g >= 0 && int(g) < len(grades) {
+		return grades[g].name
+	}
+	return "Grade(" + strconv.Itoa(int(g)) + ")"
+}
+
+func (g Grade) factor() float64 {
+	if g >= 0 && int(g) < len(grades) {
+		return grades[g].factor
+	}
+	return 1
+}
+
+// Config configures an estimator. Zero values select the defaults noted on each field.
+type Config struct {
+	// Oil grade; default Synthetic
+	Grade Grade
+	// Weighted engine revolutions a synthetic oil lasts; default 30 million
+	Revolutions float64
+	// Distance a synthetic oil lasts (Unit: meters); default 15000 km
+	ServiceDistance float64
+	// Time the oil lasts regardless of use; default 365 days
+	ServiceTime time.Duration
+	// Oil temperature below which wear counts ColdFactor times (Unit: celsius); default 60
+	ColdTemperature float64
+	// default 2
+	ColdFactor float64
+	// Oil temperature above which wear doubles every HotDoubling degrees (Unit: celsius); default 120
+	HotTemperature float64
+	// default 10
+	HotDoubling float64
+	// Speed below which a running engine is idling (Unit: meters per hour); default 1000
+	IdleSpeed uint16
+	// Weight of revolutions while idling; default 1.5
+	IdleFactor float64
+	// Temperature below which a start is cold (Unit: celsius); default 40
+	ColdStartTemperature float64
+	// Ignition off time after which a start counts as cold when no temperature is reported; default 2h
+	ColdSoak time.Duration
+	// Time after ignition on to wait for a temperature before falling back to ColdSoak; default 1 minute
+	ColdStartWindow time.Duration
+	// Share of the oil life used by a cold start; default 0.001
+	ColdStartCost float64
+	// LifeRemaining at or below which Change is set (Unit: percentage); default 5
+	ChangeAt float64
+	// Samples further apart than this are a gap and are not integrated; default 5s
+	MaxGap time.Duration
+	// Minimum time between automatic saves of the state file; default 1 minute
+	SaveInterval time.Duration
+}
+
+func (c *Config) defaults() {
+	if c.Revolutions == 0 {
+		c.Revolutions = 30e6
+	}
+	if c.ServiceDistance == 0 {
+		c.ServiceDistance = 15e6
+	}
+	if c.ServiceTime == 0 {
+		c.ServiceTime = 365 * 24 * time.Hour
+	}
+	if c.ColdTemperature == 0 {
+		c.ColdTemperature = 60
+	}
+	if c.ColdFactor == 0 {
+		c.ColdFactor = 2
+	}
+	if c.HotTemperature == 0 {
+		c.HotTemperature = 120
+	}
+	if c.HotDoubling == 0 {
+		c.HotDoubling = 10
+	}
+	if c.IdleSpeed == 0 {
+		c.IdleSpeed = 1000
+	}
+	if c.IdleFactor == 0 {
+		c.IdleFactor = 1.5
+	}
+	if c.ColdStartTemperature == 0 {
+		c.ColdStartTemperature = 40
+	}
+	if c.ColdSoak == 0 {
+		c.ColdSoak = 2 * time.Hour
+	}
+	if c.ColdStartWindow == 0 {
+		c.ColdStartWindow = time.Minute
+	}
+	if c.ColdStartCost == 0 {
+		c.ColdStartCost = 0.001
+	}
+	if c.ChangeAt == 0 {
+		c.ChangeAt = 5
+	}
+	if c.MaxGap == 0 {
+		c.MaxGap = 5 * time.Second
+	}
+	if c.SaveInterval == 0 {
+		c.SaveInterval = time.Minute
+	}
+}
+
+// Status is the oil state since the last change. It is the content of the state file.
+type Status struct {
+	// Time of the last oil change, or of the first sample if none was recorded
+	Since time.Time
+	// Distance driven (Unit: meters)
+	Distance float64
+	// Engine revolutions weighted by temperature and idling
+	Revolutions float64
+	EngineTime  time.Duration
+	IdleTime    time.Duration
+	Starts      int
+	ColdStarts  int
+	// Ignition on time of the last start counted
+	LastStart time.Time
+	// Remaining oil life (Unit: percentage); not saved
+	LifeRemaining float64 `json:"-"`
+	Change        bool    `json:"-"`
+}
+
+// An Estimator estimates the oil life. It is not safe for concurrent use.
+type Estimator struct {
+	cfg  Config
+	path string
+	st   Status
+	now  time.Time
+
+	rpm      float64
+	rpmTime  time.Time
+	speed    float64
+	spdTime  time.Time
+	oilTemp  float64
+	haveOil  bool
+	oilSeen  bool
+	coolTemp float64
+	haveCool bool
+	// last EngineOil read from the vehicle, reported with the estimates
+	oil vehicledata.EngineOil
+	// start waiting for a temperature to tell whether it was cold
+	pending  bool
+	lastOff  time.Time
+	reported float64
+	emitted  bool
+	lastSave time.Time
+	unsaved  bool
+	err      error
+}
+
+// New returns an estimator for fresh oil that is not backed by a state file.
+func New(cfg Config) *Estimator {
+	cfg.defaults()
+	return &Estimator{cfg: cfg}
+}
+
+// Open returns an estimator that saves its state to the file at path, restoring it if the file exists.
+func Open(path string, cfg Config) (*Estimator, error) {
+	e := New(cfg)
+	e.path = path
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return e, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &e.st); err != nil {
+		return nil, fmt.Errorf("oil: state file %s: %v", path, err)
+	}
+	return e, nil
+}
+
+// Status returns the oil state.
+func (e *Estimator) Status() Status {
+	st := e.st
+	st.LifeRemaining = e.life()
+	st.Change = st.LifeRemaining <= e.cfg.ChangeAt
+	return st
+}
+
+// Reset records an oil change at time t.
+func (e *Estimator) Reset(t time.Time) {
+	e.st = Status{Since: t, LastStart: e.st.LastStart}
+	e.emitted, e.unsaved = false, true
+}
+
+// Update processes a sample. It returns an EngineOil sample when the remaining life changes by a whole
+// percent or Change flips, carrying the latest EngineOil values read from the vehicle.
+func (e *Estimator) Update(s vehicledata.Sample) []vehicledata.Sample {
+	if s.Source == Source {
+		return nil
+	}
+	if s.Time.After(e.now) {
+		e.now = s.Time
+	}
+	if e.st.Since.IsZero() {
+		e.st.Since = s.Time
+	}
+	switch v := s.Value.(type) {
+	case vehicledata.EngineSpeed:
+		e.engineSample(s.Time, float64(v.Speed))
+	case vehicledata.VehicleSpeed:
+		e.speedSample(s.Time, float64(v.Speed))
+	case vehicledata.EngineOil:
+		e.oil = v
+		if v.Temperature != 0 {
+			e.oilSeen = true
+		}
+		e.oilTemp, e.haveOil = float64(v.Temperature), true
+		e.checkOilTemp()
+		e.coldStart(s.Time)
+	case vehicledata.EngineCoolant:
+		e.coolTemp, e.haveCool = float64(v.Temperature), true
+		e.checkOilTemp()
+		e.coldStart(s.Time)
+	case vehicledata.IgnitionTime:
+		e.ignition(v)
+	default:
+		return nil
+	}
+	if e.path != "" && e.unsaved && e.now.Sub(e.lastSave) >= e.cfg.SaveInterval {
+		e.err = e.Save()
+	}
+	life := e.life()
+	change := life <= e.cfg.ChangeAt
+	if e.emitted && math.Floor(life) == math.Floor(e.reported) && change == (e.reported <= e.cfg.ChangeAt) {
+		return nil
+	}
+	e.emitted, e.reported = true, life
+	out := e.oil
+	out.LifeRemaining, out.Change = uint16(math.Ceil(life)), change
+	return []vehicledata.Sample{{Time: e.now, Value: out, Source: Source}}
+}
+
+// life returns the remaining oil life in percent.
+func (e *Estimator) life() float64 {
+	f := e.cfg.Grade.factor()
+	used := e.st.Revolutions/(e.cfg.Revolutions*f) + float64(e.st.ColdStarts)*e.cfg.ColdStartCost
+	used = math.Max(used, e.st.Distance/(e.cfg.ServiceDistance*f))
+	if !e.st.Since.IsZero() && e.now.After(e.st.Since) {
+		used = math.Max(used, float64(e.now.Sub(e.st.Since))/float64(e.cfg.ServiceTime))
+	}
+	return math.Max(0, 100*(1-used))
+}
+
+// weight returns the wear weight of a revolution at the current oil temperature.
+func (e *Estimator) weight() float64 {
+	t, ok := e.oilTemp, e.haveOil
+	if !ok {
+		t, ok = e.coolTemp, e.haveCool
+	}
+	if !ok {
+		return 1
+	}
+	switch {
+	case t < e.cfg.ColdTemperature:
+		return e.cfg.ColdFactor
+	case t > e.cfg.HotTemperature:
+		return math.Pow(2, (t-e.cfg.HotTemperature)/e.cfg.HotDoubling)
+	}
+	return 1
+}
+
+func (e *Estimator) engineSample(t time.Time, rpm float64) {
+	prev, prevTime := e.rpm, e.rpmTime
+	e.rpm, e.rpmTime = rpm, t
+	if e.pending && t.Sub(e.st.LastStart) >= e.cfg.ColdStartWindow {
+		// no temperature reported: judge the start by the time the engine was off
+		e.pending = false
+		if !e.lastOff.IsZero() && e.st.LastStart.Sub(e.lastOff) >= e.cfg.ColdSoak {
+			e.st.ColdStarts++
+		}
+	}
+	dt := t.Sub(prevTime)
+	if prevTime.IsZero() || dt <= 0 || dt > e.cfg.MaxGap || prev+rpm == 0 {
+		return
+	}
+	w := e.weight()
+	if e.speed < float64(e.cfg.IdleSpeed) && !e.spdTime.IsZero() && t.Sub(e.spdTime) <= e.cfg.MaxGap {
+		w *= e.cfg.IdleFactor
+		e.st.IdleTime += dt
+	}
+	e.st.Revolutions += (prev + rpm) / 2 * dt.Minutes() * w
+	e.st.EngineTime += dt
+	e.unsaved = true
+}
+
+// speedSample integrates the distance; speed is in meters per hour.
+func (e *Estimator) speedSample(t time.Time, speed float64) {
+	prev, prevTime := e.speed, e.spdTime
+	e.speed, e.spdTime = speed, t
+	dt := t.Sub(prevTime)
+	if prevTime.IsZero() || dt <= 0 || dt > e.cfg.MaxGap {
+		return
+	}
+	e.st.Distance += (prev + speed) / 2 * dt.Hours()
+	e.unsaved = true
+}
+
+func (e *Estimator) ignition(v vehicledata.IgnitionTime) {
+	if !v.IgnitionOffTime.IsZero() {
+		e.lastOff = v.IgnitionOffTime
+	}
+	if v.IgnitionOnTime.IsZero() || !v.IgnitionOnTime.After(e.st.LastStart) {
+		return
+	}
+	e.st.LastStart = v.IgnitionOnTime
+	e.st.Starts++
+	e.pending, e.unsaved = true, true
+	// temperatures read before this start are stale
+	e.haveOil, e.haveCool = false, false
+	e.rpmTime, e.spdTime = time.Time{}, time.Time{}
+}
+
+// checkOilTemp discards an oil temperature of 0 that is not credible: from a vehicle that never reported
+// another one, or while the coolant is warm.
+func (e *Estimator) checkOilTemp() {
+	if e.haveOil && e.oilTemp == 0 && (!e.oilSeen || e.haveCool && e.coolTemp >= e.cfg.ColdTemperature) {
+		e.haveOil = false
+	}
+}
+
+// coldStart judges a pending start by the first temperature read after it.
+func (e *Estimator) coldStart(t time.Time) {
+	if !e.pending || t.Before(e.st.LastStart) || !e.haveOil && !e.haveCool {
+		return
+	}
+	e.pending = false
+	temp := e.coolTemp
+	if e.haveOil {
+		temp = e.oilTemp
+	}
+	if temp < e.cfg.ColdStartTemperature {
+		e.st.ColdStarts++
+	}
+}
+
+// Err returns the error of the last automatic save, if any.
+func (e *Estimator) Err() error {
+	return e.err
+}
+
+// Save writes the state to the state file. The file is replaced atomically, so a power loss leaves either the
+// old or the new state.
+func (e *Estimator) Save() error {
+	if e.path == "" {
+		return nil
+	}
+	if err := statefile.Save(e.path, e.st); err != nil {
+		return err
+	}
+	e.lastSave, e.unsaved = e.now, false
+	return nil
+}
+
+// Close saves the state.
+func (e *Estimator) Close() error {
+	return e.Save()
+}
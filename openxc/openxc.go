@@ -0,0 +1,339 @@
+// Package openxc converts between OpenXC JSON messages and vehicle data samples, so public OpenXC traces can
+// be replayed as vehicle data and vehicle data can be exported to OpenXC tools.
+//
+// OpenXC traces hold one JSON message per line:
+//
+//	{"name":"vehicle_speed","value":42.5,"timestamp":1332794184.319}
+//	{"name":"door_status","value":"driver","event":true,"timestamp":1332794184.402}
+//
+// Values are converted between OpenXC units and the units of the vehicle data types, e.g. vehicle_speed in
+// km/h to VehicleSpeed.Speed in meters per hour.
+package openxc
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+	"github.com/calvernaz/w3c-vehicle-data/types/door-open-status"
+	"github.com/calvernaz/w3c-vehicle-data/types/parking-brake-status"
+	"github.com/calvernaz/w3c-vehicle-data/types/transmission-mode"
+	"github.com/calvernaz/w3c-vehicle-data/types/vehicle-power"
+)
+
+// A Message is an OpenXC vehicle message.
+type Message struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+	// Event qualifies the value of evented messages such as door_status
+	Event interface{} `json:"event,omitempty"`
+	// Seconds since the Unix epoch
+	Timestamp float64 `json:"timestamp,omitempty"`
+}
+
+// Time returns the message timestamp as a time.
+func (m Message) Time() time.Time {
+	sec, frac := math.Modf(m.Timestamp)
+	return time.Unix(int64(sec), int64(math.Round(frac*1e9)))
+}
+
+// timestamp converts t to OpenXC seconds.
+func timestamp(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixNano()) / 1e9
+}
+
+// DoorZones maps the OpenXC door_status values to vehicle data zones. The defaults assume a left-hand drive
+// vehicle.
+var DoorZones = map[string]string{
+	"driver":     "front-left",
+	"passenger":  "front-right",
+	"rear_left":  "rear-left",
+	"rear_right": "rear-right",
+}
+
+var gears = []string{"first", "second", "third", "fourth", "fifth", "sixth", "seventh", "eighth", "ninth", "tenth"}
+
+var levers = map[string]transmission_mode.TransmissionMode{
+	"park":    transmission_mode.Park,
+	"reverse": transmission_mode.Reverse,
+	"neutral": transmission_mode.Neutral,
+	"drive":   transmission_mode.Drive,
+	"sport":   transmission_mode.Drive,
+	"low":     transmission_mode.Low,
+}
+
+var ignition = map[string]vehicle_power.VehiclePowerMode{
+	"off":       vehicle_power.Off,
+	"accessory": vehicle_power.Accessory1,
+	"run":       vehicle_power.Running,
+	"start":     vehicle_power.Running,
+}
+
+// A Converter converts OpenXC messages to vehicle data samples. It keeps the last value of every signal, so a
+// message that updates one field of a type (e.g. high_beam_status) yields a LightStatus with the other fields
+// as last seen.
+type Converter struct {
+	state map[string]interface{}
+}
+
+// NewConverter returns a converter with no signal seen yet.
+func NewConverter() *Converter {
+	return &Converter{state: map[string]interface{}{}}
+}
+
+// signal returns the current value of the signal with the given key.
+func (c *Converter) signal(key string) interface{} {
+	v, ok := c.state[key]
+	if !ok {
+		v, _ = vehicledata.NewFromKey(key)
+		c.state[key] = v
+	}
+	return v
+}
+
+// Sample converts an OpenXC message. It returns false for messages without a vehicle data equivalent.
+func (c *Converter) Sample(m Message) (vehicledata.Sample, bool, error) {
+	s, ok, err := c.sample(m)
+	if err != nil {
+		return s, false, fmt.Errorf("openxc: %v", err)
+	}
+	return s, ok, nil
+}
+
+func (c *Converter) sample(m Message) (vehicledata.Sample, bool, error) {
+	key, set, err := convert(m)
+	if err != nil || key == "" {
+		return vehicledata.Sample{}, false, err
+	}
+	v := c.signal(key)
+	if err := set(v); err != nil {
+		return vehicledata.Sample{}, false, fmt.Errorf("%s: %v", m.Name, err)
+	}
+	return vehicledata.Sample{Time: m.Time(), Value: reflect.ValueOf(v).Elem().Interface()}, true, nil
+}
+
+// number returns v as a number, accepting JSON numbers and booleans.
+func number(name string, v interface{}) (float64, error) {
+	switch x := v.(type) {
+	case float64:
+		return x, nil
+	case bool:
+		if x {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, fmt.Errorf("%s: expected a number, got %T", name, v)
+}
+
+// text returns v as a string.
+func text(name string, v interface{}) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return "", fmt.Errorf("%s: expected a string, got %T", name, v)
+}
+
+// field returns a setter for a numeric field, scaling the OpenXC value.
+func field(name string, value interface{}, f string, scale float64) (func(interface{}) error, error) {
+	x, err := number(name, value)
+	if err != nil {
+		return nil, err
+	}
+	return func(v interface{}) error { return vehicledata.SetField(v, f, x*scale) }, nil
+}
+
+// convert returns the vehicle data key of an OpenXC message and a function applying the message to its value.
+// It returns an empty key for messages without a vehicle data equivalent.
+func convert(m Message) (string, func(interface{}) error, error) {
+	var (
+		set func(interface{}) error
+		err error
+	)
+	switch m.Name {
+	case "vehicle_speed":
+		// km/h to meters per hour
+		set, err = field(m.Name, m.Value, "Speed", 1000)
+		return "VehicleSpeed", set, err
+	case "engine_speed":
+		set, err = field(m.Name, m.Value, "Speed", 1)
+		return "EngineSpeed", set, err
+	case "steering_wheel_angle":
+		set, err = field(m.Name, m.Value, "Angle", 1)
+		return "SteeringWheel", set, err
+	case "torque_at_transmission":
+		set, err = field(m.Name, m.Value, "Value", 1)
+		return "PowertrainTorque", set, err
+	case "accelerator_pedal_position":
+		set, err = field(m.Name, m.Value, "Value", 1)
+		return "AcceleratorPedalPosition", set, err
+	case "fuel_level":
+		set, err = field(m.Name, m.Value, "Level", 1)
+		return "Fuel", set, err
+	case "fuel_consumed_since_restart":
+		// liters to milliliters
+		set, err = field(m.Name, m.Value, "FuelConsumedSinceRestart", 1000)
+		return "Fuel", set, err
+	case "odometer":
+		// kilometers to meters
+		set, err = field(m.Name, m.Value, "DistanceTotal", 1000)
+		return "Odometer", set, err
+	case "brake_pedal_status":
+		set, err = field(m.Name, m.Value, "BrakePedalDepressed", 1)
+		return "BrakeOperation", set, err
+	case "headlamp_status":
+		set, err = field(m.Name, m.Value, "Head", 1)
+		return "LightStatus", set, err
+	case "high_beam_status":
+		set, err = field(m.Name, m.Value, "HighBeam", 1)
+		return "LightStatus", set, err
+	case "parking_brake_status":
+		on, err := number(m.Name, m.Value)
+		return "ParkingBrake", func(v interface{}) error {
+			v.(*vehicledata.ParkingBrake).Status = parking_braking_status.Inactive
+			if on != 0 {
+				v.(*vehicledata.ParkingBrake).Status = parking_braking_status.Active
+			}
+			return nil
+		}, err
+	case "ignition_status":
+		s, err := text(m.Name, m.Value)
+		mode, ok := ignition[s]
+		if err == nil && !ok {
+			err = fmt.Errorf("%s: unknown value %q", m.Name, s)
+		}
+		return "VehiclePowerModeType", func(v interface{}) error {
+			v.(*vehicledata.VehiclePowerModeType).Value = mode
+			return nil
+		}, err
+	case "transmission_gear_position":
+		s, err := text(m.Name, m.Value)
+		return "Transmission", func(v interface{}) error {
+			t := v.(*vehicledata.Transmission)
+			switch s {
+			case "reverse":
+				t.Gear, t.Mode = 0, transmission_mode.Reverse
+			case "neutral":
+				t.Gear, t.Mode = 0, transmission_mode.Neutral
+			default:
+				for i, g := range gears {
+					if g == s {
+						t.Gear = byte(i + 1)
+						return nil
+					}
+				}
+				return fmt.Errorf("unknown gear %q", s)
+			}
+			return nil
+		}, err
+	case "gear_lever_position":
+		s, err := text(m.Name, m.Value)
+		mode, ok := levers[s]
+		if err == nil && !ok {
+			// manual gate positions ("first", ...) have no transmission mode
+			return "", nil, nil
+		}
+		return "Transmission", func(v interface{}) error {
+			v.(*vehicledata.Transmission).Mode = mode
+			return nil
+		}, err
+	case "door_status":
+		s, err := text(m.Name, m.Value)
+		if err != nil {
+			return "", nil, err
+		}
+		z, ok := DoorZones[s]
+		if !ok {
+			return "", nil, fmt.Errorf("%s: unknown door %q", m.Name, s)
+		}
+		open, err := number(m.Name, m.Event)
+		return "Door[" + z + "]", func(v interface{}) error {
+			v.(*vehicledata.Door).Status = door_open_status.Closed
+			if open != 0 {
+				v.(*vehicledata.Door).Status = door_open_status.Open
+			}
+			return nil
+		}, err
+	}
+	return "", nil, nil
+}
+
+// Messages converts a vehicle data sample to OpenXC messages. Types without an OpenXC equivalent yield none.
+func Messages(s vehicledata.Sample) []Message {
+	ts := timestamp(s.Time)
+	msg := func(name string, value interface{}) Message {
+		return Message{Name: name, Value: value, Timestamp: ts}
+	}
+	v := reflect.Indirect(reflect.ValueOf(s.Value)).Interface()
+	switch x := v.(type) {
+	case vehicledata.VehicleSpeed:
+		return []Message{msg("vehicle_speed", float64(x.Speed)/1000)}
+	case vehicledata.EngineSpeed:
+		return []Message{msg("engine_speed", float64(x.Speed))}
+	case vehicledata.SteeringWheel:
+		return []Message{msg("steering_wheel_angle", float64(x.Angle))}
+	case vehicledata.PowertrainTorque:
+		return []Message{msg("torque_at_transmission", float64(x.Value))}
+	case vehicledata.AcceleratorPedalPosition:
+		return []Message{msg("accelerator_pedal_position", float64(x.Value))}
+	case vehicledata.Fuel:
+		return []Message{
+			msg("fuel_level", float64(x.Level)),
+			msg("fuel_consumed_since_restart", float64(x.FuelConsumedSinceRestart)/1000),
+		}
+	case vehicledata.Odometer:
+		return []Message{msg("odometer", float64(x.DistanceTotal)/1000)}
+	case vehicledata.BrakeOperation:
+		return []Message{msg("brake_pedal_status", x.BrakePedalDepressed)}
+	case vehicledata.LightStatus:
+		return []Message{msg("headlamp_status", x.Head), msg("high_beam_status", x.HighBeam)}
+	case vehicledata.ParkingBrake:
+		return []Message{msg("parking_brake_status", x.Status == parking_braking_status.Active)}
+	case vehicledata.VehiclePowerModeType:
+		switch x.Value {
+		case vehicle_power.Off:
+			return []Message{msg("ignition_status", "off")}
+		case vehicle_power.Accessory1, vehicle_power.Accessory2:
+			return []Message{msg("ignition_status", "accessory")}
+		case vehicle_power.Running:
+			return []Message{msg("ignition_status", "run")}
+		}
+	case vehicledata.Transmission:
+		var ms []Message
+		switch {
+		case x.Mode == transmission_mode.Reverse:
+			ms = append(ms, msg("transmission_gear_position", "reverse"))
+		case x.Mode == transmission_mode.Neutral || x.Mode == transmission_mode.Park:
+			ms = append(ms, msg("transmission_gear_position", "neutral"))
+		case x.Gear >= 1 && int(x.Gear) <= len(gears):
+			ms = append(ms, msg("transmission_gear_position", gears[x.Gear-1]))
+		}
+		switch x.Mode {
+		case transmission_mode.Park:
+			ms = append(ms, msg("gear_lever_position", "park"))
+		case transmission_mode.Reverse:
+			ms = append(ms, msg("gear_lever_position", "reverse"))
+		case transmission_mode.Neutral:
+			ms = append(ms, msg("gear_lever_position", "neutral"))
+		case transmission_mode.Low:
+			ms = append(ms, msg("gear_lever_position", "low"))
+		case transmission_mode.Drive, transmission_mode.Overdrive:
+			ms = append(ms, msg("gear_lever_position", "drive"))
+		}
+		return ms
+	case vehicledata.Door:
+		for name, z := range DoorZones {
+			if x.Zone.String() == z {
+				m := msg("door_status", name)
+				m.Event = x.Status == door_open_status.Open || x.Status == door_open_status.Ajar
+				return []Message{m}
+			}
+		}
+	}
+	return nil
+}
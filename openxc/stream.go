@@ -0,0 +1,82 @@
+package openxc
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+)
+
+// A Reader reads vehicle data samples from an OpenXC trace file or a live OpenXC stream. Messages without a
+// vehicle data equivalent, such as latitude, are skipped.
+type Reader struct {
+	sc   *bufio.Scanner
+	c    *Converter
+	line int
+}
+
+// NewReader returns a reader of the newline delimited OpenXC messages in r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{sc: bufio.NewScanner(r), c: NewConverter()}
+}
+
+// Next returns the next sample. It returns io.EOF at the end of the input.
+func (r *Reader) Next() (vehicledata.Sample, error) {
+	for r.sc.Scan() {
+		r.line++
+		line := strings.TrimSpace(r.sc.Text())
+		// some traces wrap messages in a JSON array or separate them with commas
+		line = strings.TrimSuffix(strings.TrimPrefix(line, "["), "]")
+		line = strings.TrimSuffix(line, ",")
+		if line == "" {
+			continue
+		}
+		var m Message
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			return vehicledata.Sample{}, fmt.Errorf("openxc: line %d: %v", r.line, err)
+		}
+		s, ok, err := r.c.sample(m)
+		if err != nil {
+			return vehicledata.Sample{}, fmt.Errorf("openxc: line %d: %v", r.line, err)
+		}
+		if ok {
+			return s, nil
+		}
+	}
+	if err := r.sc.Err(); err != nil {
+		return vehicledata.Sample{}, err
+	}
+	return vehicledata.Sample{}, io.EOF
+}
+
+// A Writer writes vehicle data samples as newline delimited OpenXC messages. Samples of types without an
+// OpenXC equivalent are skipped.
+type Writer struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+// NewWriter returns a writer to w. Output is buffered; call Flush when done, or after every sample when
+// streaming.
+func NewWriter(w io.Writer) *Writer {
+	bw := bufio.NewWriter(w)
+	return &Writer{w: bw, enc: json.NewEncoder(bw)}
+}
+
+// Write writes the OpenXC messages of a sample.
+func (w *Writer) Write(s vehicledata.Sample) error {
+	for _, m := range Messages(s) {
+		if err := w.enc.Encode(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes buffered messages to the underlying writer.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
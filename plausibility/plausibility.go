@@ -0,0 +1,426 @@
+// Package plausibility checks vehicle data for values out of their physical range and for signals that
+// contradict each other, such as a vehicle moving with the transmission in park.
+//
+// A Checker holds the latest value of every signal and runs the built-in checks, plus any configured ones, on
+// every sample. It reports a violation once it has held for the hold time, and marks samples of the signals
+// involved in an active violation as low quality.
+package plausibility
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+	"github.com/calvernaz/w3c-vehicle-data/types/door-open-status"
+	"github.com/calvernaz/w3c-vehicle-data/types/fuel-type"
+	"github.com/calvernaz/w3c-vehicle-data/types/transmission-mode"
+	"github.com/calvernaz/w3c-vehicle-data/types/vehicle-power"
+)
+
+// Names of the built-in checks.
+const (
+	// A field outside its physical range, see DefaultRanges
+	Range = "range"
+	// VehicleSpeed above Config.ParkSpeed with the transmission in park
+	SpeedInPark = "speed-in-park"
+	// A door open or ajar with VehicleSpeed above Config.DoorSpeed
+	DoorOpenMoving = "door-open-moving"
+	// VehicleSpeed above Config.PowerOffSpeed with the vehicle power off
+	SpeedPowerOff = "speed-power-off"
+	// Speed rising with the engine stopped and the vehicle running, for vehicles not listed as electric
+	EngineStopped = "engine-stopped"
+)
+
+// DefaultRanges are the physical ranges of fields, keyed by type and field name.
+var DefaultRanges = map[string][2]float64{
+	"Tire.Pressure":                   {0, 1000},
+	"Tire.Temperature":                {-50, 200},
+	"EngineCoolant.Level":             {0, 100},
+	"EngineCoolant.Temperature":       {-50, 150},
+	"EngineOil.Level":                 {0, 100},
+	"EngineOil.LifeRemaining":         {0, 100},
+	"EngineOil.Temperature":           {-50, 180},
+	"EngineSpeed.Speed":               {0, 15000},
+	"Fuel.Level":                      {0, 100},
+	"Temperature.InteriorTemperature": {-50, 100},
+	"Temperature.ExteriorTemperature": {-60, 70},
+	"Transmission.Gear":               {0, 10},
+	"TransmissionOil.Wear":            {0, 100},
+	"BrakeMaintenance.FluidLevel":     {0, 100},
+	"BrakeMaintenance.PadWear":        {0, 100},
+	"WasherFluid.Level":               {0, 100},
+	"BatteryStatus.ChargeLevel":       {0, 100},
+}
+
+// A Check finds implausible signals.
+type Check struct {
+	Name string
+	// Types the check reads; it runs on samples of these types, or on every sample if empty
+	Types []string
+	// Duration a finding must persist before it is reported
+	Hold time.Duration
+	// Eval returns the findings given the latest values, including the sample s that triggered the check
+	Eval func(v *Values, s vehicledata.Sample) []Finding
+}
+
+// A Finding is an implausible combination of signals.
+type Finding struct {
+	// Keys of the signals involved, e.g. "VehicleSpeed" or "Door[front-left]"
+	Signals []string
+	Detail  string
+}
+
+// A Violation is a finding that persisted for the hold time of its check.
+type Violation struct {
+	Check   string
+	Signals []string
+	Detail  string
+	// Time the finding was first made
+	Start time.Time
+	// Time the violation was reported
+	Time time.Time
+}
+
+// Config configures a Checker. Zero values select the defaults noted on each field.
+type Config struct {
+	// Hold time of the cross-signal checks; default 1s. Range checks report immediately.
+	Hold time.Duration
+	// Field ranges; default DefaultRanges
+	Ranges map[string][2]float64
+	// Speed above which park is implausible (Unit: meters per hour); default 3000
+	ParkSpeed uint16
+	// Speed above which an open door is implausible (Unit: meters per hour); default 10000
+	DoorSpeed uint16
+	// Speed above which power off is implausible (Unit: meters per hour); default 10000
+	PowerOffSpeed uint16
+	// Speed rise within SpeedRiseWindow implausible with the engine stopped (Unit: meters per hour);
+	// default 3000
+	SpeedRise uint16
+	// Window the speed rise is measured over; default 3s
+	SpeedRiseWindow time.Duration
+	// Checks run in addition to the built-in ones
+	Checks []Check
+	// Names of built-in checks to skip
+	Disabled []string
+}
+
+func (c *Config) defaults() {
+	if c.Hold == 0 {
+		c.Hold = time.Second
+	}
+	if c.Ranges == nil {
+		c.Ranges = DefaultRanges
+	}
+	if c.ParkSpeed == 0 {
+		c.ParkSpeed = 3000
+	}
+	if c.DoorSpeed == 0 {
+		c.DoorSpeed = 10000
+	}
+	if c.PowerOffSpeed == 0 {
+		c.PowerOffSpeed = 10000
+	}
+	if c.SpeedRise == 0 {
+		c.SpeedRise = 3000
+	}
+	if c.SpeedRiseWindow == 0 {
+		c.SpeedRiseWindow = 3 * time.Second
+	}
+}
+
+// Values holds the latest sample of every signal.
+type Values struct {
+	latest map[string]vehicledata.Sample
+	// keys seen per type
+	keys map[string][]string
+}
+
+// Get returns the latest sample of the signal key.
+func (v *Values) Get(key string) (vehicledata.Sample, bool) {
+	s, ok := v.latest[key]
+	return s, ok
+}
+
+// Field returns the named field of the latest value of the signal key, see vehicledata.Field.
+func (v *Values) Field(key, field string) (float64, bool) {
+	s, ok := v.latest[key]
+	if !ok {
+		return 0, false
+	}
+	return vehicledata.Field(s.Value, field)
+}
+
+// Keys returns the keys seen for the type, sorted.
+func (v *Values) Keys(typ string) []string {
+	return v.keys[typ]
+}
+
+func (v *Values) set(s vehicledata.Sample) {
+	key := s.Key()
+	if _, ok := v.latest[key]; !ok {
+		name := s.Name()
+		v.keys[name] = append(v.keys[name], key)
+		sort.Strings(v.keys[name])
+	}
+	v.latest[key] = s
+}
+
+// A finding being held or reported.
+type state struct {
+	check    string
+	signals  []string
+	detail   string
+	start    time.Time
+	reported bool
+	// time the violation was reported
+	time time.Time
+}
+
+func (st *state) violation() Violation {
+	return Violation{Check: st.check, Signals: st.signals, Detail: st.detail, Start: st.start, Time: st.time}
+}
+
+// A Checker checks samples for plausibility. It is not safe for concurrent use.
+type Checker struct {
+	cfg    Config
+	checks []Check
+	values Values
+	states map[string]*state
+}
+
+// NewChecker returns a checker running the built-in checks not disabled and the configured ones.
+func NewChecker(cfg Config) *Checker {
+	cfg.defaults()
+	c := &Checker{
+		cfg:    cfg,
+		values: Values{latest: map[string]vehicledata.Sample{}, keys: map[string][]string{}},
+		states: map[string]*state{},
+	}
+	disabled := map[string]bool{}
+	for _, n := range cfg.Disabled {
+		disabled[n] = true
+	}
+	for _, ch := range c.builtin() {
+		if !disabled[ch.Name] {
+			c.checks = append(c.checks, ch)
+		}
+	}
+	c.checks = append(c.checks, cfg.Checks...)
+	return c
+}
+
+// Update records the sample, runs the checks reading its type and returns the sample, marked low quality if
+// its signal is involved in an active violation, and the violations reported.
+func (c *Checker) Update(s vehicledata.Sample) (vehicledata.Sample, []Violation) {
+	if s.Value == nil {
+		return s, nil
+	}
+	c.values.set(s)
+	key, name := s.Key(), s.Name()
+	var out []Violation
+	for _, ch := range c.checks {
+		if !reads(ch, name) {
+			continue
+		}
+		found := map[string]bool{}
+		for _, f := range ch.Eval(&c.values, s) {
+			id := ch.Name + "|" + strings.Join(f.Signals, ",")
+			found[id] = true
+			st := c.states[id]
+			if st == nil {
+				st = &state{check: ch.Name, signals: f.Signals, start: s.Time}
+				c.states[id] = st
+			}
+			st.detail = f.Detail
+			if !st.reported && s.Time.Sub(st.start) >= ch.Hold {
+				st.reported, st.time = true, s.Time
+				out = append(out, st.violation())
+			}
+		}
+		// findings involving this signal that were not made again are gone
+		for id, st := range c.states {
+			if st.check == ch.Name && !found[id] && contains(st.signals, key) {
+				delete(c.states, id)
+			}
+		}
+	}
+	s.LowQuality = s.LowQuality || c.suspect(key)
+	return s, out
+}
+
+// Active returns the violations reported that still hold, ordered by check and signals.
+func (c *Checker) Active() []Violation {
+	var out []Violation
+	for _, st := range c.states {
+		if st.reported {
+			out = append(out, st.violation())
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Check != out[j].Check {
+			return out[i].Check < out[j].Check
+		}
+		return strings.Join(out[i].Signals, ",") < strings.Join(out[j].Signals, ",")
+	})
+	return out
+}
+
+// suspect reports whether the signal key is involved in a reported violation.
+func (c *Checker) suspect(key string) bool {
+	for _, st := range c.states {
+		if st.reported && contains(st.signals, key) {
+			return true
+		}
+	}
+	return false
+}
+
+func reads(ch Check, name string) bool {
+	if len(ch.Types) == 0 {
+		return true
+	}
+	return contains(ch.Types, name)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Checker) builtin() []Check {
+	cfg := c.cfg
+	rise := &riseWindow{window: cfg.SpeedRiseWindow}
+	return []Check{
+		{Name: Range, Eval: func(v *Values, s vehicledata.Sample) []Finding {
+			var out []Finding
+			name := s.Name()
+			for _, f := range vehicledata.Fields(s.Value) {
+				r, ok := cfg.Ranges[name+"."+f]
+				if !ok {
+					continue
+				}
+				x, _ := vehicledata.Field(s.Value, f)
+				if x < r[0] || x > r[1] {
+					out = append(out, Finding{
+						Signals: []string{s.Key()},
+						Detail:  fmt.Sprintf("%s.%s %g outside [%g, %g]", s.Key(), f, x, r[0], r[1]),
+					})
+				}
+			}
+			return out
+		}},
+		{Name: SpeedInPark, Types: []string{"VehicleSpeed", "Transmission"}, Hold: cfg.Hold,
+			Eval: func(v *Values, _ vehicledata.Sample) []Finding {
+				speed, ok1 := v.Field("VehicleSpeed", "Speed")
+				mode, ok2 := v.Field("Transmission", "Mode")
+				if !ok1 || !ok2 || mode != float64(transmission_mode.Park) || speed <= float64(cfg.ParkSpeed) {
+					return nil
+				}
+				return []Finding{{
+					Signals: []string{"Transmission", "VehicleSpeed"},
+					Detail:  fmt.Sprintf("speed %g m/h in park", speed),
+				}}
+			}},
+		{Name: DoorOpenMoving, Types: []string{"VehicleSpeed", "Door"}, Hold: cfg.Hold,
+			Eval: func(v *Values, _ vehicledata.Sample) []Finding {
+				speed, ok := v.Field("VehicleSpeed", "Speed")
+				if !ok || speed <= float64(cfg.DoorSpeed) {
+					return nil
+				}
+				var out []Finding
+				for _, k := range v.Keys("Door") {
+					st, _ := v.Field(k, "Status")
+					if st == float64(door_open_status.Open) || st == float64(door_open_status.Ajar) {
+						out = append(out, Finding{
+							Signals: []string{k, "VehicleSpeed"},
+							Detail:  fmt.Sprintf("%s open at %g m/h", k, speed),
+						})
+					}
+				}
+				return out
+			}},
+		{Name: SpeedPowerOff, Types: []string{"VehicleSpeed", "VehiclePowerModeType"}, Hold: cfg.Hold,
+			Eval: func(v *Values, _ vehicledata.Sample) []Finding {
+				speed, ok1 := v.Field("VehicleSpeed", "Speed")
+				mode, ok2 := v.Field("VehiclePowerModeType", "Value")
+				if !ok1 || !ok2 || mode != float64(vehicle_power.Off) || speed <= float64(cfg.PowerOffSpeed) {
+					return nil
+				}
+				return []Finding{{
+					Signals: []string{"VehiclePowerModeType", "VehicleSpeed"},
+					Detail:  fmt.Sprintf("speed %g m/h with power off", speed),
+				}}
+			}},
+		{Name: EngineStopped, Types: []string{"VehicleSpeed", "EngineSpeed", "VehiclePowerModeType"}, Hold: cfg.Hold,
+			Eval: func(v *Values, s vehicledata.Sample) []Finding {
+				if s.Name() == "VehicleSpeed" {
+					x, _ := vehicledata.Field(s.Value, "Speed")
+					rise.add(s.Time, x)
+				}
+				rpm, ok1 := v.Field("EngineSpeed", "Speed")
+				mode, ok2 := v.Field("VehiclePowerModeType", "Value")
+				if !ok1 || !ok2 || rpm != 0 || mode != float64(vehicle_power.Running) || electric(v) {
+					return nil
+				}
+				if d := rise.rise(); d > float64(cfg.SpeedRise) {
+					return []Finding{{
+						Signals: []string{"EngineSpeed", "VehiclePowerModeType", "VehicleSpeed"},
+						Detail:  fmt.Sprintf("speed rose %g m/h with the engine stopped", d),
+					}}
+				}
+				return nil
+			}},
+	}
+}
+
+// electric reports whether the fuel configuration lists electric propulsion.
+func electric(v *Values) bool {
+	s, ok := v.Get("FuelConfiguration")
+	if !ok {
+		return false
+	}
+	fc, _ := s.Value.(vehicledata.FuelConfiguration)
+	for _, t := range fc.FuelType {
+		if t == fuel_type.Electric {
+			return true
+		}
+	}
+	return false
+}
+
+// A riseWindow tracks the speed rise within a time window.
+type riseWindow struct {
+	window time.Duration
+	times  []time.Time
+	speeds []float64
+}
+
+func (w *riseWindow) add(t time.Time, speed float64) {
+	w.times = append(w.times, t)
+	w.speeds = append(w.speeds, speed)
+	i := 0
+	for i < len(w.times)-1 && t.Sub(w.times[i]) > w.window {
+		i++
+	}
+	w.times, w.speeds = w.times[i:], w.speeds[i:]
+}
+
+// rise returns the latest speed less the lowest one in the window.
+func (w *riseWindow) rise() float64 {
+	if len(w.speeds) == 0 {
+		return 0
+	}
+	last, low := w.speeds[len(w.speeds)-1], w.speeds[len(w.speeds)-1]
+	for _, x := range w.speeds {
+		if x < low {
+			low = x
+		}
+	}
+	return last - low
+}
@@ -0,0 +1,157 @@
+package record
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+)
+
+// A Reader reads samples from a log in time order.
+type Reader struct {
+	f      *os.File
+	br     *bufio.Reader
+	idx    []entry
+	offset int64
+	// end of the last valid record when the log was opened
+	end         int64
+	first, last int64
+	buf         []byte
+	version     byte
+}
+
+// Open opens the log at path for reading. The log may still be written to; records appended after Open are
+// read as well.
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Reader{f: f}
+	if err := r.init(path); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Reader) init(path string) error {
+	var err error
+	if r.version, err = checkHeader(r.f); err != nil {
+		return err
+	}
+	fi, err := r.f.Stat()
+	if err != nil {
+		return err
+	}
+	if r.idx, err = openIndex(r.f, path+".idx", fi.Size()); err != nil {
+		return err
+	}
+	from := int64(len(magic))
+	count := IndexInterval
+	if len(r.idx) > 0 {
+		from, count = r.idx[len(r.idx)-1].offset, 0
+	}
+	r.first, r.last = -1, -1
+	r.end, err = scan(r.f, from, func(offset int64, b []byte) {
+		if count >= IndexInterval {
+			r.idx = append(r.idx, entry{recordTime(b), offset})
+			count = 0
+		}
+		count++
+		r.last = recordTime(b)
+	})
+	if err != nil {
+		return err
+	}
+	if len(r.idx) > 0 {
+		r.first = r.idx[0].time
+	}
+	return r.seekOffset(int64(len(magic)))
+}
+
+// Start returns the time of the first sample in the log, or the zero time for an empty log.
+func (r *Reader) Start() time.Time {
+	if r.first < 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, r.first)
+}
+
+// End returns the time of the last sample in the log when it was opened, or the zero time for an empty log.
+func (r *Reader) End() time.Time {
+	if r.last < 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, r.last)
+}
+
+// Seek positions the reader at the first sample at or after t.
+func (r *Reader) Seek(t time.Time) error {
+	nt := t.UnixNano()
+	// last index entry strictly before t; samples at t may start in the previous block
+	i := sort.Search(len(r.idx), func(i int) bool { return r.idx[i].time >= nt })
+	from := int64(len(magic))
+	if i > 0 {
+		from = r.idx[i-1].offset
+	}
+	if err := r.seekOffset(from); err != nil {
+		return err
+	}
+	for {
+		b, err := r.br.Peek(headerSize)
+		if err != nil {
+			return nil
+		}
+		n := int(binary.BigEndian.Uint32(b))
+		if n < 9 || n > maxPayload {
+			return nil
+		}
+		b, err = r.br.Peek(headerSize + 8)
+		if err != nil || recordTime(b[headerSize:]) >= nt {
+			return nil
+		}
+		if _, err := r.br.Discard(headerSize + n); err != nil {
+			return nil
+		}
+		r.offset += int64(headerSize + n)
+	}
+}
+
+func (r *Reader) seekOffset(offset int64) error {
+	if _, err := r.f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if r.br == nil {
+		r.br = bufio.NewReader(r.f)
+	} else {
+		r.br.Reset(r.f)
+	}
+	r.offset = offset
+	return nil
+}
+
+// Next returns the next sample. It returns io.EOF at the end of the log.
+func (r *Reader) Next() (vehicledata.Sample, error) {
+	b, err := readRecord(r.br, r.buf)
+	if err != nil {
+		if err == io.ErrUnexpectedEOF && r.offset < r.end {
+			return vehicledata.Sample{}, err
+		}
+		// a torn record at the end of a log that is being written
+		r.seekOffset(r.offset)
+		return vehicledata.Sample{}, io.EOF
+	}
+	r.buf = b
+	r.offset += headerSize + int64(len(b))
+	return decode(b, r.version)
+}
+
+// Close closes the log.
+func (r *Reader) Close() error {
+	return r.f.Close()
+}
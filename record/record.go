@@ -0,0 +1,235 @@
+// Package record writes vehicle data samples to an append-only log file and reads them back, so the exact
+// signal sequence of a vehicle can be replayed later.
+//
+// A log is a header followed by length-prefixed, checksummed records holding the sample time, the vehicle
+// data type name, the sample source, its flags and the value encoded as JSON. The header carries the format
+// version; older logs, which lack the source (version 1) or the flags (version 2), are still read and appended
+// to in their own format. Records are appended in time order. A torn record at the end
+// of the log, left by a crash or power loss, is detected by its checksum and cut off when the log is reopened.
+//
+// Every IndexInterval records the time and offset of a record is appended to a sparse index in a side file
+// named after the log with an ".idx" suffix. The index is only an accelerator for seeking: it is validated
+// against the log on open and rebuilt from the log where it is missing or behind.
+package record
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+)
+
+const (
+	// magic is the log header; the byte at versionOffset is the format version
+	magic         = "VDLOG\x00\x03\n"
+	versionOffset = 6
+	// version is the format written to new logs
+	version = 3
+	// flagLowQuality marks a sample found implausible in the record flags
+	flagLowQuality = 1 << 0
+	// record header: payload length and CRC-32 (Castagnoli) of the payload
+	headerSize = 8
+	// index entry: record time (Unix nanoseconds) and offset
+	entrySize = 16
+	// maxPayload guards against reading garbage lengths from a damaged log
+	maxPayload = 1 << 20
+)
+
+// IndexInterval is the number of records between index entries.
+var IndexInterval = 256
+
+var (
+	// ErrOutOfOrder is returned when a sample is older than the last sample in the log.
+	ErrOutOfOrder = errors.New("record: sample is older than the end of the log")
+	// ErrFormat is returned when a file is not a vehicle data log.
+	ErrFormat = errors.New("record: not a vehicle data log")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// entry is an index entry.
+type entry struct {
+	time   int64
+	offset int64
+}
+
+// encode builds the record payload of a sample in format version v.
+func encode(s vehicledata.Sample, v byte) ([]byte, error) {
+	name := s.Name()
+	if _, ok := vehicledata.New(name); !ok {
+		return nil, fmt.Errorf("record: %T is not a vehicle data type", s.Value)
+	}
+	value, err := json.Marshal(s.Value)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 8, 9+2*binary.MaxVarintLen64+len(name)+len(s.Source)+len(value))
+	binary.BigEndian.PutUint64(b, uint64(s.Time.UnixNano()))
+	b = binary.AppendUvarint(b, uint64(len(name)))
+	b = append(b, name...)
+	if v >= 2 {
+		b = binary.AppendUvarint(b, uint64(len(s.Source)))
+		b = append(b, s.Source...)
+	}
+	if v >= 3 {
+		var flags byte
+		if s.LowQuality {
+			flags |= flagLowQuality
+		}
+		b = append(b, flags)
+	}
+	return append(b, value...), nil
+}
+
+// decode parses a record payload in format version ver.
+func decode(b []byte, ver byte) (vehicledata.Sample, error) {
+	if len(b) < 9 {
+		return vehicledata.Sample{}, errors.New("record: short record")
+	}
+	t := time.Unix(0, int64(binary.BigEndian.Uint64(b)))
+	name, b, ok := readString(b[8:])
+	if !ok {
+		return vehicledata.Sample{}, errors.New("record: bad type name")
+	}
+	var source string
+	if ver >= 2 {
+		if source, b, ok = readString(b); !ok {
+			return vehicledata.Sample{}, errors.New("record: bad source")
+		}
+	}
+	var flags byte
+	if ver >= 3 {
+		if len(b) == 0 {
+			return vehicledata.Sample{}, errors.New("record: short record")
+		}
+		flags, b = b[0], b[1:]
+	}
+	v, ok := vehicledata.New(name)
+	if !ok {
+		return vehicledata.Sample{}, fmt.Errorf("record: unknown vehicle data type %s", name)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return vehicledata.Sample{}, fmt.Errorf("record: %s: %v", name, err)
+	}
+	return vehicledata.Sample{Time: t, Value: derefValue(v), Source: source, LowQuality: flags&flagLowQuality != 0}, nil
+}
+
+// readString reads a length-prefixed string from b and returns the rest of b.
+func readString(b []byte) (string, []byte, bool) {
+	n, k := binary.Uvarint(b)
+	if k <= 0 || uint64(len(b)-k) < n {
+		return "", nil, false
+	}
+	return string(b[k : k+int(n)]), b[k+int(n):], true
+}
+
+// recordTime returns the time of a record payload without decoding it.
+func recordTime(b []byte) int64 {
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+// readRecord reads the record at the current position of r. It returns io.EOF at a clean end of log and
+// io.ErrUnexpectedEOF for a torn or damaged record.
+func readRecord(r io.Reader, buf []byte) ([]byte, error) {
+	var h [headerSize]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, io.ErrUnexpectedEOF
+	}
+	n := binary.BigEndian.Uint32(h[:4])
+	if n < 9 || n > maxPayload {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if cap(buf) < int(n) {
+		buf = make([]byte, n)
+	}
+	buf = buf[:n]
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if crc32.Checksum(buf, crcTable) != binary.BigEndian.Uint32(h[4:]) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return buf, nil
+}
+
+// scan reads the log from offset, calling fn with the offset and payload of every valid record. It returns
+// the offset just past the last valid record.
+func scan(f *os.File, offset int64, fn func(offset int64, payload []byte)) (int64, error) {
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	br := bufio.NewReader(f)
+	var buf []byte
+	for {
+		b, err := readRecord(br, buf)
+		if err != nil {
+			return offset, nil
+		}
+		fn(offset, b)
+		offset += headerSize + int64(len(b))
+		buf = b
+	}
+}
+
+// loadIndex reads the index of a log whose valid records end at size, dropping entries past the end.
+func loadIndex(path string, size int64) ([]entry, error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var idx []entry
+	for ; len(b) >= entrySize; b = b[entrySize:] {
+		e := entry{int64(binary.BigEndian.Uint64(b)), int64(binary.BigEndian.Uint64(b[8:]))}
+		if e.offset >= size || (len(idx) > 0 && e.offset <= idx[len(idx)-1].offset) {
+			break
+		}
+		idx = append(idx, e)
+	}
+	return idx, nil
+}
+
+func appendEntry(b []byte, e entry) []byte {
+	var x [entrySize]byte
+	binary.BigEndian.PutUint64(x[:], uint64(e.time))
+	binary.BigEndian.PutUint64(x[8:], uint64(e.offset))
+	return append(b, x[:]...)
+}
+
+// checkHeader verifies the log header of f and returns the format version of the log.
+func checkHeader(f *os.File) (byte, error) {
+	h := make([]byte, len(magic))
+	if _, err := io.ReadFull(io.NewSectionReader(f, 0, int64(len(magic))), h); err != nil {
+		return 0, ErrFormat
+	}
+	v := h[versionOffset]
+	h[versionOffset] = version
+	if string(h) != magic || v < 1 || v > version {
+		return 0, ErrFormat
+	}
+	return v, nil
+}
+
+// derefValue returns the value pointed to by v.
+func derefValue(v interface{}) interface{} {
+	return reflect.ValueOf(v).Elem().Interface()
+}
+
+// putHeader fills the record header h for payload.
+func putHeader(h []byte, payload []byte) {
+	binary.BigEndian.PutUint32(h, uint32(len(payload)))
+	binary.BigEndian.PutUint32(h[4:], crc32.Checksum(payload, crcTable))
+}
@@ -0,0 +1,193 @@
+package record
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+)
+
+// speed returns a sample at second i of the log.
+func speed(i int) vehicledata.Sample {
+	return vehicledata.Sample{Time: time.Unix(1700000000+int64(i), 0), Value: vehicledata.VehicleSpeed{Speed: uint16(i)}}
+}
+
+func write(t *testing.T, path string, samples ...vehicledata.Sample) {
+	w, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range samples {
+		if err := w.Write(s); err != nil {
+			t.Fatalf("Write(%+v): %v", s, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readAll(t *testing.T, path string) []vehicledata.Sample {
+	r, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var out []vehicledata.Sample
+	for {
+		s, err := r.Next()
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, s)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	samples := []vehicledata.Sample{
+		speed(0),
+		{Time: time.Unix(1700000000, 5), Value: vehicledata.BatteryStatus{ChargeLevel: 80, Voltage: 12, Current: 2}, Source: "can0"},
+		{Time: time.Unix(1700000001, 0), Value: vehicledata.VehicleSpeed{Speed: 65535}, Source: "obd", LowQuality: true},
+	}
+	write(t, path, samples...)
+	if got := readAll(t, path); !reflect.DeepEqual(got, samples) {
+		t.Errorf("read %+v, want %+v", got, samples)
+	}
+	f, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f[versionOffset] != version {
+		t.Errorf("version %d, want %d", f[versionOffset], version)
+	}
+
+	w, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(speed(0)); err != ErrOutOfOrder {
+		t.Errorf("Write before the end: err = %v, want ErrOutOfOrder", err)
+	}
+	w.Close()
+}
+
+func TestTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	var samples []vehicledata.Sample
+	for i := 0; i < 10; i++ {
+		samples = append(samples, speed(i))
+	}
+	write(t, path, samples...)
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a record cut short by a crash
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 40, 1, 2, 3, 4, 5})
+	f.Close()
+	if got := readAll(t, path); !reflect.DeepEqual(got, samples) {
+		t.Errorf("read torn log %+v, want %+v", got, samples)
+	}
+
+	w, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi2, err := os.Stat(path); err != nil || fi2.Size() != fi.Size() {
+		t.Errorf("recovered log size %d, want %d", fi2.Size(), fi.Size())
+	}
+	if err := w.Write(speed(10)); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	samples = append(samples, speed(10))
+	if got := readAll(t, path); !reflect.DeepEqual(got, samples) {
+		t.Errorf("read recovered log %+v, want %+v", got, samples)
+	}
+}
+
+func TestSeek(t *testing.T) {
+	defer func(n int) { IndexInterval = n }(IndexInterval)
+	IndexInterval = 4
+	path := filepath.Join(t.TempDir(), "log")
+	var samples []vehicledata.Sample
+	for i := 0; i < 50; i++ {
+		samples = append(samples, speed(i))
+	}
+	write(t, path, samples...)
+	// resume the log, following its index
+	samples = samples[:0]
+	for i := 50; i < 100; i++ {
+		samples = append(samples, speed(i))
+	}
+	write(t, path, samples...)
+
+	fi, err := os.Stat(path + ".idx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(100 / IndexInterval * entrySize); fi.Size() != want {
+		t.Errorf("index size %d, want %d", fi.Size(), want)
+	}
+
+	r, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if len(r.idx) != 100/IndexInterval {
+		t.Errorf("%d index entries, want %d", len(r.idx), 100/IndexInterval)
+	}
+	for _, i := range []int{0, 1, 3, 4, 5, 37, 48, 50, 99} {
+		if err := r.Seek(speed(i).Time); err != nil {
+			t.Fatal(err)
+		}
+		s, err := r.Next()
+		if err != nil || !reflect.DeepEqual(s, speed(i)) {
+			t.Errorf("Seek(%d): Next = %+v, %v", i, s, err)
+		}
+	}
+	if err := r.Seek(speed(100).Time); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := r.Next(); err != io.EOF {
+		t.Errorf("Seek past the end: Next = %+v, %v", s, err)
+	}
+}
+
+func TestIndexFailure(t *testing.T) {
+	defer func(n int) { IndexInterval = n }(IndexInterval)
+	IndexInterval = 2
+	path := filepath.Join(t.TempDir(), "log")
+	w, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var samples []vehicledata.Sample
+	for i := 0; i < 8; i++ {
+		if i == 3 {
+			w.idx.Close()
+		}
+		samples = append(samples, speed(i))
+		if err := w.Write(speed(i)); err != nil {
+			t.Fatalf("Write(%d): %v", i, err)
+		}
+	}
+	w.f.Close()
+	if got := readAll(t, path); !reflect.DeepEqual(got, samples) {
+		t.Errorf("read %+v, want %+v", got, samples)
+	}
+}
@@ -0,0 +1,67 @@
+package record
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+)
+
+// A Replay plays samples from a log back with their original timing.
+type Replay struct {
+	// Playback rate: 1 for real time, N for N times faster, 0 for as fast as possible
+	Speed float64
+	// Time range to play; zero values mean the start and the end of the log
+	From, To time.Time
+}
+
+// Run plays the samples of r within the replay range, calling emit for each. Sample times are the recorded
+// times. It returns nil at the end of the range or the log, or ctx.Err() when ctx is done.
+func (p Replay) Run(ctx context.Context, r *Reader, emit func(vehicledata.Sample)) error {
+	if !p.From.IsZero() {
+		if err := r.Seek(p.From); err != nil {
+			return err
+		}
+	}
+	var (
+		timer     *time.Timer
+		base      time.Time
+		wallStart time.Time
+	)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !p.To.IsZero() && s.Time.After(p.To) {
+			return nil
+		}
+		if p.Speed > 0 {
+			if base.IsZero() {
+				base, wallStart = s.Time, time.Now()
+			}
+			due := wallStart.Add(time.Duration(float64(s.Time.Sub(base)) / p.Speed))
+			if d := time.Until(due); d > 0 {
+				if timer == nil {
+					timer = time.NewTimer(d)
+					defer timer.Stop()
+				} else {
+					timer.Reset(d)
+				}
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+		emit(s)
+	}
+}
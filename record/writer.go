@@ -0,0 +1,171 @@
+package record
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+)
+
+// A Writer appends samples to a log. It is safe for concurrent use.
+type Writer struct {
+	mu   sync.Mutex
+	f    *os.File
+	idx  *os.File
+	size int64
+	last int64
+	// size of the index in whole entries
+	idxSize int64
+	// format version of the log
+	version byte
+	// records written since the last index entry
+	count int
+}
+
+// Create opens the log at path for appending, creating it if it does not exist. An existing log is recovered:
+// a torn record at its end is truncated and missing index entries are rebuilt. Samples appended to an
+// existing log are written in the format version of that log.
+func Create(path string) (*Writer, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{f: f}
+	if err := w.recover(path); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// recover validates the log and its index and positions the writer at the end of the last valid record.
+func (w *Writer) recover(path string) error {
+	fi, err := w.f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() == 0 {
+		if _, err := w.f.WriteAt([]byte(magic), 0); err != nil {
+			return err
+		}
+		w.version = version
+	} else if w.version, err = checkHeader(w.f); err != nil {
+		return err
+	}
+	idx, err := openIndex(w.f, path+".idx", fi.Size())
+	if err != nil {
+		return err
+	}
+	from := int64(len(magic))
+	w.count = IndexInterval
+	if len(idx) > 0 {
+		from = idx[len(idx)-1].offset
+		w.count = 0
+	}
+	w.last = -1 << 63
+	end, err := scan(w.f, from, func(offset int64, b []byte) {
+		if w.count >= IndexInterval {
+			idx = append(idx, entry{recordTime(b), offset})
+			w.count = 0
+		}
+		w.count++
+		w.last = recordTime(b)
+	})
+	if err != nil {
+		return err
+	}
+	if end < fi.Size() {
+		if err := w.f.Truncate(end); err != nil {
+			return err
+		}
+	}
+	w.size = end
+
+	w.idx, err = os.OpenFile(path+".idx", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	var b []byte
+	for _, e := range idx {
+		b = appendEntry(b, e)
+	}
+	n, err := w.idx.Write(b)
+	w.idxSize = int64(n) / entrySize * entrySize
+	return err
+}
+
+// openIndex loads the index of a log and discards it if it does not match the log.
+func openIndex(f *os.File, path string, size int64) ([]entry, error) {
+	idx, err := loadIndex(path, size)
+	if err != nil || len(idx) == 0 {
+		return nil, err
+	}
+	last := idx[len(idx)-1]
+	b, err := readRecord(io.NewSectionReader(f, last.offset, size-last.offset), nil)
+	if err != nil || recordTime(b) != last.time {
+		return nil, nil
+	}
+	return idx, nil
+}
+
+// Write appends a sample to the log. Samples must be written in time order. The index is only a shortcut
+// for seeking: an index entry that cannot be written is retried with the next record, and recovery rebuilds
+// what is missing.
+func (w *Writer) Write(s vehicledata.Sample) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	payload, err := encode(s, w.version)
+	if err != nil {
+		return err
+	}
+	t := s.Time.UnixNano()
+	if t < w.last {
+		return ErrOutOfOrder
+	}
+	b := make([]byte, headerSize, headerSize+len(payload))
+	putHeader(b, payload)
+	b = append(b, payload...)
+	if _, err := w.f.WriteAt(b, w.size); err != nil {
+		// leave a partial record to be cut off by recovery, but never append after it
+		w.f.Truncate(w.size)
+		return err
+	}
+	offset := w.size
+	w.size += int64(len(b))
+	w.last = t
+	if w.count >= IndexInterval {
+		if _, err := w.idx.WriteAt(appendEntry(nil, entry{t, offset}), w.idxSize); err != nil {
+			// cut off a partial entry so that the later ones stay aligned
+			w.idx.Truncate(w.idxSize)
+		} else {
+			w.idxSize += entrySize
+			w.count = 0
+		}
+	}
+	w.count++
+	return nil
+}
+
+// Sync commits the log and its index to stable storage. Records written before a successful Sync survive a
+// crash or power loss.
+func (w *Writer) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.f.Sync(); err != nil {
+		return err
+	}
+	return w.idx.Sync()
+}
+
+// Close syncs and closes the log.
+func (w *Writer) Close() error {
+	err := w.Sync()
+	if e := w.f.Close(); err == nil {
+		err = e
+	}
+	if e := w.idx.Close(); err == nil {
+		err = e
+	}
+	return err
+}
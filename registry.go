@@ -0,0 +1,194 @@
+package vehicledata
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/calvernaz/w3c-vehicle-data/types/airflow-direction"
+	"github.com/calvernaz/w3c-vehicle-data/types/alarm-status"
+	"github.com/calvernaz/w3c-vehicle-data/types/button-event"
+	"github.com/calvernaz/w3c-vehicle-data/types/convertible-root-status"
+	"github.com/calvernaz/w3c-vehicle-data/types/door-open-status"
+	"github.com/calvernaz/w3c-vehicle-data/types/driver-mode"
+	"github.com/calvernaz/w3c-vehicle-data/types/fuel-type"
+	"github.com/calvernaz/w3c-vehicle-data/types/identification-type"
+	"github.com/calvernaz/w3c-vehicle-data/types/lane-departure-status"
+	"github.com/calvernaz/w3c-vehicle-data/types/occupant-status"
+	"github.com/calvernaz/w3c-vehicle-data/types/parking-brake-status"
+	"github.com/calvernaz/w3c-vehicle-data/types/transmission-gear"
+	"github.com/calvernaz/w3c-vehicle-data/types/transmission-mode"
+	"github.com/calvernaz/w3c-vehicle-data/types/vehicle-power"
+	"github.com/calvernaz/w3c-vehicle-data/types/vehicle-type"
+	"github.com/calvernaz/w3c-vehicle-data/types/wiper-control"
+	"github.com/calvernaz/w3c-vehicle-data/types/zone"
+)
+
+// types holds every vehicle data type by name.
+var types = map[string]reflect.Type{}
+
+func init() {
+	for _, v := range []interface{}{
+		// Configuration and Identification
+		Identification{}, SizeConfiguration{}, FuelConfiguration{}, TransmissionConfiguration{},
+		WheelConfiguration{}, SteeringWheelConfiguration{},
+		// Running Status
+		VehicleSpeed{}, WheelSpeed{}, EngineSpeed{}, VehiclePowerModeType{}, PowertrainTorque{},
+		AcceleratorPedalPosition{}, ThrottlePosition{}, Trip{}, Transmission{}, CruiseControlStatus{},
+		LightStatus{}, InteriorLightStatus{}, Horn{}, Chime{}, Fuel{}, EngineOil{}, Acceleration{},
+		EngineCoolant{}, SteeringWheel{}, WheelTick{}, IgnitionTime{}, YawRate{}, BrakeOperation{},
+		ButtonEvent{}, DrivingMode{}, NightMode{},
+		// Maintenance
+		Odometer{}, TransmissionOil{}, TransmissionClutch{}, BrakeMaintenance{}, WasherFluid{},
+		MalfunctionIndicator{}, BatteryStatus{}, Tire{}, Diagnostic{},
+		// Personalization
+		LanguageConfiguration{}, UnitsOfMeasure{}, Mirror{}, SeatAdjustment{}, DriverMode{},
+		DashboardIllumination{}, VehicleSound{},
+		// DrivingSafety
+		AntilockBrakingSystem{}, TractionControlSystem{}, ElectronicStabilitySystem{}, TopSpeedLimit{},
+		AirbagStatus{}, Door{}, ChildSafetyLock{}, Seat{},
+		// Climate
+		Temperature{}, RailSensor{}, WiperStatus{}, Defrost{}, Sunroof{}, ConvertibleRoof{}, SlideWindow{},
+		ClimateControl{}, AtmosphericPressure{},
+		// Vision and Parking
+		LaneDepartureDetection{}, Alarm{}, ParkingBrake{},
+	} {
+		t := reflect.TypeOf(v)
+		types[t.Name()] = t
+	}
+}
+
+// Names returns the names of all vehicle data types in alphabetical order.
+func Names() []string {
+	names := make([]string, 0, len(types))
+	for n := range types {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New returns a pointer to a new zero value of the named vehicle data type.
+func New(name string) (interface{}, bool) {
+	t, ok := types[name]
+	if !ok {
+		return nil, false
+	}
+	return reflect.New(t).Interface(), true
+}
+
+// enums maps each enumerated type to the names of its constants.
+var enums = map[reflect.Type]map[string]int64{
+	reflect.TypeOf(airflow_direction.FrontPanel): {
+		"FrontPanel": int64(airflow_direction.FrontPanel), "FloorDuct": int64(airflow_direction.FloorDuct),
+		"BiLevel": int64(airflow_direction.BiLevel), "DefrostFloor": int64(airflow_direction.DefrostFloor),
+	},
+	reflect.TypeOf(alarm_status.Disarmed): {
+		"Disarmed": int64(alarm_status.Disarmed), "PreArmed": int64(alarm_status.PreArmed),
+		"Armed": int64(alarm_status.Armed), "Alarmed": int64(alarm_status.Alarmed),
+	},
+	reflect.TypeOf(button_event.Home): {
+		"Home": int64(button_event.Home), "Back": int64(button_event.Back), "Search": int64(button_event.Search),
+		"Call": int64(button_event.Call), "EndCall": int64(button_event.EndCall),
+		"MediaPlay": int64(button_event.MediaPlay), "MediaNext": int64(button_event.MediaNext),
+		"MediaPrevious": int64(button_event.MediaPrevious), "MediaPause": int64(button_event.MediaPause),
+		"VoiceRecognize": int64(button_event.VoiceRecognize), "Enter": int64(button_event.Enter),
+		"Left": int64(button_event.Left), "Right": int64(button_event.Right), "Up": int64(button_event.Up),
+		"Down": int64(button_event.Down), "Press": int64(button_event.Press),
+		"LongPress": int64(button_event.LongPress), "Release": int64(button_event.Release),
+	},
+	reflect.TypeOf(convertible_root_status.Closed): {
+		"Closed": int64(convertible_root_status.Closed), "Closing": int64(convertible_root_status.Closing),
+		"Opening": int64(convertible_root_status.Opening), "Opened": int64(convertible_root_status.Opened),
+	},
+	reflect.TypeOf(door_open_status.Open): {
+		"Open": int64(door_open_status.Open), "Ajar": int64(door_open_status.Ajar),
+		"Closed": int64(door_open_status.Closed),
+	},
+	reflect.TypeOf(driver_mode.Comfort): {
+		"Comfort": int64(driver_mode.Comfort), "Auto": int64(driver_mode.Auto), "Sport": int64(driver_mode.Sport),
+		"Eco": int64(driver_mode.Eco), "Manual": int64(driver_mode.Manual), "Winter": int64(driver_mode.Winter),
+	},
+	reflect.TypeOf(fuel_type.Gasoline): {
+		"Gasoline": int64(fuel_type.Gasoline), "Methanol": int64(fuel_type.Methanol),
+		"Ethanol": int64(fuel_type.Ethanol), "Diesel": int64(fuel_type.Diesel), "LPG": int64(fuel_type.LPG),
+		"CNG": int64(fuel_type.CNG), "Electric": int64(fuel_type.Electric),
+	},
+	reflect.TypeOf(identification_type.Pin): {
+		"Pin": int64(identification_type.Pin), "Keyfob": int64(identification_type.Keyfob),
+		"Bluethoot": int64(identification_type.Bluethoot), "NFC": int64(identification_type.NFC),
+		"Fingerprint": int64(identification_type.Fingerprint), "Camera": int64(identification_type.Camera),
+		"Voice": int64(identification_type.Voice),
+	},
+	reflect.TypeOf(lane_departure_status.Off): {
+		"Off": int64(lane_departure_status.Off), "Pause": int64(lane_departure_status.Pause),
+		"Running": int64(lane_departure_status.Running),
+	},
+	reflect.TypeOf(occupant_status.Adult): {
+		"Adult": int64(occupant_status.Adult), "Child": int64(occupant_status.Child),
+		"Vacant": int64(occupant_status.Vacant),
+	},
+	reflect.TypeOf(parking_braking_status.Inactive): {
+		"Inactive": int64(parking_braking_status.Inactive), "Active": int64(parking_braking_status.Active),
+		"Error": int64(parking_braking_status.Error),
+	},
+	reflect.TypeOf(transmission_gear.Automatic): {
+		"Automatic": int64(transmission_gear.Automatic), "Manual": int64(transmission_gear.Manual),
+	},
+	reflect.TypeOf(transmission_mode.Park): {
+		"Park": int64(transmission_mode.Park), "Reverse": int64(transmission_mode.Reverse),
+		"Neutral": int64(transmission_mode.Neutral), "Low": int64(transmission_mode.Low),
+		"Drive": int64(transmission_mode.Drive), "Overdrive": int64(transmission_mode.Overdrive),
+	},
+	reflect.TypeOf(vehicle_power.Off): {
+		"Off": int64(vehicle_power.Off), "Accessory1": int64(vehicle_power.Accessory1),
+		"Accessory2": int64(vehicle_power.Accessory2), "Running": int64(vehicle_power.Running),
+	},
+	reflect.TypeOf(vehicle_type.PassengerCarMini): {
+		"PassengerCarMini":    int64(vehicle_type.PassengerCarMini),
+		"PassengerCarLight":   int64(vehicle_type.PassengerCarLight),
+		"PassengerCarCompact": int64(vehicle_type.PassengerCarCompact),
+		"PassengerCarMedium":  int64(vehicle_type.PassengerCarMedium),
+		"PassengerCarHeavy":   int64(vehicle_type.PassengerCarHeavy),
+		"SportUtilityVehicle": int64(vehicle_type.SportUtilityVehicle),
+		"PickupTruck":         int64(vehicle_type.PickupTruck), "Van": int64(vehicle_type.Van),
+	},
+	reflect.TypeOf(wiper_control.Off): {
+		"Off": int64(wiper_control.Off), "Once": int64(wiper_control.Once),
+		"Slowest": int64(wiper_control.Slowest), "Slow": int64(wiper_control.Slow),
+		"Middle": int64(wiper_control.Middle), "Fast": int64(wiper_control.Fast),
+		"Fastest": int64(wiper_control.Fastest), "Auto": int64(wiper_control.Auto),
+	},
+	reflect.TypeOf(zone.Front): {
+		"Front": int64(zone.Front), "Middle": int64(zone.Middle), "Right": int64(zone.Right),
+		"Left": int64(zone.Left), "Rear": int64(zone.Rear), "Center": int64(zone.Center),
+	},
+}
+
+// enumValue resolves the constant name s of the enumerated type t, ignoring case.
+func enumValue(t reflect.Type, s string) (int64, bool) {
+	for n, v := range enums[t] {
+		if strings.EqualFold(n, s) {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// EnumName returns the constant name of an enumerated value, e.g. "Open" for door_open_status.Open.
+func EnumName(v interface{}) (string, bool) {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return "", false
+	}
+	names, ok := enums[rv.Type()]
+	if !ok {
+		return "", false
+	}
+	for n, c := range names {
+		if c == rv.Int() {
+			return n, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,216 @@
+package rules
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+	"github.com/calvernaz/w3c-vehicle-data/subscription"
+)
+
+// State of an alert.
+type State int
+
+const (
+	Fired State = iota
+	Cleared
+)
+
+func (s State) String() string {
+	if s == Fired {
+		return "fired"
+	}
+	return "cleared"
+}
+
+// An Alert is raised when a rule fires or clears.
+type Alert struct {
+	Rule     string
+	Severity Severity
+	// Zone bound to the wildcard, empty for rules without one
+	Zone  string
+	State State
+	// Time the rule fired or cleared
+	Time time.Time
+	// Time the condition that fired or cleared the rule started to hold
+	Since   time.Time
+	Message string
+}
+
+// An instance is the state of a rule for one zone.
+type instance struct {
+	active bool
+	// time the condition to change state started to hold, zero if it does not
+	since time.Time
+	// last alert raised
+	last Alert
+}
+
+// An Engine evaluates rules against the latest value of every signal. It is not safe for concurrent use.
+type Engine struct {
+	rules  []*Rule
+	byType map[string][]*Rule
+	values map[string]interface{}
+	// zones seen per type
+	zones map[string]map[string]bool
+	inst  map[*Rule]map[string]*instance
+}
+
+// NewEngine returns an engine evaluating rules.
+func NewEngine(rules []*Rule) *Engine {
+	e := &Engine{
+		rules:  rules,
+		byType: map[string][]*Rule{},
+		values: map[string]interface{}{},
+		zones:  map[string]map[string]bool{},
+		inst:   map[*Rule]map[string]*instance{},
+	}
+	for _, r := range rules {
+		for t := range r.types {
+			e.byType[t] = append(e.byType[t], r)
+		}
+		e.inst[r] = map[string]*instance{}
+	}
+	return e
+}
+
+// Attach feeds every sample published on h to e and calls fn with each alert raised. The returned function
+// detaches the engine.
+func Attach(h *subscription.Hub, e *Engine, fn func(Alert)) func() {
+	var mu sync.Mutex
+	return h.Subscribe("", subscription.Options{}, func(s vehicledata.Sample) {
+		mu.Lock()
+		alerts := e.Update(s)
+		mu.Unlock()
+		for _, a := range alerts {
+			fn(a)
+		}
+	})
+}
+
+// Update records the sample and returns the alerts fired or cleared by it. Rules referencing the sample type are
+// evaluated, as are rules waiting for a duration to elapse. Samples must be fed in time order.
+func (e *Engine) Update(s vehicledata.Sample) []Alert {
+	if s.Value == nil {
+		return nil
+	}
+	name := s.Name()
+	e.values[s.Key()] = s.Value
+	if z, ok := vehicledata.ZoneOf(s.Value); ok && len(z.Value) > 0 {
+		if e.zones[name] == nil {
+			e.zones[name] = map[string]bool{}
+		}
+		e.zones[name][z.String()] = true
+	}
+	triggered := map[*Rule]bool{}
+	for _, r := range e.byType[name] {
+		triggered[r] = true
+	}
+	var out []Alert
+	for _, r := range e.rules {
+		if !triggered[r] && !e.waiting(r) {
+			continue
+		}
+		for _, z := range e.ruleZones(r) {
+			if a, ok := e.step(r, z, s.Time); ok {
+				out = append(out, a)
+			}
+		}
+	}
+	return out
+}
+
+// Active returns the alerts currently fired, in rule order.
+func (e *Engine) Active() []Alert {
+	var out []Alert
+	for _, r := range e.rules {
+		for _, z := range e.ruleZones(r) {
+			if in := e.inst[r][z]; in != nil && in.active {
+				out = append(out, in.last)
+			}
+		}
+	}
+	return out
+}
+
+func (e *Engine) waiting(r *Rule) bool {
+	for _, in := range e.inst[r] {
+		if !in.since.IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleZones returns the zones r is evaluated for: those seen for its wildcard types, or the empty zone.
+func (e *Engine) ruleZones(r *Rule) []string {
+	if len(r.wildcard) == 0 {
+		return []string{""}
+	}
+	set := map[string]bool{}
+	for t := range r.wildcard {
+		for z := range e.zones[t] {
+			set[z] = true
+		}
+	}
+	zs := make([]string, 0, len(set))
+	for z := range set {
+		zs = append(zs, z)
+	}
+	sort.Strings(zs)
+	return zs
+}
+
+// step evaluates r for zone z at time now and returns the alert raised, if any.
+func (e *Engine) step(r *Rule, z string, now time.Time) (Alert, bool) {
+	in := e.inst[r][z]
+	if in == nil {
+		in = &instance{}
+		e.inst[r][z] = in
+	}
+	env := &env{zone: z, lookup: e.lookup}
+	var cond bool
+	delay := r.For
+	if !in.active {
+		cond = truth(r.when, env)
+	} else {
+		delay = r.ClearFor
+		if r.clear != nil {
+			cond = truth(r.clear, env)
+		} else {
+			cond = !truth(r.when, env)
+		}
+	}
+	if !cond {
+		in.since = time.Time{}
+		return Alert{}, false
+	}
+	if in.since.IsZero() {
+		in.since = now
+	}
+	if now.Sub(in.since) < delay {
+		return Alert{}, false
+	}
+	since := in.since
+	in.active, in.since = !in.active, time.Time{}
+	state := Fired
+	if !in.active {
+		state = Cleared
+	}
+	in.last = Alert{Rule: r.Name, Severity: r.Severity, Zone: z, State: state, Time: now, Since: since, Message: r.Message}
+	return in.last, true
+}
+
+func (e *Engine) lookup(key, field string) (float64, bool) {
+	v, ok := e.values[key]
+	if !ok {
+		return 0, false
+	}
+	return vehicledata.Field(v, field)
+}
+
+func truth(n node, e *env) bool {
+	v, ok := n.eval(e)
+	return ok && v != 0
+}
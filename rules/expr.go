@@ -0,0 +1,369 @@
+package rules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+	"github.com/calvernaz/w3c-vehicle-data/types/zone"
+)
+
+// A node is a compiled expression. eval returns false if a signal it depends on has no value yet.
+type node interface {
+	eval(env *env) (float64, bool)
+}
+
+// env resolves signal paths, binding the zone wildcard to zone.
+type env struct {
+	zone   string
+	lookup func(key, field string) (float64, bool)
+}
+
+type num float64
+
+func (n num) eval(*env) (float64, bool) { return float64(n), true }
+
+// A path is a signal field such as Tire[*].Pressure.
+type path struct {
+	typ string
+	// zone in brackets: empty for none, "*" for the wildcard
+	zone  string
+	field string
+}
+
+func (p path) eval(e *env) (float64, bool) {
+	key := p.typ
+	switch p.zone {
+	case "":
+	case "*":
+		key += "[" + e.zone + "]"
+	default:
+		key += "[" + p.zone + "]"
+	}
+	return e.lookup(key, p.field)
+}
+
+type unary struct {
+	op string
+	x  node
+}
+
+func (u unary) eval(e *env) (float64, bool) {
+	x, ok := u.x.eval(e)
+	if !ok {
+		return 0, false
+	}
+	if u.op == "!" {
+		return b2f(x == 0), true
+	}
+	return -x, true
+}
+
+type binary struct {
+	op   string
+	l, r node
+}
+
+func (b binary) eval(e *env) (float64, bool) {
+	l, lok := b.l.eval(e)
+	r, rok := b.r.eval(e)
+	switch b.op {
+	// a known operand can decide a logical operator on its own
+	case "&&":
+		if lok && l == 0 || rok && r == 0 {
+			return 0, true
+		}
+		return 1, lok && rok
+	case "||":
+		if lok && l != 0 || rok && r != 0 {
+			return 1, true
+		}
+		return 0, lok && rok
+	}
+	if !lok || !rok {
+		return 0, false
+	}
+	switch b.op {
+	case "==":
+		return b2f(l == r), true
+	case "!=":
+		return b2f(l != r), true
+	case "<":
+		return b2f(l < r), true
+	case "<=":
+		return b2f(l <= r), true
+	case ">":
+		return b2f(l > r), true
+	case ">=":
+		return b2f(l >= r), true
+	case "+":
+		return l + r, true
+	case "-":
+		return l - r, true
+	case "*":
+		return l * r, true
+	case "/":
+		if r == 0 {
+			return 0, false
+		}
+		return l / r, true
+	}
+	return 0, false
+}
+
+func b2f(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// A parser compiles expressions from tokens.
+type parser struct {
+	toks []string
+	pos  int
+	// types referenced, and whether a zone wildcard is used
+	types    map[string]bool
+	wildcard map[string]bool
+}
+
+// keywords end an expression.
+var keywords = map[string]bool{"for": true, "clear": true, "severity": true, "message": true}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *parser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// expr parses an expression up to the end of the tokens or a keyword.
+func (p *parser) expr() (node, error) {
+	return p.binary(0)
+}
+
+var levels = [][]string{{"||"}, {"&&"}, {"==", "!=", "<", "<=", ">", ">="}, {"+", "-"}, {"*", "/"}}
+
+func (p *parser) binary(level int) (node, error) {
+	if level == len(levels) {
+		return p.unary()
+	}
+	l, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		found := false
+		for _, o := range levels[level] {
+			found = found || o == op
+		}
+		if !found {
+			return l, nil
+		}
+		p.next()
+		r, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		rl, rr := resolveEnum(l, r), resolveEnum(r, l)
+		if rl == nil {
+			return nil, fmt.Errorf("unknown constant %q", l)
+		}
+		if rr == nil {
+			return nil, fmt.Errorf("unknown constant %q", r)
+		}
+		l = binary{op, rl, rr}
+	}
+}
+
+func (p *parser) unary() (node, error) {
+	switch p.peek() {
+	case "!", "-":
+		op := p.next()
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := x.(constant); ok {
+			return nil, fmt.Errorf("unknown constant after %q", op)
+		}
+		return unary{op, x}, nil
+	}
+	return p.primary()
+}
+
+// A constant is an identifier such as an enumeration name, resolved against the path it is compared with.
+type constant string
+
+func (constant) eval(*env) (float64, bool) { return 0, false }
+
+// resolveEnum resolves x against the field of other if x is a constant; it returns nil if it cannot.
+func resolveEnum(x, other node) node {
+	c, ok := x.(constant)
+	if !ok {
+		return x
+	}
+	p, ok := other.(path)
+	if !ok {
+		return nil
+	}
+	v, _ := vehicledata.New(p.typ)
+	if err := vehicledata.ParseField(v, p.field, string(c)); err != nil {
+		return nil
+	}
+	f, ok := vehicledata.Field(v, p.field)
+	if !ok {
+		return nil
+	}
+	return num(f)
+}
+
+func (p *parser) primary() (node, error) {
+	t := p.next()
+	switch {
+	case t == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case t == "(":
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		return x, nil
+	case keywords[t]:
+		return nil, fmt.Errorf("unexpected %q", t)
+	case t[0] >= '0' && t[0] <= '9' || t[0] == '.':
+		f, err := strconv.ParseFloat(t, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", t)
+		}
+		return num(f), nil
+	case isIdent(t[0]):
+		return p.path(t)
+	}
+	return nil, fmt.Errorf("unexpected %q", t)
+}
+
+// path parses Type[zone].Field, Type.Field or a constant.
+func (p *parser) path(t string) (node, error) {
+	typ, rest := t, ""
+	if i := strings.IndexAny(t, "[."); i >= 0 {
+		typ, rest = t[:i], t[i:]
+	}
+	if rest == "" {
+		if p.peek() == "(" {
+			return nil, fmt.Errorf("unknown function %q", t)
+		}
+		switch t {
+		case "true":
+			return num(1), nil
+		case "false":
+			return num(0), nil
+		}
+		return constant(t), nil
+	}
+	pt := path{typ: typ}
+	if rest[0] == '[' {
+		end := strings.IndexByte(rest, ']')
+		if end < 0 {
+			return nil, fmt.Errorf("missing ] in %q", t)
+		}
+		z := strings.TrimSpace(rest[1:end])
+		if z != "*" {
+			z = zone.Parse(z).String()
+		}
+		pt.zone, rest = z, rest[end+1:]
+	}
+	if !strings.HasPrefix(rest, ".") || len(rest) < 2 {
+		return nil, fmt.Errorf("missing field in %q", t)
+	}
+	pt.field = rest[1:]
+	v, ok := vehicledata.New(typ)
+	if !ok {
+		return nil, fmt.Errorf("unknown type %q", typ)
+	}
+	if _, ok := vehicledata.Field(v, pt.field); !ok {
+		return nil, fmt.Errorf("%s has no numeric or boolean field %q", typ, pt.field)
+	}
+	if pt.zone != "" {
+		if _, ok := vehicledata.ZoneOf(v); !ok {
+			return nil, fmt.Errorf("%s has no zone", typ)
+		}
+	}
+	p.types[typ] = true
+	if pt.zone == "*" {
+		p.wildcard[typ] = true
+	}
+	return pt, nil
+}
+
+func isIdent(c byte) bool {
+	return c == '_' || unicode.IsLetter(rune(c))
+}
+
+// tokenize splits a rule line into tokens. A path with its zone, such as Tire[front-left].Pressure, is one
+// token, as is a quoted string.
+func tokenize(s string) ([]string, error) {
+	var toks []string
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '"':
+			end := strings.IndexByte(s[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			toks = append(toks, s[i:i+end+2])
+			i += end + 2
+		case isIdent(c):
+			j := i
+			for j < len(s) && (isIdent(s[j]) || s[j] >= '0' && s[j] <= '9' || s[j] == '.' || s[j] == '[') {
+				if s[j] == '[' {
+					end := strings.IndexByte(s[j:], ']')
+					if end < 0 {
+						return nil, fmt.Errorf("missing ]")
+					}
+					j += end
+				}
+				j++
+			}
+			toks = append(toks, s[i:j])
+			i = j
+		case c >= '0' && c <= '9' || c == '.':
+			// numbers and durations such as 10s or 1m30s
+			j := i
+			for j < len(s) && (s[j] >= '0' && s[j] <= '9' || s[j] == '.' || unicode.IsLetter(rune(s[j]))) {
+				j++
+			}
+			toks = append(toks, s[i:j])
+			i = j
+		default:
+			if i+1 < len(s) {
+				if two := s[i : i+2]; two == "&&" || two == "||" || two == "==" || two == "!=" || two == "<=" || two == ">=" {
+					toks = append(toks, two)
+					i += 2
+					continue
+				}
+			}
+			if !strings.ContainsRune("()!<>+-*/", rune(c)) {
+				return nil, fmt.Errorf("unexpected %q", c)
+			}
+			toks = append(toks, string(c))
+			i++
+		}
+	}
+	return toks, nil
+}
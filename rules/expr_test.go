@@ -0,0 +1,152 @@
+package rules
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/calvernaz/w3c-vehicle-data/types/transmission-mode"
+	"github.com/calvernaz/w3c-vehicle-data/types/zone"
+)
+
+// compileExpr compiles a whole expression.
+func compileExpr(s string) (node, error) {
+	toks, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks, types: map[string]bool{}, wildcard: map[string]bool{}}
+	n, err := p.expr()
+	if err == nil && p.pos < len(toks) {
+		return nil, fmt.Errorf("unexpected %q", p.peek())
+	}
+	return n, err
+}
+
+func TestTokenize(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want []string
+	}{
+		{"VehicleSpeed.Speed>0", []string{"VehicleSpeed.Speed", ">", "0"}},
+		{"Tire[front-left].Pressure <= 200", []string{"Tire[front-left].Pressure", "<=", "200"}},
+		{"Tire[*].Pressure < 1.5*x", []string{"Tire[*].Pressure", "<", "1.5", "*", "x"}},
+		{"!(a&&b)||c", []string{"!", "(", "a", "&&", "b", ")", "||", "c"}},
+		{"a != -2", []string{"a", "!=", "-", "2"}},
+		{"a for 1m30s", []string{"a", "for", "1m30s"}},
+		{`message "low: 5 < 6"`, []string{"message", `"low: 5 < 6"`}},
+	} {
+		got, err := tokenize(tt.in)
+		if err != nil {
+			t.Errorf("tokenize(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tokenize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	for _, in := range []string{`message "open`, "Tire[front.Pressure", "a & b", "a = b", "a % 2"} {
+		if _, err := tokenize(in); err == nil {
+			t.Errorf("tokenize(%q) succeeded", in)
+		}
+	}
+}
+
+func TestEval(t *testing.T) {
+	fl := "Tire[" + zone.Parse("front-left").String() + "]"
+	values := map[string]float64{
+		"VehicleSpeed.Speed":        50,
+		"EngineCoolant.Temperature": 95,
+		"Transmission.Mode":         float64(transmission_mode.Drive),
+		fl + ".Pressure":            180,
+		fl + ".PressureLow":         1,
+	}
+	e := &env{
+		zone: zone.Parse("front-left").String(),
+		lookup: func(key, field string) (float64, bool) {
+			v, ok := values[key+"."+field]
+			return v, ok
+		},
+	}
+	for _, tt := range []struct {
+		expr string
+		want float64
+		ok   bool
+	}{
+		{"1 + 2 * 3", 7, true},
+		{"(1 + 2) * 3", 9, true},
+		{"10 - 4 - 3", 3, true},
+		{"8 / 4 / 2", 1, true},
+		{"-2 * -3", 6, true},
+		{"1 / 0", 0, false},
+		{"1 < 2 && 2 <= 2 && 3 > 2 && 3 >= 3", 1, true},
+		{"1 == 1 && 1 != 2", 1, true},
+		{"!0 && !!1", 1, true},
+		{"true || false", 1, true},
+		{"1 + 1 == 2 || 0", 1, true},
+		{"VehicleSpeed.Speed > 40", 1, true},
+		{"VehicleSpeed.Speed / 2", 25, true},
+		{"EngineCoolant.Temperature > 110", 0, true},
+		{"Transmission.Mode == Drive", 1, true},
+		{"Drive == Transmission.Mode", 1, true},
+		{"Transmission.Mode != Park", 1, true},
+		{"Tire[front-left].Pressure < 200", 1, true},
+		{"Tire[*].Pressure < 200 && Tire[*].PressureLow", 1, true},
+		// no value for the rear tire or the engine speed
+		{"Tire[rear-left].Pressure < 200", 0, false},
+		{"EngineSpeed.Speed > 0", 0, false},
+		{"-EngineSpeed.Speed", 0, false},
+		// a known operand decides a logical operator
+		{"EngineSpeed.Speed > 0 && VehicleSpeed.Speed > 60", 0, true},
+		{"EngineSpeed.Speed > 0 || VehicleSpeed.Speed > 40", 1, true},
+		{"EngineSpeed.Speed > 0 && VehicleSpeed.Speed > 40", 1, false},
+		{"EngineSpeed.Speed > 0 || VehicleSpeed.Speed > 60", 0, false},
+	} {
+		n, err := compileExpr(tt.expr)
+		if err != nil {
+			t.Errorf("%q: %v", tt.expr, err)
+			continue
+		}
+		got, ok := n.eval(e)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%q = %v, %v; want %v, %v", tt.expr, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"1 +",
+		"(1 + 2",
+		"1 2",
+		"Bogus.Field > 0",
+		"VehicleSpeed.Bogus > 0",
+		"VehicleSpeed > 0",
+		"VehicleSpeed[front].Speed > 0",
+		"Transmission.Mode == Bogus",
+		"Drive == 1",
+		"-Drive",
+		"max(1, 2)",
+		"1..2 > 0",
+		"for",
+	} {
+		if _, err := compileExpr(expr); err == nil {
+			t.Errorf("%q compiled", expr)
+		}
+	}
+}
+
+func TestWildcard(t *testing.T) {
+	toks, _ := tokenize("Tire[*].Pressure < 200 && VehicleSpeed.Speed > 0")
+	p := &parser{toks: toks, types: map[string]bool{}, wildcard: map[string]bool{}}
+	if _, err := p.expr(); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]bool{"Tire": true, "VehicleSpeed": true}; !reflect.DeepEqual(p.types, want) {
+		t.Errorf("types = %v, want %v", p.types, want)
+	}
+	if want := map[string]bool{"Tire": true}; !reflect.DeepEqual(p.wildcard, want) {
+		t.Errorf("wildcard = %v, want %v", p.wildcard, want)
+	}
+}
@@ -0,0 +1,195 @@
+// Package rules raises alerts from rules written as expressions over vehicle data, e.g.
+//
+//	low-pressure: Tire[*].Pressure < 200 && VehicleSpeed.Speed > 0 for 10s severity warning
+//
+// A rule line is
+//
+//	<name>: <expr> [for <duration>] [clear [<expr>] [for <duration>]] [severity info|warning|critical] [message "<text>"]
+//
+// Expressions combine signal paths, numbers and constants with || && ! == != < <= > >= + - * / and
+// parentheses. A path is Type.Field, Type[zone].Field or Type[*].Field; booleans are 1 or 0, and an
+// identifier compared with a path, such as Transmission.Mode == Drive, is resolved as a value of that field.
+// The severity defaults to warning. The zone wildcard binds the same zone across the whole expression and alerts are raised per zone. An
+// expression that depends on a signal with no value yet is false.
+//
+// A rule fires once its expression has held for the for duration. It clears once the clear expression has
+// held for the clear duration; without a clear expression, once the rule expression has been false for it.
+// A clear expression that is false near the firing threshold gives hysteresis, e.g.
+//
+//	hot: EngineCoolant.Temperature > 110 for 5s clear EngineCoolant.Temperature < 100
+package rules
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Severity of an alert.
+type Severity int
+
+const (
+	Info Severity = iota
+	Warning
+	Critical
+)
+
+var severities = [...]string{"info", "warning", "critical"}
+
+func (s Severity) String() string {
+	if s >= 0 && int(s) < len(severities) {
+		return severities[s]
+	}
+	return "Severity(" + strconv.Itoa(int(s)) + ")"
+}
+
+// A Rule is a compiled alert rule.
+type Rule struct {
+	Name     string
+	Severity Severity
+	Message  string
+	// Text of the rule expression
+	Expr string
+	// Duration the expression must hold before the rule fires
+	For time.Duration
+	// Duration the clear condition must hold before the alert clears
+	ClearFor time.Duration
+
+	when, clear node
+	// vehicle data types referenced, and those used with the zone wildcard
+	types, wildcard map[string]bool
+}
+
+// Parse compiles one rule per non-empty line of r. Text after # is a comment.
+func Parse(r io.Reader) ([]*Rule, error) {
+	var rules []*Rule
+	names := map[string]bool{}
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 && strings.Count(line[:i], `"`)%2 == 0 {
+			line = line[:i]
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		rule, err := Compile(line)
+		if err != nil {
+			return nil, fmt.Errorf("rules: line %d: %v", n, err)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("rules: line %d: duplicate rule %q", n, rule.Name)
+		}
+		names[rule.Name] = true
+		rules = append(rules, rule)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("rules: %v", err)
+	}
+	return rules, nil
+}
+
+// ParseFile compiles the rules in the named file, see Parse.
+func ParseFile(name string) ([]*Rule, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("rules: %v", err)
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Compile compiles a single rule line.
+func Compile(line string) (*Rule, error) {
+	i := strings.IndexByte(line, ':')
+	if i < 0 {
+		return nil, fmt.Errorf("missing rule name")
+	}
+	r := &Rule{Name: strings.TrimSpace(line[:i]), Severity: Warning}
+	if r.Name == "" {
+		return nil, fmt.Errorf("missing rule name")
+	}
+	toks, err := tokenize(line[i+1:])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", r.Name, err)
+	}
+	p := &parser{toks: toks, types: map[string]bool{}, wildcard: map[string]bool{}}
+	if err := r.parse(p); err != nil {
+		return nil, fmt.Errorf("%s: %v", r.Name, err)
+	}
+	r.types, r.wildcard = p.types, p.wildcard
+	return r, nil
+}
+
+func (r *Rule) parse(p *parser) error {
+	start := p.pos
+	var err error
+	if r.when, err = p.expr(); err != nil {
+		return err
+	}
+	if _, ok := r.when.(constant); ok {
+		return fmt.Errorf("unknown constant %q", r.when)
+	}
+	r.Expr = strings.Join(p.toks[start:p.pos], " ")
+	if p.peek() == "for" {
+		p.next()
+		if r.For, err = duration(p.next()); err != nil {
+			return err
+		}
+	}
+	if p.peek() == "clear" {
+		p.next()
+		if t := p.peek(); t != "" && !keywords[t] {
+			if r.clear, err = p.expr(); err != nil {
+				return err
+			}
+			if _, ok := r.clear.(constant); ok {
+				return fmt.Errorf("unknown constant %q", r.clear)
+			}
+		}
+		if p.peek() == "for" {
+			p.next()
+			if r.ClearFor, err = duration(p.next()); err != nil {
+				return err
+			}
+		}
+	}
+	if p.peek() == "severity" {
+		p.next()
+		t := p.next()
+		found := false
+		for i, s := range severities {
+			if strings.EqualFold(t, s) {
+				r.Severity, found = Severity(i), true
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown severity %q", t)
+		}
+	}
+	if p.peek() == "message" {
+		p.next()
+		t := p.next()
+		m, err := strconv.Unquote(t)
+		if err != nil {
+			return fmt.Errorf("invalid message %s", t)
+		}
+		r.Message = m
+	}
+	if t := p.peek(); t != "" {
+		return fmt.Errorf("unexpected %q", t)
+	}
+	return nil
+}
+
+func duration(t string) (time.Duration, error) {
+	d, err := time.ParseDuration(t)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", t)
+	}
+	return d, nil
+}
@@ -0,0 +1,98 @@
+package vehicledata
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data/types/zone"
+)
+
+// A Sample is a timestamped value of one of the vehicle data types, e.g. VehicleSpeed or Door.
+type Sample struct {
+	// Time the value was observed
+	Time time.Time
+	// Vehicle data value, held by value (VehicleSpeed{}, not *VehicleSpeed)
+	Value interface{}
+	// Component that computed the value, e.g. "fusion"; empty for values read from the vehicle
+	Source string
+	// True if the value was found implausible, e.g. by package plausibility; kept in logs by package record
+	LowQuality bool
+}
+
+// Name returns the vehicle data type name of the sample value.
+func (s Sample) Name() string {
+	return TypeName(s.Value)
+}
+
+// Key returns the signal key of the sample value, see Key.
+func (s Sample) Key() string {
+	return Key(s.Value)
+}
+
+// TypeName returns the vehicle data type name of v, e.g. "VehicleSpeed". Pointers are dereferenced.
+func TypeName(v interface{}) string {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return ""
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
+// ZoneOf returns the zone of v and true if the type of v has a Zone field.
+func ZoneOf(v interface{}) (zone.Zone, bool) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return zone.Zone{}, false
+	}
+	f := rv.FieldByName("Zone")
+	if !f.IsValid() {
+		return zone.Zone{}, false
+	}
+	z, ok := f.Interface().(zone.Zone)
+	return z, ok
+}
+
+// Key identifies a signal: the type name, followed by the zone in brackets for zoned types with a zone set,
+// e.g. "VehicleSpeed" or "Door[front-left]".
+func Key(v interface{}) string {
+	name := TypeName(v)
+	if z, ok := ZoneOf(v); ok && len(z.Value) > 0 {
+		return name + "[" + z.String() + "]"
+	}
+	return name
+}
+
+// SplitKey splits a signal key into its type name and zone.
+func SplitKey(key string) (string, zone.Zone) {
+	n := len(key)
+	if n == 0 || key[n-1] != ']' {
+		return key, zone.Zone{}
+	}
+	for i := n - 1; i >= 0; i-- {
+		if key[i] == '[' {
+			return key[:i], zone.Parse(key[i+1 : n-1])
+		}
+	}
+	return key, zone.Zone{}
+}
+
+// NewFromKey returns a pointer to a new zero value for a signal key, with the zone set for zoned keys. It
+// returns false for an unknown type or a zone on a type without one.
+func NewFromKey(key string) (interface{}, bool) {
+	name, z := SplitKey(key)
+	v, ok := New(name)
+	if !ok {
+		return nil, false
+	}
+	if len(z.Value) > 0 {
+		f := reflect.ValueOf(v).Elem().FieldByName("Zone")
+		if !f.IsValid() {
+			return nil, false
+		}
+		f.Set(reflect.ValueOf(z))
+	}
+	return v, true
+}
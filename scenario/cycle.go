@@ -0,0 +1,122 @@
+package scenario
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Point is a speed waypoint of a drive cycle. Speed is interpolated linearly between points.
+type Point struct {
+	// Offset from the start of the cycle
+	T time.Duration
+	// Vehicle speed (Unit: kilometers per hour)
+	Speed float64
+}
+
+// A Cycle is a standard or custom speed-versus-time drive cycle.
+type Cycle struct {
+	// Cycle name as used by the "cycle" statement, e.g. "NEDC"
+	Name string
+	// Waypoints in time order, starting and ending at standstill
+	Points []Point
+}
+
+// Duration returns the length of the cycle.
+func (c Cycle) Duration() time.Duration {
+	if len(c.Points) == 0 {
+		return 0
+	}
+	return c.Points[len(c.Points)-1].T
+}
+
+var cycles = map[string]Cycle{}
+
+// Register makes a drive cycle available to scenarios by name. Names are case insensitive.
+func Register(c Cycle) {
+	cycles[strings.ToUpper(c.Name)] = c
+}
+
+// Lookup returns the registered drive cycle with the given name.
+func Lookup(name string) (Cycle, bool) {
+	c, ok := cycles[strings.ToUpper(name)]
+	return c, ok
+}
+
+// ParseCycle reads a drive cycle from "seconds,km/h" lines, such as the official second-by-second tables.
+// Blank lines, lines starting with '#' and a non-numeric header line are ignored.
+func ParseCycle(name string, r io.Reader) (Cycle, error) {
+	c := Cycle{Name: name}
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ';' || r == '\t' || r == ' ' })
+		if len(f) < 2 {
+			return Cycle{}, fmt.Errorf("scenario: cycle %s line %d: expected seconds and speed", name, n)
+		}
+		sec, err1 := strconv.ParseFloat(f[0], 64)
+		kmh, err2 := strconv.ParseFloat(f[1], 64)
+		if err1 != nil || err2 != nil {
+			if len(c.Points) == 0 {
+				continue
+			}
+			return Cycle{}, fmt.Errorf("scenario: cycle %s line %d: invalid number", name, n)
+		}
+		t := time.Duration(sec * float64(time.Second))
+		if len(c.Points) > 0 && t <= c.Points[len(c.Points)-1].T {
+			return Cycle{}, fmt.Errorf("scenario: cycle %s line %d: time does not increase", name, n)
+		}
+		c.Points = append(c.Points, Point{T: t, Speed: kmh})
+	}
+	if err := sc.Err(); err != nil {
+		return Cycle{}, err
+	}
+	if len(c.Points) == 0 {
+		return Cycle{}, fmt.Errorf("scenario: cycle %s is empty", name)
+	}
+	return c, nil
+}
+
+// table builds points from alternating seconds and km/h values, shifted by offset seconds.
+func table(offset float64, v ...float64) []Point {
+	p := make([]Point, 0, len(v)/2)
+	for i := 0; i+1 < len(v); i += 2 {
+		p = append(p, Point{T: time.Duration((offset + v[i]) * float64(time.Second)), Speed: v[i+1]})
+	}
+	return p
+}
+
+// join appends cycle segments, dropping the duplicated standstill point where segments meet.
+func join(segments ...[]Point) []Point {
+	var p []Point
+	for _, s := range segments {
+		if len(p) > 0 && len(s) > 0 && s[0].T == p[len(p)-1].T {
+			s = s[1:]
+		}
+		p = append(p, s...)
+	}
+	return p
+}
+
+// ece15 returns the ECE-15 urban driving cycle (195 s), shifted by offset seconds.
+func ece15(offset float64) []Point {
+	return table(offset,
+		0, 0, 11, 0, 15, 15, 23, 15, 25, 10, 28, 0, 49, 0, 54, 15, 56, 15, 61, 32, 85, 32, 93, 10, 96, 0,
+		117, 0, 122, 15, 124, 15, 133, 35, 135, 35, 143, 50, 155, 50, 163, 35, 176, 35, 185, 10, 188, 0, 195, 0)
+}
+
+// The official WLTP and FTP-75 traces are second-by-second tables that are not shipped with the package; load
+// them with ParseCycle and Register them under their names.
+func init() {
+	// NEDC: four ECE-15 urban cycles followed by the EUDC extra-urban cycle (1180 s), using the breakpoints of
+	// UN ECE R83 with gear change pauses merged into the adjacent segments.
+	Register(Cycle{Name: "NEDC", Points: join(ece15(0), ece15(195), ece15(390), ece15(585), table(780,
+		0, 0, 20, 0, 25, 15, 27, 15, 36, 35, 38, 35, 46, 50, 48, 50, 61, 70, 111, 70, 119, 50, 188, 50, 201, 70,
+		251, 70, 286, 100, 316, 100, 336, 120, 346, 120, 362, 80, 370, 50, 380, 0, 400, 0))})
+}
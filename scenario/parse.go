@@ -0,0 +1,227 @@
+package scenario
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseFile reads and compiles the scenario in the named file.
+func ParseFile(name string) (*Scenario, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Parse reads and compiles a scenario.
+func Parse(r io.Reader) (*Scenario, error) {
+	p := parser{s: &Scenario{Speed: []Point{{}}}}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		p.line++
+		if i := strings.IndexByte(sc.Text(), '#'); i >= 0 {
+			p.statement(strings.Fields(sc.Text()[:i]))
+		} else {
+			p.statement(strings.Fields(sc.Text()))
+		}
+		if p.err != nil {
+			return nil, p.err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(p.s.Actions, func(i, j int) bool { return p.s.Actions[i].At < p.s.Actions[j].At })
+	return p.s, nil
+}
+
+type parser struct {
+	s    *Scenario
+	line int
+	// scenario clock, the end of the speed profile
+	clock time.Duration
+	err   error
+}
+
+func (p *parser) errorf(format string, args ...interface{}) {
+	if p.err == nil {
+		p.err = fmt.Errorf("scenario: line %d: "+format, append([]interface{}{p.line}, args...)...)
+	}
+}
+
+func (p *parser) speed() float64 {
+	return p.s.Speed[len(p.s.Speed)-1].Speed
+}
+
+// hold extends the speed profile at the current speed.
+func (p *parser) hold(d time.Duration) {
+	p.clock += d
+	p.s.Speed = append(p.s.Speed, Point{T: p.clock, Speed: p.speed()})
+}
+
+func (p *parser) statement(f []string) {
+	if len(f) == 0 {
+		return
+	}
+	switch f[0] {
+	case "name":
+		p.s.Name = strings.Join(f[1:], " ")
+	case "idle":
+		if p.speed() != 0 {
+			p.errorf("idle while moving at %g km/h", p.speed())
+			return
+		}
+		p.hold(p.duration(f, 1))
+	case "wait", "cruise":
+		p.hold(p.duration(f, 1))
+	case "accelerate", "decelerate":
+		// accelerate to <speed> [km/h|mph] in <time>
+		if len(f) < 5 || f[1] != "to" {
+			p.errorf("expected %s to <speed> in <time>", f[0])
+			return
+		}
+		v, rest := p.kmh(f[2:])
+		if len(rest) != 2 || rest[0] != "in" {
+			p.errorf("expected %s to <speed> in <time>", f[0])
+			return
+		}
+		d := p.duration(rest, 1)
+		if d <= 0 {
+			p.errorf("%s needs a positive time", f[0])
+			return
+		}
+		if (f[0] == "accelerate") != (v >= p.speed()) {
+			p.errorf("cannot %s from %g to %g km/h", f[0], p.speed(), v)
+			return
+		}
+		p.clock += d
+		p.s.Speed = append(p.s.Speed, Point{T: p.clock, Speed: v})
+	case "cycle":
+		if len(f) != 2 {
+			p.errorf("expected cycle <name>")
+			return
+		}
+		c, ok := Lookup(f[1])
+		if !ok {
+			p.errorf("unknown drive cycle %s", f[1])
+			return
+		}
+		if p.speed() != 0 {
+			p.errorf("cycle %s must start at standstill", c.Name)
+			return
+		}
+		start := p.clock
+		for _, pt := range c.Points {
+			p.s.Speed = append(p.s.Speed, Point{T: start + pt.T, Speed: pt.Speed})
+		}
+		p.clock = start + c.Duration()
+	case "at":
+		if len(f) < 3 {
+			p.errorf("expected at <time> <statement>")
+			return
+		}
+		p.action(p.duration(f, 1), f[2:])
+	default:
+		p.action(p.clock, f)
+	}
+}
+
+// action parses a set, ramp or ignition statement that happens at offset at.
+func (p *parser) action(at time.Duration, f []string) {
+	a := Action{At: at, Line: p.line}
+	switch f[0] {
+	case "ignition":
+		if len(f) != 2 || (f[1] != "on" && f[1] != "off") {
+			p.errorf("expected ignition on|off")
+			return
+		}
+		on := f[1] == "on"
+		a.Ignition = &on
+	case "set":
+		// set Key.Field = value
+		if len(f) < 4 || f[2] != "=" {
+			p.errorf("expected set <signal>.<field> = <value>")
+			return
+		}
+		a.Key, a.Field = p.path(f[1])
+		a.Value = strings.Join(f[3:], " ")
+	case "ramp":
+		// ramp Key.Field to value over time
+		if len(f) != 6 || f[2] != "to" || f[4] != "over" {
+			p.errorf("expected ramp <signal>.<field> to <value> over <time>")
+			return
+		}
+		a.Key, a.Field = p.path(f[1])
+		to, err := strconv.ParseFloat(f[3], 64)
+		if err != nil {
+			p.errorf("invalid ramp target %q", f[3])
+			return
+		}
+		a.To = to
+		if a.Over = p.duration(f, 5); a.Over <= 0 {
+			p.errorf("ramp needs a positive time")
+			return
+		}
+	default:
+		p.errorf("unknown statement %q", f[0])
+		return
+	}
+	p.s.Actions = append(p.s.Actions, a)
+}
+
+// path splits "Door[front-left].Status" into the signal key and the field name.
+func (p *parser) path(s string) (string, string) {
+	i := strings.LastIndexByte(s, '.')
+	if i <= 0 || i < strings.LastIndexByte(s, ']') {
+		p.errorf("expected <signal>.<field>, got %q", s)
+		return "", ""
+	}
+	return s[:i], s[i+1:]
+}
+
+// duration parses f[i] as a Go duration or a number of seconds.
+func (p *parser) duration(f []string, i int) time.Duration {
+	if i >= len(f) {
+		p.errorf("missing time")
+		return 0
+	}
+	if sec, err := strconv.ParseFloat(f[i], 64); err == nil {
+		return time.Duration(sec * float64(time.Second))
+	}
+	d, err := time.ParseDuration(f[i])
+	if err != nil {
+		p.errorf("invalid time %q", f[i])
+	}
+	return d
+}
+
+// kmh parses a speed with an optional unit and returns it in km/h with the remaining fields.
+func (p *parser) kmh(f []string) (float64, []string) {
+	s := f[0]
+	unit := ""
+	if i := strings.IndexFunc(s, func(r rune) bool { return r != '.' && (r < '0' || r > '9') }); i > 0 {
+		s, unit = s[:i], s[i:]
+	} else if len(f) > 1 && (f[1] == "km/h" || f[1] == "kmh" || f[1] == "mph") {
+		unit, f = f[1], f[1:]
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		p.errorf("invalid speed %q", f[0])
+	}
+	switch unit {
+	case "", "km/h", "kmh":
+	case "mph":
+		v *= 1.609344
+	default:
+		p.errorf("unknown speed unit %q", unit)
+	}
+	return v, f[1:]
+}
@@ -0,0 +1,251 @@
+// Package scenario drives vehicle data signals over time from declarative scenarios, so behaviour such as door,
+// rain sensor, tire or alarm handling can be regression tested without writing code.
+//
+// A scenario is a text file with one statement per line. Timeline statements advance the scenario clock, other
+// statements happen at the current clock or, when prefixed with "at <time>", at an absolute time:
+//
+//	name door-warning
+//	ignition on
+//	idle 30s
+//	accelerate to 50 km/h in 10s
+//	cruise 2m
+//	at 120s set Door[front-left].Status = Open
+//	at 130s set RailSensor.RainIntensity = 7
+//	at 140s ramp Tire[rear-right].Pressure to 120 over 1m
+//	decelerate to 0 in 8s
+//	cycle NEDC
+//	ignition off
+//
+// Timeline statements are idle, wait (or cruise), accelerate/decelerate to <speed> in <time>, and cycle <name>.
+// Speeds are in km/h unless followed by "mph"; times are Go durations or plain seconds.
+//
+// NEDC is the only built-in cycle; the official WLTP and FTP-75 tables can be loaded with ParseCycle and added
+// with Register. VehicleSpeed saturates at 65.535 km/h, so faster parts of a profile, such as the extra-urban
+// phase of NEDC, are published at that speed, and Acceleration follows the published speed.
+package scenario
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+	"github.com/calvernaz/w3c-vehicle-data/types/vehicle-power"
+)
+
+// A Scenario is a compiled scenario: a speed profile and a list of timed signal changes.
+type Scenario struct {
+	// Scenario name, from the "name" statement
+	Name string
+	// Speed profile, always starting at standstill at offset 0
+	Speed []Point
+	// Signal changes ordered by time
+	Actions []Action
+}
+
+// An Action changes one field of one signal at a point in time.
+type Action struct {
+	// Offset from the start of the scenario
+	At time.Duration
+	// Signal key, e.g. "Door[front-left]"
+	Key string
+	// Field name, e.g. "Status"
+	Field string
+	// New value in text form for a set, e.g. "Open"
+	Value string
+	// Target value and duration for a ramp. Over is zero for a set.
+	To   float64
+	Over time.Duration
+	// Ignition switches the power mode and records IgnitionTime instead of setting a field.
+	Ignition *bool
+	// Source line, for error reporting
+	Line int
+}
+
+// Duration returns the time at which the last speed waypoint or action completes.
+func (s *Scenario) Duration() time.Duration {
+	var d time.Duration
+	if len(s.Speed) > 0 {
+		d = s.Speed[len(s.Speed)-1].T
+	}
+	for _, a := range s.Actions {
+		if a.At+a.Over > d {
+			d = a.At + a.Over
+		}
+	}
+	return d
+}
+
+// SpeedAt returns the profile speed at offset t, in kilometers per hour.
+func (s *Scenario) SpeedAt(t time.Duration) float64 {
+	p := s.Speed
+	if len(p) == 0 {
+		return 0
+	}
+	i := sort.Search(len(p), func(i int) bool { return p[i].T > t })
+	switch {
+	case i == 0:
+		return p[0].Speed
+	case i == len(p):
+		return p[len(p)-1].Speed
+	}
+	a, b := p[i-1], p[i]
+	return a.Speed + (b.Speed-a.Speed)*float64(t-a.T)/float64(b.T-a.T)
+}
+
+// Run plays the scenario in simulated time as fast as possible. The clock starts at start and advances by
+// step; emit is called with every signal whose value changed, VehicleSpeed and Acceleration included.
+func (s *Scenario) Run(start time.Time, step time.Duration, emit func(vehicledata.Sample)) error {
+	return s.run(start, step, emit, nil)
+}
+
+// Play plays the scenario in real time, sleeping between steps, until it ends or ctx is done.
+func (s *Scenario) Play(ctx context.Context, step time.Duration, emit func(vehicledata.Sample)) error {
+	return s.run(time.Now(), step, emit, func(d time.Duration) error {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-t.C:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
+
+// ramp is an action in progress.
+type ramp struct {
+	Action
+	from float64
+}
+
+func (s *Scenario) run(start time.Time, step time.Duration, emit func(vehicledata.Sample), wait func(time.Duration) error) error {
+	if step <= 0 {
+		return fmt.Errorf("scenario: step must be positive")
+	}
+	state := map[string]interface{}{}
+	last := map[string]interface{}{}
+	send := func(now time.Time, v interface{}) {
+		value := reflect.ValueOf(v).Elem().Interface()
+		key := vehicledata.Key(v)
+		if prev, ok := last[key]; ok && reflect.DeepEqual(prev, value) {
+			return
+		}
+		last[key] = value
+		emit(vehicledata.Sample{Time: now, Value: value})
+	}
+	signal := func(key string) (interface{}, error) {
+		if v, ok := state[key]; ok {
+			return v, nil
+		}
+		v, ok := vehicledata.NewFromKey(key)
+		if !ok {
+			return nil, fmt.Errorf("invalid signal %s", key)
+		}
+		state[key] = v
+		return v, nil
+	}
+
+	var ramps []ramp
+	next := 0
+	end := s.Duration()
+	var prevSpeed float64
+	var prevT time.Duration
+	for t := time.Duration(0); ; t += step {
+		if t > end {
+			t = end
+		}
+		now := start.Add(t)
+
+		speed, _ := signal("VehicleSpeed")
+		vehicledata.SetField(speed, "Speed", s.SpeedAt(t)*1000)
+		// the acceleration follows the published speed, which saturates
+		mh, _ := vehicledata.Field(speed, "Speed")
+		kmh := mh / 1000
+		send(now, speed)
+		if t > prevT {
+			accel, _ := signal("Acceleration")
+			vehicledata.SetField(accel, "X", (kmh-prevSpeed)/3.6*100/(t-prevT).Seconds())
+			send(now, accel)
+		}
+		prevSpeed, prevT = kmh, t
+
+		for ; next < len(s.Actions) && s.Actions[next].At <= t; next++ {
+			a := s.Actions[next]
+			if a.Ignition != nil {
+				if err := ignition(now, *a.Ignition, signal, send); err != nil {
+					return err
+				}
+				continue
+			}
+			v, err := signal(a.Key)
+			if err != nil {
+				return fmt.Errorf("scenario: line %d: %v", a.Line, err)
+			}
+			if a.Over > 0 {
+				from, ok := vehicledata.Field(v, a.Field)
+				if !ok {
+					return fmt.Errorf("scenario: line %d: %s.%s is not numeric", a.Line, a.Key, a.Field)
+				}
+				ramps = append(ramps, ramp{Action: a, from: from})
+				continue
+			}
+			if err := vehicledata.ParseField(v, a.Field, a.Value); err != nil {
+				return fmt.Errorf("scenario: line %d: %v", a.Line, err)
+			}
+			send(now, v)
+		}
+
+		active := ramps[:0]
+		for _, r := range ramps {
+			v, _ := signal(r.Key)
+			f := math.Min(1, float64(t-r.At)/float64(r.Over))
+			if err := vehicledata.SetField(v, r.Field, r.from+(r.To-r.from)*f); err != nil {
+				return fmt.Errorf("scenario: line %d: %v", r.Line, err)
+			}
+			send(now, v)
+			if f < 1 {
+				active = append(active, r)
+			}
+		}
+		ramps = active
+
+		if t >= end {
+			return nil
+		}
+		if wait != nil {
+			d := step
+			if t+d > end {
+				d = end - t
+			}
+			if err := wait(d); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ignition switches the vehicle power mode and records the switching time.
+func ignition(now time.Time, on bool, signal func(string) (interface{}, error), send func(time.Time, interface{})) error {
+	power, err := signal("VehiclePowerModeType")
+	if err != nil {
+		return err
+	}
+	it, err := signal("IgnitionTime")
+	if err != nil {
+		return err
+	}
+	if on {
+		power.(*vehicledata.VehiclePowerModeType).Value = vehicle_power.Running
+		it.(*vehicledata.IgnitionTime).IgnitionOnTime = now
+	} else {
+		power.(*vehicledata.VehiclePowerModeType).Value = vehicle_power.Off
+		it.(*vehicledata.IgnitionTime).IgnitionOffTime = now
+	}
+	send(now, power)
+	send(now, it)
+	return nil
+}
@@ -0,0 +1,435 @@
+// Package score computes usage based insurance driver scores. Every trip, from ignition on to ignition off as
+// reported by IgnitionTime, gets a score from 0 (worst) to 100 (best), and a rolling score combines the recent
+// trips weighted by distance.
+//
+// The score is the weighted mean of five sub-scores, each explained by the figures it was computed from:
+//
+//   - speeding: share of the driving time under a speed limit spent above it, weighted by severity
+//   - harshness: harsh braking, acceleration, cornering and lane change events per 100 km, weighted by
+//     severity; time on cruise control earns a credit
+//   - night: share of the distance driven in NightMode
+//   - seat belt: share of driving time with an occupied seat's belt unfastened
+//   - distraction: control interactions (ButtonEvent) while moving and panic stops, where harsh braking
+//     follows the first brake pedal press within a second, per hour of driving
+//
+// Driving events come from package driving. Speeding is off until a speed limit is set, in the driving
+// thresholds of Config.Driving or with Engine.Detector().SetSpeedLimit, and VehicleSpeed saturates at
+// 65.535 km/h, so a limit at or above that speed can never be exceeded. Driving time without a limit below
+// 65.535 km/h is therefore not judged for speeding, and a trip without any has a speeding sub-score of weight 0
+// that leaves the total unaffected.
+package score
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+	"github.com/calvernaz/w3c-vehicle-data/driving"
+	"github.com/calvernaz/w3c-vehicle-data/types/button-event"
+	"github.com/calvernaz/w3c-vehicle-data/types/occupant-status"
+)
+
+// Sub-score names.
+const (
+	Speeding    = "speeding"
+	Harshness   = "harshness"
+	Night       = "night"
+	SeatBelt    = "seat belt"
+	Distraction = "distraction"
+)
+
+// Weights are the weights of the sub-scores in the total. They need not sum to 1.
+type Weights struct {
+	Speeding, Harshness, Night, SeatBelt, Distraction float64
+}
+
+// Config configures an engine. Zero values select the defaults noted on each field.
+type Config struct {
+	// Sub-score weights (default 0.3, 0.3, 0.1, 0.15, 0.15)
+	Weights Weights
+	// Driving event detection
+	Driving driving.Config
+	// Severity weighted events per 100 km that halve the harshness score (default 20)
+	HarshScale float64
+	// Interactions and panic stops per hour that halve the distraction score (default 20)
+	DistractionScale float64
+	// Share of speeding time, weighted by severity, that zeroes the speeding score (default 0.2)
+	SpeedingScale float64
+	// Rates per distance and per time are computed over at least this distance and time, so a single event on
+	// a short trip does not dominate the score (defaults 10 km and 15 minutes)
+	MinDistance float64
+	MinDuration time.Duration
+	// Harshness points credited for driving the whole trip on cruise control (default 10)
+	CruiseCredit float64
+	// Zone of the driver seat, whose belt counts even without occupant information (default "front-left")
+	DriverSeat string
+	// Number of recent trips in the rolling score (default 20)
+	Window int
+	// Speed samples further apart than this are a gap that is not integrated (default 5 s)
+	MaxGap time.Duration
+}
+
+func (c *Config) defaults() {
+	if c.Weights == (Weights{}) {
+		c.Weights = Weights{Speeding: 0.3, Harshness: 0.3, Night: 0.1, SeatBelt: 0.15, Distraction: 0.15}
+	}
+	def := func(x *float64, d float64) {
+		if *x <= 0 {
+			*x = d
+		}
+	}
+	def(&c.HarshScale, 20)
+	def(&c.DistractionScale, 20)
+	def(&c.SpeedingScale, 0.2)
+	def(&c.CruiseCredit, 10)
+	def(&c.MinDistance, 10000)
+	if c.MinDuration <= 0 {
+		c.MinDuration = 15 * time.Minute
+	}
+	if c.DriverSeat == "" {
+		c.DriverSeat = "front-left"
+	}
+	if c.Window <= 0 {
+		c.Window = 20
+	}
+	if c.MaxGap <= 0 {
+		c.MaxGap = 5 * time.Second
+	}
+}
+
+// A Part is a sub-score with its explanation.
+type Part struct {
+	Name string
+	// Sub-score from 0 to 100 and its weight in the total
+	Score, Weight float64
+	// Figures the sub-score was computed from, e.g. "3 harsh events (1 high) in 12.4 km"
+	Explanation string
+}
+
+// A Score is a total score with its sub-scores.
+type Score struct {
+	Total float64
+	Parts []Part
+}
+
+// Part returns the sub-score of the given name.
+func (s Score) Part(name string) (Part, bool) {
+	for _, p := range s.Parts {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Part{}, false
+}
+
+// A Trip is a scored trip.
+type Trip struct {
+	Start, End time.Time
+	// Distance driven (Unit: meters) and time spent moving
+	Distance float64
+	Driving  time.Duration
+	Score    Score
+	// Driving events of the trip
+	Events []driving.Event
+}
+
+// figures are the measurements of a trip.
+type figures struct {
+	distance, nightDistance float64
+	driving                 time.Duration
+	unbelted, cruise        time.Duration
+	speeding                float64
+	harsh                   float64
+	high                    int
+	interactions, panic     int
+	// driving time under a speed limit that VehicleSpeed can exceed
+	limited time.Duration
+}
+
+// An Engine scores trips. It is not safe for concurrent use.
+type Engine struct {
+	cfg      Config
+	detector *driving.Detector
+
+	inTrip bool
+	// whether IgnitionTime samples delimit the trips
+	ignition bool
+	start    time.Time
+	fig      figures
+	events   []driving.Event
+
+	speed     float64
+	speedTime time.Time
+	night     bool
+	cruise    bool
+	// belt state of occupied seats by zone
+	belts      map[string]bool
+	brakeStart time.Time
+	braking    bool
+
+	trips []Trip
+}
+
+// NewEngine returns an engine.
+func NewEngine(cfg Config) *Engine {
+	cfg.defaults()
+	return &Engine{cfg: cfg, detector: driving.NewDetector(cfg.Driving), belts: map[string]bool{}}
+}
+
+// Detector returns the driving event detector, e.g. to set the speed limit.
+func (e *Engine) Detector() *driving.Detector {
+	return e.detector
+}
+
+// Update processes a sample and returns the trip it completes, if any. Before the first IgnitionTime a trip
+// starts with the first sample; after it, samples while the ignition is off only update the seat and night
+// state.
+func (e *Engine) Update(s vehicledata.Sample) []Trip {
+	t := s.Time
+	if v, ok := s.Value.(vehicledata.IgnitionTime); ok {
+		e.ignition = true
+		var out []Trip
+		if !v.IgnitionOffTime.IsZero() && !v.IgnitionOffTime.Before(v.IgnitionOnTime) {
+			if e.inTrip {
+				out = append(out, e.EndTrip(v.IgnitionOffTime))
+			}
+			return out
+		}
+		if !v.IgnitionOnTime.IsZero() {
+			if e.inTrip && v.IgnitionOnTime.After(e.start) {
+				out = append(out, e.EndTrip(v.IgnitionOnTime))
+			}
+			if !e.inTrip {
+				e.startTrip(v.IgnitionOnTime)
+			}
+		}
+		return out
+	}
+	if !e.inTrip && e.ignition {
+		switch v := s.Value.(type) {
+		case vehicledata.NightMode:
+			e.night = v.Mode
+		case vehicledata.Seat:
+			e.seat(v)
+		}
+		return nil
+	}
+	if !e.inTrip {
+		e.startTrip(t)
+	}
+	for _, ev := range e.detector.Update(s) {
+		e.event(ev)
+	}
+	switch v := s.Value.(type) {
+	case vehicledata.VehicleSpeed:
+		e.speedSample(t, float64(v.Speed)/3600)
+	case vehicledata.NightMode:
+		e.night = v.Mode
+	case vehicledata.CruiseControlStatus:
+		e.cruise = v.Status
+	case vehicledata.Seat:
+		e.seat(v)
+	case vehicledata.BrakeOperation:
+		if v.BrakePedalDepressed && !e.braking {
+			e.brakeStart = t
+		}
+		e.braking = v.BrakePedalDepressed
+	case vehicledata.ButtonEvent:
+		if e.speed >= 0.5 && v.State != button_event.Release && v.State != button_event.VoiceRecognize {
+			e.fig.interactions++
+		}
+	}
+	return nil
+}
+
+// seat tracks the belt state of an occupied seat.
+func (e *Engine) seat(v vehicledata.Seat) {
+	occupied := v.Occupant == occupant_status.Adult || v.Occupant == occupant_status.Child ||
+		v.Zone.String() == e.cfg.DriverSeat
+	e.belts[v.Zone.String()] = v.SeatBelt || !occupied
+}
+
+func (e *Engine) startTrip(t time.Time) {
+	e.inTrip, e.start = true, t
+	e.fig, e.events = figures{}, nil
+	e.speedTime = time.Time{}
+}
+
+func (e *Engine) speedSample(t time.Time, v float64) {
+	prev, prevTime := e.speed, e.speedTime
+	e.speed, e.speedTime = v, t
+	if prevTime.IsZero() {
+		return
+	}
+	dt := t.Sub(prevTime)
+	if dt <= 0 || dt > e.cfg.MaxGap {
+		return
+	}
+	d := (prev + v) / 2 * dt.Seconds()
+	e.fig.distance += d
+	if e.night {
+		e.fig.nightDistance += d
+	}
+	if prev < 0.5 && v < 0.5 {
+		return
+	}
+	e.fig.driving += dt
+	if limit := e.detector.SpeedLimit(); limit > 0 && limit < maxSpeed {
+		e.fig.limited += dt
+	}
+	for _, ok := range e.belts {
+		if !ok {
+			e.fig.unbelted += dt
+			break
+		}
+	}
+	if e.cruise {
+		e.fig.cruise += dt
+	}
+}
+
+// maxSpeed is the highest speed VehicleSpeed carries (Unit: meters per second).
+const maxSpeed = 65.535 / 3.6
+
+var severityWeight = map[driving.Severity]float64{driving.Low: 1, driving.Medium: 2, driving.High: 4}
+
+func (e *Engine) event(ev driving.Event) {
+	e.events = append(e.events, ev)
+	w := severityWeight[ev.Severity]
+	if ev.Severity == driving.High {
+		e.fig.high++
+	}
+	switch ev.Kind {
+	case driving.Speeding:
+		e.fig.speeding += ev.Duration().Seconds() * w
+	default:
+		e.fig.harsh += w
+	}
+	if ev.Kind == driving.HarshBraking && !e.brakeStart.IsZero() && ev.Start.Sub(e.brakeStart) < time.Second &&
+		!ev.Start.Before(e.brakeStart) {
+		e.fig.panic++
+	}
+}
+
+// EndTrip ends the current trip at time t, scores it and adds it to the rolling score.
+func (e *Engine) EndTrip(t time.Time) Trip {
+	tr := Trip{Start: e.start, End: t, Distance: e.fig.distance, Driving: e.fig.driving, Events: e.events}
+	tr.Score = e.score(e.fig)
+	e.inTrip = false
+	e.trips = append(e.trips, tr)
+	if len(e.trips) > e.cfg.Window {
+		e.trips = e.trips[len(e.trips)-e.cfg.Window:]
+	}
+	return tr
+}
+
+// Trips returns the trips of the rolling score, oldest first.
+func (e *Engine) Trips() []Trip {
+	return append([]Trip(nil), e.trips...)
+}
+
+// Rolling returns the rolling score: the sub-scores of the recent trips averaged by distance. Trips in which a
+// sub-score had weight 0 are left out of its mean.
+func (e *Engine) Rolling() Score {
+	if len(e.trips) == 0 {
+		return e.score(figures{})
+	}
+	sums, totals, counts := map[string]float64{}, map[string]float64{}, map[string]int{}
+	for _, tr := range e.trips {
+		w := math.Max(tr.Distance, 1)
+		for _, p := range tr.Score.Parts {
+			if p.Weight > 0 {
+				sums[p.Name] += p.Score * w
+				totals[p.Name] += w
+				counts[p.Name]++
+			}
+		}
+	}
+	var s Score
+	for _, p := range e.parts() {
+		if totals[p.Name] == 0 {
+			p.Score, p.Weight = 100, 0
+			p.Explanation = "not scored in any of the recent trips"
+		} else {
+			p.Score = sums[p.Name] / totals[p.Name]
+			p.Explanation = fmt.Sprintf("distance weighted mean of %d trips", counts[p.Name])
+		}
+		s.Parts = append(s.Parts, p)
+	}
+	s.Total = weighted(s.Parts)
+	return s
+}
+
+// parts returns the sub-scores with their weights and no score.
+func (e *Engine) parts() []Part {
+	w := e.cfg.Weights
+	return []Part{
+		{Name: Speeding, Weight: w.Speeding},
+		{Name: Harshness, Weight: w.Harshness},
+		{Name: Night, Weight: w.Night},
+		{Name: SeatBelt, Weight: w.SeatBelt},
+		{Name: Distraction, Weight: w.Distraction},
+	}
+}
+
+func (e *Engine) score(f figures) Score {
+	km := math.Max(f.distance, e.cfg.MinDistance) / 1000
+	hours := math.Max(f.driving.Hours(), e.cfg.MinDuration.Hours())
+	share := func(part, whole float64) float64 {
+		if whole <= 0 {
+			return 0
+		}
+		return part / whole
+	}
+	var s Score
+	for _, p := range e.parts() {
+		switch p.Name {
+		case Speeding:
+			if f.limited == 0 {
+				p.Score, p.Weight = 100, 0
+				p.Explanation = "no speed limit below 65.5 km/h set, speeding not scored"
+				break
+			}
+			x := share(f.speeding, f.limited.Seconds())
+			p.Score = 100 * math.Max(0, 1-x/e.cfg.SpeedingScale)
+			p.Explanation = fmt.Sprintf("%.1f%% of %.0f minutes under a speed limit speeding, severity weighted",
+				100*x, f.limited.Minutes())
+		case Harshness:
+			rate := share(f.harsh, km) * 100
+			credit := e.cfg.CruiseCredit * share(f.cruise.Seconds(), f.driving.Seconds())
+			p.Score = math.Min(100, 100*math.Pow(0.5, rate/e.cfg.HarshScale)+credit)
+			p.Explanation = fmt.Sprintf("%.1f weighted harsh events per 100 km (%d high) in %.1f km, %.0f point cruise control credit",
+				rate, f.high, f.distance/1000, credit)
+		case Night:
+			x := share(f.nightDistance, f.distance)
+			p.Score = 100 * (1 - x)
+			p.Explanation = fmt.Sprintf("%.1f%% of distance at night", 100*x)
+		case SeatBelt:
+			x := share(f.unbelted.Seconds(), f.driving.Seconds())
+			p.Score = 100 * (1 - x)
+			p.Explanation = fmt.Sprintf("%.1f%% of driving time with a seat belt unfastened", 100*x)
+		case Distraction:
+			rate := share(float64(f.interactions+f.panic), hours)
+			p.Score = 100 * math.Pow(0.5, rate/e.cfg.DistractionScale)
+			p.Explanation = fmt.Sprintf("%d interactions while moving and %d panic stops in %.0f minutes",
+				f.interactions, f.panic, f.driving.Minutes())
+		}
+		s.Parts = append(s.Parts, p)
+	}
+	s.Total = weighted(s.Parts)
+	return s
+}
+
+// weighted returns the weighted mean of the sub-scores.
+func weighted(parts []Part) float64 {
+	var sum, weights float64
+	for _, p := range parts {
+		sum += p.Score * p.Weight
+		weights += p.Weight
+	}
+	if weights == 0 {
+		return 0
+	}
+	return sum / weights
+}
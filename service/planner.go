@@ -0,0 +1,407 @@
+// Package service plans vehicle maintenance: it combines the service intervals of a maintenance schedule with
+// the condition reported by the maintenance interfaces (EngineOil, TransmissionOil, TransmissionClutch,
+// BrakeMaintenance, WasherFluid, Tire, BatteryStatus and the Diagnostic counters) into a prioritized list of
+// due and upcoming service items.
+//
+// The schedule is chosen by the Identification brand, model and year. Distance intervals run on the Odometer
+// and engine time intervals on Diagnostic.AccumulatedEngineRuntime. Completed work is recorded in a service
+// history, saved to a file, which restarts the intervals of the item:
+//
+//	p, err := service.Open("/var/lib/vehicle/service.json", service.Config{})
+//	...
+//	defer p.Close()
+//	for smp := range samples {
+//		p.Update(smp)
+//	}
+//	for _, e := range p.Plan(time.Now()) {
+//		...
+//	}
+//	p.Done("engine-oil", "", time.Now())
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+	"github.com/calvernaz/w3c-vehicle-data/internal/statefile"
+	"github.com/calvernaz/w3c-vehicle-data/rules"
+)
+
+// Status of a service item.
+type Status int
+
+const (
+	Upcoming Status = iota
+	Due
+)
+
+func (s Status) String() string {
+	if s == Due {
+		return "due"
+	}
+	return "upcoming"
+}
+
+// Config configures a planner. Zero values select the defaults noted on each field.
+type Config struct {
+	// Schedules by vehicle; the most specific match is used, DefaultSchedule if none matches
+	Schedules []Schedule
+	// Distance before a distance interval at which the item is upcoming (Unit: meters); default 1000 km
+	UpcomingDistance float64
+	// Time before a time interval at which the item is upcoming; default 30 days
+	UpcomingTime time.Duration
+	// Engine time before an engine time interval at which the item is upcoming; default 20 hours
+	UpcomingEngineTime time.Duration
+	// Time of odometer history needed to project the date of distance intervals; default 7 days
+	ProjectAfter time.Duration
+}
+
+func (c *Config) defaults() {
+	if c.UpcomingDistance == 0 {
+		c.UpcomingDistance = 1000e3
+	}
+	if c.UpcomingTime == 0 {
+		c.UpcomingTime = 30 * 24 * time.Hour
+	}
+	if c.UpcomingEngineTime == 0 {
+		c.UpcomingEngineTime = 20 * time.Hour
+	}
+	if c.ProjectAfter == 0 {
+		c.ProjectAfter = 7 * 24 * time.Hour
+	}
+}
+
+// A Record is a service item done.
+type Record struct {
+	Item string
+	// Zone serviced, empty for the whole vehicle
+	Zone string `json:",omitempty"`
+	Time time.Time
+	// Odometer reading (Unit: meters) and engine runtime when the work was done
+	Distance   float64
+	EngineTime time.Duration
+}
+
+// An Entry is a due or upcoming service item.
+type Entry struct {
+	Item        string
+	Description string
+	// Zone of a per-zone item
+	Zone     string
+	Status   Status
+	Severity rules.Severity
+	// What makes the item due or upcoming: "distance", "time", "engine time" or "condition"
+	Reason string
+	// Odometer reading at which the item is due (Unit: meters) and the distance left, negative once overdue;
+	// both zero for items without a distance interval
+	DueDistance       float64
+	RemainingDistance float64
+	// Date the item is due, projected from the average daily distance for distance intervals, and at the
+	// latest now once an interval is overdue; zero if unknown
+	DueDate time.Time
+}
+
+// state is the content of the state file.
+type state struct {
+	History []Record
+	// First odometer reading and its time, the start of the intervals without history and of the average daily
+	// distance
+	FirstDistance float64
+	FirstTime     time.Time
+}
+
+// A Planner plans service items. It is not safe for concurrent use.
+type Planner struct {
+	cfg      Config
+	path     string
+	schedule *compiled
+	// every schedule with its rules
+	schedules []*compiled
+	engine    *rules.Engine
+	// latest sample per signal key, replayed when the schedule changes
+	latest map[string]vehicledata.Sample
+
+	st         state
+	now        time.Time
+	distance   float64
+	engineTime time.Duration
+}
+
+type compiled struct {
+	Schedule
+	rules []*rules.Rule
+}
+
+// New returns a planner that is not backed by a state file. It fails if a condition of a schedule does not
+// compile.
+func New(cfg Config) (*Planner, error) {
+	cfg.defaults()
+	p := &Planner{cfg: cfg, latest: map[string]vehicledata.Sample{}}
+	for _, s := range append(append([]Schedule{}, cfg.Schedules...), DefaultSchedule) {
+		c := &compiled{Schedule: s}
+		for _, it := range s.Items {
+			for _, cond := range [][2]string{{"due", it.Due}, {"upcoming", it.Upcoming}} {
+				if cond[1] == "" {
+					continue
+				}
+				r, err := rules.Compile(it.Name + "/" + cond[0] + ": " + cond[1])
+				if err != nil {
+					return nil, fmt.Errorf("service: %s: %v", s.name(), err)
+				}
+				c.rules = append(c.rules, r)
+			}
+		}
+		p.schedules = append(p.schedules, c)
+	}
+	p.use(p.schedules[len(p.schedules)-1])
+	return p, nil
+}
+
+// Open returns a planner that saves its service history to the file at path, restoring it if the file exists.
+func Open(path string, cfg Config) (*Planner, error) {
+	p, err := New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	p.path = path
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &p.st); err != nil {
+		return nil, fmt.Errorf("service: state file %s: %v", path, err)
+	}
+	return p, nil
+}
+
+func (s *Schedule) name() string {
+	if s.Brand == "" && s.Model == "" {
+		return "default schedule"
+	}
+	return strings.TrimSpace(s.Brand + " " + s.Model)
+}
+
+// use switches to schedule c, replaying the latest samples into its conditions.
+func (p *Planner) use(c *compiled) {
+	p.schedule = c
+	p.engine = rules.NewEngine(c.rules)
+	for _, s := range p.latest {
+		p.engine.Update(s)
+	}
+}
+
+// Schedule returns the schedule in use.
+func (p *Planner) Schedule() Schedule {
+	return p.schedule.Schedule
+}
+
+// Update processes a sample.
+func (p *Planner) Update(s vehicledata.Sample) {
+	if s.Value == nil {
+		return
+	}
+	if s.Time.After(p.now) {
+		p.now = s.Time
+	}
+	switch v := s.Value.(type) {
+	case vehicledata.Identification:
+		p.identify(v)
+	case vehicledata.Odometer:
+		p.distance = float64(v.DistanceTotal)
+		if p.st.FirstTime.IsZero() {
+			p.st.FirstDistance, p.st.FirstTime = p.distance, s.Time
+		}
+	case vehicledata.Diagnostic:
+		p.engineTime = time.Duration(v.AccumulatedEngineRuntime) * time.Second
+	}
+	p.latest[s.Key()] = s
+	p.engine.Update(s)
+}
+
+func (p *Planner) identify(v vehicledata.Identification) {
+	var best *compiled
+	bestScore := -1
+	for _, c := range p.schedules {
+		if ok, score := c.matches(v.Brand, v.Model, v.Year); ok && score > bestScore {
+			best, bestScore = c, score
+		}
+	}
+	if best != p.schedule {
+		p.use(best)
+	}
+}
+
+// Done records that a service item was done at time t, restarting its intervals, and saves the history. zone
+// is the zone serviced for per-zone items, or empty.
+func (p *Planner) Done(item, zone string, t time.Time) error {
+	p.st.History = append(p.st.History, Record{
+		Item: item, Zone: zone, Time: t, Distance: p.distance, EngineTime: p.engineTime,
+	})
+	return p.Save()
+}
+
+// History returns the service history, oldest first.
+func (p *Planner) History() []Record {
+	return append([]Record(nil), p.st.History...)
+}
+
+// last returns the latest record of the item, or of its zone for per-zone items.
+func (p *Planner) last(item, zone string) (Record, bool) {
+	for i := len(p.st.History) - 1; i >= 0; i-- {
+		r := p.st.History[i]
+		if r.Item == item && (zone == "" || r.Zone == "" || r.Zone == zone) {
+			return r, true
+		}
+	}
+	return Record{}, false
+}
+
+// Plan returns the due and upcoming service items at time now: due items first, then by severity, then by due
+// date and remaining distance.
+func (p *Planner) Plan(now time.Time) []Entry {
+	conds := map[string]map[string]bool{}
+	for _, a := range p.engine.Active() {
+		if conds[a.Rule] == nil {
+			conds[a.Rule] = map[string]bool{}
+		}
+		conds[a.Rule][a.Zone] = true
+	}
+	var out []Entry
+	for _, it := range p.schedule.Items {
+		if it.PerZone {
+			// the zones with a condition, and the vehicle as a whole for the intervals
+			zones := map[string]bool{}
+			for z := range conds[it.Name+"/due"] {
+				zones[z] = true
+			}
+			for z := range conds[it.Name+"/upcoming"] {
+				zones[z] = true
+			}
+			for z := range zones {
+				if e, ok := p.entry(it, z, now, conds); ok {
+					out = append(out, e)
+				}
+			}
+			if len(zones) > 0 {
+				continue
+			}
+		}
+		if e, ok := p.entry(it, "", now, conds); ok {
+			out = append(out, e)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		switch {
+		case a.Status != b.Status:
+			return a.Status > b.Status
+		case a.Severity != b.Severity:
+			return a.Severity > b.Severity
+		case !a.DueDate.Equal(b.DueDate):
+			return !a.DueDate.IsZero() && (b.DueDate.IsZero() || a.DueDate.Before(b.DueDate))
+		case a.RemainingDistance != b.RemainingDistance:
+			return a.RemainingDistance < b.RemainingDistance
+		}
+		return a.Zone < b.Zone
+	})
+	return out
+}
+
+// entry evaluates an item for a zone, empty for the vehicle, and returns it if it is due or upcoming.
+func (p *Planner) entry(it Item, zone string, now time.Time, conds map[string]map[string]bool) (Entry, bool) {
+	e := Entry{Item: it.Name, Description: it.Description, Zone: zone, Severity: it.Severity, Status: -1}
+	set := func(st Status, reason string) {
+		if st > e.Status {
+			e.Status, e.Reason = st, reason
+		}
+	}
+	base, ok := p.last(it.Name, zone)
+	if !ok {
+		// no service recorded: intervals run from the first odometer reading
+		base = Record{Time: p.st.FirstTime, Distance: p.st.FirstDistance}
+	}
+	if it.Distance > 0 {
+		e.DueDistance = base.Distance + it.Distance
+		e.RemainingDistance = e.DueDistance - p.distance
+		switch {
+		case e.RemainingDistance <= 0:
+			set(Due, "distance")
+		case e.RemainingDistance <= p.cfg.UpcomingDistance:
+			set(Upcoming, "distance")
+		}
+		if rate := p.dailyDistance(now); rate > 0 {
+			e.DueDate = now.Add(time.Duration(math.Max(0, e.RemainingDistance) / rate * float64(24*time.Hour)))
+		}
+	}
+	if it.Time > 0 && !base.Time.IsZero() {
+		due := base.Time.Add(it.Time)
+		if e.DueDate.IsZero() || due.Before(e.DueDate) {
+			e.DueDate = due
+		}
+		switch {
+		case !now.Before(due):
+			set(Due, "time")
+		case due.Sub(now) <= p.cfg.UpcomingTime:
+			set(Upcoming, "time")
+		}
+	}
+	if it.EngineTime > 0 {
+		left := base.EngineTime + it.EngineTime - p.engineTime
+		switch {
+		case left <= 0:
+			set(Due, "engine time")
+		case left <= p.cfg.UpcomingEngineTime:
+			set(Upcoming, "engine time")
+		}
+	}
+	if p.condition(conds[it.Name+"/due"], zone) {
+		set(Due, "condition")
+	} else if p.condition(conds[it.Name+"/upcoming"], zone) {
+		set(Upcoming, "condition")
+	}
+	if e.Status == Due && e.Reason != "condition" && (e.DueDate.IsZero() || e.DueDate.After(now)) {
+		// an overdue interval is due now, whatever the other intervals project
+		e.DueDate = now
+	}
+	return e, e.Status >= 0
+}
+
+// condition reports whether a condition holds for the zone, or for any zone if zone is empty.
+func (p *Planner) condition(zones map[string]bool, zone string) bool {
+	if zone == "" {
+		return len(zones) > 0
+	}
+	return zones[zone]
+}
+
+// dailyDistance returns the average distance per day, or 0 before ProjectAfter of odometer history.
+func (p *Planner) dailyDistance(now time.Time) float64 {
+	span := now.Sub(p.st.FirstTime)
+	if p.st.FirstTime.IsZero() || span < p.cfg.ProjectAfter {
+		return 0
+	}
+	return (p.distance - p.st.FirstDistance) / (float64(span) / float64(24*time.Hour))
+}
+
+// Save writes the service history to the state file. The file is replaced atomically, so a power loss leaves
+// either the old or the new history.
+func (p *Planner) Save() error {
+	if p.path == "" {
+		return nil
+	}
+	return statefile.Save(p.path, p.st)
+}
+
+// Close saves the service history.
+func (p *Planner) Close() error {
+	return p.Save()
+}
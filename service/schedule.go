@@ -0,0 +1,86 @@
+package service
+
+import (
+	"strings"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data/rules"
+)
+
+// An Item is a service task of a maintenance schedule. It is due once any of its intervals has elapsed since
+// it was last done, or while its Due condition holds.
+type Item struct {
+	// Name identifying the item in the service history, e.g. "engine-oil"
+	Name        string
+	Description string
+	Severity    rules.Severity
+	// Intervals since the item was last done; zero disables an interval. Distance is in meters.
+	Distance   float64
+	Time       time.Duration
+	EngineTime time.Duration
+	// Conditions in the expression language of package rules, e.g. "EngineOil.Change"; empty disables one
+	Due, Upcoming string
+	// Report the item per zone of the wildcard in its conditions rather than once for the vehicle
+	PerZone bool
+}
+
+// A Schedule is the maintenance schedule of the vehicles matching its brand, model and model years. Empty
+// fields match any vehicle.
+type Schedule struct {
+	Brand, Model     string
+	MinYear, MaxYear uint16
+	Items            []Item
+}
+
+const year = 365 * 24 * time.Hour
+
+// DefaultSchedule applies to vehicles without a more specific schedule.
+var DefaultSchedule = Schedule{Items: []Item{
+	{Name: "engine-oil", Description: "Change engine oil and filter", Severity: rules.Warning,
+		Distance: 15000e3, Time: year,
+		Due: "EngineOil.Change", Upcoming: "EngineOil.LifeRemaining > 0 && EngineOil.LifeRemaining <= 15"},
+	{Name: "brake-pads", Description: "Replace brake pads", Severity: rules.Critical,
+		Due: "BrakeMaintenance[*].BrakesWorn", Upcoming: "BrakeMaintenance[*].PadWear >= 65", PerZone: true},
+	{Name: "brake-fluid", Description: "Replace brake fluid", Severity: rules.Critical,
+		Time: 2 * year, Due: "BrakeMaintenance[*].FluidLevelLow"},
+	{Name: "transmission-oil", Description: "Change transmission oil", Severity: rules.Warning,
+		Distance: 60000e3, Due: "TransmissionOil.Wear >= 90", Upcoming: "TransmissionOil.Wear >= 75"},
+	{Name: "clutch", Description: "Replace clutch", Severity: rules.Warning,
+		Due: "TransmissionClutch.Wear >= 90", Upcoming: "TransmissionClutch.Wear >= 75"},
+	{Name: "tires", Description: "Check tire pressure", Severity: rules.Warning,
+		Due: "Tire[*].PressureLow || Tire[*].Pressure > 0 && Tire[*].Pressure < 180", PerZone: true},
+	{Name: "tire-rotation", Description: "Rotate tires", Severity: rules.Info, Distance: 10000e3},
+	{Name: "battery", Description: "Test or replace the 12V battery", Severity: rules.Warning,
+		Due:      "BatteryStatus.ChargeLevel > 0 && BatteryStatus.ChargeLevel < 40",
+		Upcoming: "BatteryStatus.ChargeLevel > 0 && BatteryStatus.ChargeLevel < 60"},
+	{Name: "washer-fluid", Description: "Refill washer fluid", Severity: rules.Info, Due: "WasherFluid.LevelLow"},
+	{Name: "air-filter", Description: "Replace engine air filter", Severity: rules.Info,
+		Distance: 30000e3, Time: 2 * year},
+	{Name: "cabin-filter", Description: "Replace cabin air filter", Severity: rules.Info, Time: year},
+	{Name: "diagnostics", Description: "Read trouble codes: malfunction indicator on", Severity: rules.Warning,
+		Due: "Diagnostic.DistanceWithMILOn > 0 || Diagnostic.TimeRunMILOn > 0"},
+}}
+
+// matches reports whether the schedule applies to the vehicle and how specific it is.
+func (s *Schedule) matches(brand, model string, year uint16) (bool, int) {
+	score := 0
+	if s.Brand != "" {
+		if !strings.EqualFold(s.Brand, brand) {
+			return false, 0
+		}
+		score += 4
+	}
+	if s.Model != "" {
+		if !strings.EqualFold(s.Model, model) {
+			return false, 0
+		}
+		score += 2
+	}
+	if s.MinYear != 0 || s.MaxYear != 0 {
+		if year == 0 || s.MinYear != 0 && year < s.MinYear || s.MaxYear != 0 && year > s.MaxYear {
+			return false, 0
+		}
+		score++
+	}
+	return true, score
+}
@@ -0,0 +1,160 @@
+// Package solar computes the position of the sun and the times of sunrise and sunset with the NOAA solar
+// calculator equations. It needs no network access; the results are accurate to about a minute for dates
+// between 1800 and 2100 and latitudes within the polar circles.
+//
+// Example:
+//
+//	elevation, azimuth := solar.Position(time.Now(), solar.Location{Latitude: 52.52, Longitude: 13.40})
+//	rise, set, ok := solar.Sunrise(time.Now(), solar.Location{Latitude: 52.52, Longitude: 13.40})
+package solar
+
+import (
+	"math"
+	"time"
+)
+
+// A Location is a point on earth.
+type Location struct {
+	// Latitude, north positive (Unit: degrees)
+	Latitude float64
+	// Longitude, east positive (Unit: degrees)
+	Longitude float64
+}
+
+// Elevation angles of the sun center that mark the usual boundaries of the day (Unit: degrees).
+const (
+	// Sunrise and sunset, including refraction and the radius of the solar disc
+	Horizon = -0.833
+	// Civil twilight
+	Civil = -6.0
+	// Nautical twilight
+	Nautical = -12.0
+	// Astronomical twilight
+	Astronomical = -18.0
+)
+
+// Position returns the elevation of the sun above the horizon, corrected for atmospheric refraction, and its
+// azimuth clockwise from north at time t (Unit: degrees).
+func Position(t time.Time, loc Location) (elevation, azimuth float64) {
+	decl, eot := sun(t)
+	utc := t.UTC()
+	minutes := float64(utc.Hour()*60+utc.Minute()) + (float64(utc.Second())+float64(utc.Nanosecond())/1e9)/60
+	tst := math.Mod(minutes+eot+4*loc.Longitude, 1440)
+	if tst < 0 {
+		tst += 1440
+	}
+	ha := tst/4 - 180
+
+	lat := rad(loc.Latitude)
+	cosZenith := math.Sin(lat)*math.Sin(decl) + math.Cos(lat)*math.Cos(decl)*math.Cos(rad(ha))
+	zenith := math.Acos(clamp(cosZenith))
+	elevation = 90 - deg(zenith)
+
+	sinZenith := math.Sin(zenith)
+	if math.Abs(math.Cos(lat)*sinZenith) > 1e-9 {
+		a := deg(math.Acos(clamp((math.Sin(lat)*math.Cos(zenith) - math.Sin(decl)) / (math.Cos(lat) * sinZenith))))
+		if ha > 0 {
+			azimuth = math.Mod(a+180, 360)
+		} else {
+			azimuth = math.Mod(540-a, 360)
+		}
+	}
+	return elevation + refraction(elevation), azimuth
+}
+
+// Sunrise returns the times of sunrise and sunset around solar noon of the UTC day of t; far from Greenwich
+// they may fall on the neighbouring UTC date. It returns false during polar day and polar night.
+func Sunrise(t time.Time, loc Location) (rise, set time.Time, ok bool) {
+	return Crossing(t, loc, Horizon)
+}
+
+// Crossing returns the times around solar noon of the UTC day of t when the sun center rises above and sets below elevation,
+// e.g. Civil for the start and end of civil twilight. It returns false if the sun does not cross elevation
+// that day.
+func Crossing(t time.Time, loc Location, elevation float64) (rise, set time.Time, ok bool) {
+	u := t.UTC()
+	day := time.Date(u.Year(), u.Month(), u.Day(), 0, 0, 0, 0, time.UTC)
+	at := func(minutes float64) time.Time {
+		return day.Add(time.Duration(minutes * float64(time.Minute)))
+	}
+	// solve at solar noon, then refine each event with the sun at its own time
+	_, eot := sun(at(720 - 4*loc.Longitude))
+	noon := 720 - 4*loc.Longitude - eot
+	event := func(sign float64) (float64, bool) {
+		m := noon
+		for i := 0; i < 3; i++ {
+			decl, eot := sun(at(m))
+			ha, ok := hourAngle(loc.Latitude, decl, elevation)
+			if !ok {
+				return 0, false
+			}
+			m = 720 - 4*(loc.Longitude+sign*ha) - eot
+		}
+		return m, true
+	}
+	r, ok1 := event(1)
+	s, ok2 := event(-1)
+	if !ok1 || !ok2 {
+		return time.Time{}, time.Time{}, false
+	}
+	return at(r), at(s), true
+}
+
+// hourAngle returns the hour angle at which the sun center is at elevation (Unit: degrees).
+func hourAngle(latitude, decl, elevation float64) (float64, bool) {
+	lat := rad(latitude)
+	c := (math.Sin(rad(elevation)) - math.Sin(lat)*math.Sin(decl)) / (math.Cos(lat) * math.Cos(decl))
+	if c < -1 || c > 1 {
+		return 0, false
+	}
+	return deg(math.Acos(c)), true
+}
+
+// sun returns the declination of the sun (Unit: radians) and the equation of time (Unit: minutes) at t.
+func sun(t time.Time) (decl, eot float64) {
+	jd := float64(t.UnixNano())/1e9/86400 + 2440587.5
+	T := (jd - 2451545) / 36525
+
+	l0 := math.Mod(280.46646+T*(36000.76983+T*0.0003032), 360)
+	m := 357.52911 + T*(35999.05029-0.0001537*T)
+	e := 0.016708634 - T*(0.000042037+0.0000001267*T)
+	c := math.Sin(rad(m))*(1.914602-T*(0.004817+0.000014*T)) +
+		math.Sin(rad(2*m))*(0.019993-0.000101*T) +
+		math.Sin(rad(3*m))*0.000289
+	omega := 125.04 - 1934.136*T
+	lambda := l0 + c - 0.00569 - 0.00478*math.Sin(rad(omega))
+
+	eps0 := 23 + (26+(21.448-T*(46.815+T*(0.00059-T*0.001813)))/60)/60
+	eps := rad(eps0 + 0.00256*math.Cos(rad(omega)))
+	decl = math.Asin(math.Sin(eps) * math.Sin(rad(lambda)))
+
+	y := math.Pow(math.Tan(eps/2), 2)
+	L0, M := rad(l0), rad(m)
+	eot = 4 * deg(y*math.Sin(2*L0)-2*e*math.Sin(M)+4*e*y*math.Sin(M)*math.Cos(2*L0)-
+		0.5*y*y*math.Sin(4*L0)-1.25*e*e*math.Sin(2*M))
+	return decl, eot
+}
+
+// refraction returns the approximate atmospheric refraction at a geometric elevation (Unit: degrees).
+func refraction(elevation float64) float64 {
+	if elevation > 85 {
+		return 0
+	}
+	te := math.Tan(rad(elevation))
+	var arcsec float64
+	switch {
+	case elevation > 5:
+		arcsec = 58.1/te - 0.07/math.Pow(te, 3) + 0.000086/math.Pow(te, 5)
+	case elevation > -0.575:
+		arcsec = 1735 + elevation*(-518.2+elevation*(103.4+elevation*(-12.79+elevation*0.711)))
+	default:
+		arcsec = -20.772 / te
+	}
+	return arcsec / 3600
+}
+
+func rad(d float64) float64 { return d * math.Pi / 180 }
+
+func deg(r float64) float64 { return r * 180 / math.Pi }
+
+func clamp(x float64) float64 { return math.Max(-1, math.Min(1, x)) }
@@ -0,0 +1,260 @@
+// Package subscription delivers vehicle data samples to subscribers through filters modeled on the VISS
+// subscription filters: minimum interval, on-change, per-field deadband, range entry/exit triggers and
+// curve logging. Filters work on any vehicle data type through its numeric, boolean and enumerated fields.
+package subscription
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+)
+
+// Options selects the filters of a subscription. A sample is delivered when it passes every filter that is
+// set; the zero value delivers every sample.
+type Options struct {
+	// Minimum time between two deliveries of the same signal
+	MinInterval time.Duration
+	// Deliver a sample only if its value differs from the last delivered value
+	OnChange bool
+	// Deliver a sample only if at least one of the listed fields moved by at least its threshold since the last
+	// delivery, e.g. {"Speed": 100} for EngineSpeed
+	Deadband map[string]float64
+	// Deliver a sample only when it enters or leaves one of the ranges
+	Ranges []Range
+	// Curve logging: deliver only the samples needed to reconstruct the signal by linear interpolation
+	CurveLog *CurveLog
+}
+
+// A Range is a value range of one field, e.g. Fuel.Level below 10. Use math.Inf for an open end.
+type Range struct {
+	Field string
+	// Range bounds, exclusive
+	Low, High float64
+	// Edge selects the transitions that deliver a sample
+	Edge Edge
+}
+
+// Edge selects range transitions.
+type Edge int
+
+const (
+	// Both entry and exit deliver a sample
+	EntryExit Edge = iota
+	// Only entry into the range delivers a sample
+	Entry
+	// Only exit from the range delivers a sample
+	Exit
+)
+
+// Below returns the range of field values below x.
+func Below(field string, x float64) Range {
+	return Range{Field: field, Low: math.Inf(-1), High: x}
+}
+
+// Above returns the range of field values above x.
+func Above(field string, x float64) Range {
+	return Range{Field: field, Low: x, High: math.Inf(1)}
+}
+
+// ParseRange parses a range such as "Level < 10", "Fuel.Level > 90" or "10 < Pressure < 180". A type name
+// before the field is ignored.
+func ParseRange(s string) (Range, error) {
+	f := strings.Fields(strings.NewReplacer("<", " < ", ">", " > ").Replace(s))
+	field := func(name string) string {
+		if i := strings.LastIndexByte(name, '.'); i >= 0 {
+			return name[i+1:]
+		}
+		return name
+	}
+	num := func(x string) (float64, error) { return strconv.ParseFloat(x, 64) }
+	switch {
+	case len(f) == 3 && f[1] == "<":
+		x, err := num(f[2])
+		return Below(field(f[0]), x), err
+	case len(f) == 3 && f[1] == ">":
+		x, err := num(f[2])
+		return Above(field(f[0]), x), err
+	case len(f) == 5 && f[1] == "<" && f[3] == "<":
+		lo, err1 := num(f[0])
+		hi, err2 := num(f[4])
+		if err1 != nil || err2 != nil {
+			return Range{}, fmt.Errorf("subscription: invalid range %q", s)
+		}
+		return Range{Field: field(f[2]), Low: lo, High: hi}, nil
+	}
+	return Range{}, fmt.Errorf("subscription: invalid range %q", s)
+}
+
+func (r Range) contains(v interface{}) (bool, bool) {
+	x, ok := vehicledata.Field(v, r.Field)
+	return ok && x > r.Low && x < r.High, ok
+}
+
+// CurveLog configures curve logging. Buffered samples are dropped as long as linear interpolation between the
+// last delivered sample and the newest one reproduces each of them within MaxError on every numeric field.
+// Delivery is delayed by one sample: a sample is delivered when the next one breaks the line.
+type CurveLog struct {
+	// Maximum interpolation error per field; fields without an entry use Default
+	MaxError map[string]float64
+	Default  float64
+	// Maximum number of buffered samples before one is delivered regardless (default 100)
+	BufferSize int
+}
+
+func (c *CurveLog) maxError(field string) float64 {
+	if e, ok := c.MaxError[field]; ok {
+		return e
+	}
+	return c.Default
+}
+
+// A Filter applies subscription options to a stream of samples, keeping state per signal. It is not safe for
+// concurrent use.
+type Filter struct {
+	opts    Options
+	signals map[string]*state
+}
+
+type state struct {
+	// last delivered sample
+	last    vehicledata.Sample
+	hasLast bool
+	inRange []bool
+	// samples since the last delivery, for curve logging
+	pending []vehicledata.Sample
+}
+
+// NewFilter returns a filter for the given options.
+func NewFilter(opts Options) *Filter {
+	return &Filter{opts: opts, signals: map[string]*state{}}
+}
+
+// Apply returns the samples to deliver after s arrives: none, s itself, or with curve logging an earlier
+// buffered sample.
+func (f *Filter) Apply(s vehicledata.Sample) []vehicledata.Sample {
+	key := s.Key()
+	st, ok := f.signals[key]
+	if !ok {
+		st = &state{inRange: make([]bool, len(f.opts.Ranges))}
+		f.signals[key] = st
+	}
+	inRange, pass := f.ranges(st, s)
+	if !pass {
+		st.inRange = inRange
+		return nil
+	}
+	// a range transition dropped by the filters below stays pending until a sample is delivered
+	if st.hasLast {
+		if f.opts.MinInterval > 0 && s.Time.Sub(st.last.Time) < f.opts.MinInterval {
+			return nil
+		}
+		if f.opts.OnChange && reflect.DeepEqual(st.last.Value, s.Value) {
+			return nil
+		}
+		if len(f.opts.Deadband) > 0 && !f.moved(st.last.Value, s.Value) {
+			return nil
+		}
+	}
+	st.inRange = inRange
+	if f.opts.CurveLog != nil {
+		return f.curve(st, s)
+	}
+	st.last, st.hasLast = s, true
+	return []vehicledata.Sample{s}
+}
+
+// Flush returns the samples held back by curve logging, so the last value of every signal is delivered.
+func (f *Filter) Flush() []vehicledata.Sample {
+	var out []vehicledata.Sample
+	for _, st := range f.signals {
+		if n := len(st.pending); n > 0 {
+			out = append(out, st.pending[n-1])
+			st.last, st.hasLast = st.pending[n-1], true
+			st.pending = nil
+		}
+	}
+	return out
+}
+
+// ranges returns the range states after the sample and reports whether the sample passes the range filter.
+// The caller commits the states, so a transition is not lost when a later filter drops the sample.
+func (f *Filter) ranges(st *state, s vehicledata.Sample) ([]bool, bool) {
+	if len(f.opts.Ranges) == 0 {
+		return st.inRange, true
+	}
+	next := append([]bool(nil), st.inRange...)
+	pass := false
+	for i, r := range f.opts.Ranges {
+		in, ok := r.contains(s.Value)
+		if !ok {
+			continue
+		}
+		if in != st.inRange[i] {
+			switch {
+			case r.Edge == EntryExit, r.Edge == Entry && in, r.Edge == Exit && !in:
+				pass = true
+			}
+		}
+		next[i] = in
+	}
+	return next, pass
+}
+
+// moved reports whether a deadband field changed by at least its threshold.
+func (f *Filter) moved(from, to interface{}) bool {
+	for field, threshold := range f.opts.Deadband {
+		a, ok1 := vehicledata.Field(from, field)
+		b, ok2 := vehicledata.Field(to, field)
+		if ok1 && ok2 && math.Abs(b-a) >= threshold {
+			return true
+		}
+	}
+	return false
+}
+
+// curve implements curve logging for one signal.
+func (f *Filter) curve(st *state, s vehicledata.Sample) []vehicledata.Sample {
+	c := f.opts.CurveLog
+	if !st.hasLast {
+		st.last, st.hasLast = s, true
+		return []vehicledata.Sample{s}
+	}
+	size := c.BufferSize
+	if size <= 0 {
+		size = 100
+	}
+	if len(st.pending) == 0 || (len(st.pending) < size && f.fits(st.last, st.pending, s)) {
+		st.pending = append(st.pending, s)
+		return nil
+	}
+	// the newest buffered sample is the last point still on a line from the anchor
+	st.last = st.pending[len(st.pending)-1]
+	st.pending = append(st.pending[:0], s)
+	return []vehicledata.Sample{st.last}
+}
+
+// fits reports whether every pending sample lies within the allowed error of the line from anchor to s.
+func (f *Filter) fits(anchor vehicledata.Sample, pending []vehicledata.Sample, s vehicledata.Sample) bool {
+	span := s.Time.Sub(anchor.Time)
+	fields := vehicledata.Fields(s.Value)
+	for _, p := range pending {
+		frac := 0.0
+		if span > 0 {
+			frac = float64(p.Time.Sub(anchor.Time)) / float64(span)
+		}
+		for _, name := range fields {
+			a, _ := vehicledata.Field(anchor.Value, name)
+			b, _ := vehicledata.Field(s.Value, name)
+			x, _ := vehicledata.Field(p.Value, name)
+			if math.Abs(a+(b-a)*frac-x) > f.opts.CurveLog.maxError(name) {
+				return false
+			}
+		}
+	}
+	return true
+}
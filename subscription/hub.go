@@ -0,0 +1,91 @@
+package subscription
+
+import (
+	"sync"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+)
+
+// A Hub fans published samples out to filtered subscriptions. It is safe for concurrent use; subscriber
+// callbacks run on the publishing goroutine, outside the hub lock.
+type Hub struct {
+	mu   sync.Mutex
+	subs map[*sub]struct{}
+}
+
+type sub struct {
+	// type name or signal key, empty for every signal
+	match  string
+	filter *Filter
+	fn     func(vehicledata.Sample)
+}
+
+// A delivery is the samples for one subscriber.
+type delivery struct {
+	fn      func(vehicledata.Sample)
+	samples []vehicledata.Sample
+}
+
+// deliver calls the subscribers; the hub lock must not be held.
+func deliver(out []delivery) {
+	for _, d := range out {
+		for _, smp := range d.samples {
+			d.fn(smp)
+		}
+	}
+}
+
+// NewHub returns a hub without subscriptions.
+func NewHub() *Hub {
+	return &Hub{subs: map[*sub]struct{}{}}
+}
+
+// Subscribe calls fn with the samples that match and pass the options. match is a type name such as "Tire"
+// for every zone, a signal key such as "Tire[rear-right]", or empty for every signal. The returned function
+// cancels the subscription, first delivering the samples its filter holds back, see Filter.Flush.
+func (h *Hub) Subscribe(match string, opts Options, fn func(vehicledata.Sample)) func() {
+	s := &sub{match: match, filter: NewFilter(opts), fn: fn}
+	h.mu.Lock()
+	h.subs[s] = struct{}{}
+	h.mu.Unlock()
+	return func() {
+		h.mu.Lock()
+		var held []vehicledata.Sample
+		if _, ok := h.subs[s]; ok {
+			held = s.filter.Flush()
+			delete(h.subs, s)
+		}
+		h.mu.Unlock()
+		deliver([]delivery{{s.fn, held}})
+	}
+}
+
+// Flush delivers the samples held back by the filters of all subscriptions, so every subscriber has the last
+// value of every signal it was sent, e.g. before shutting down.
+func (h *Hub) Flush() {
+	var out []delivery
+	h.mu.Lock()
+	for sb := range h.subs {
+		if d := sb.filter.Flush(); len(d) > 0 {
+			out = append(out, delivery{sb.fn, d})
+		}
+	}
+	h.mu.Unlock()
+	deliver(out)
+}
+
+// Publish delivers a sample to the matching subscriptions.
+func (h *Hub) Publish(s vehicledata.Sample) {
+	var out []delivery
+	h.mu.Lock()
+	for sb := range h.subs {
+		if sb.match != "" && sb.match != s.Name() && sb.match != s.Key() {
+			continue
+		}
+		if d := sb.filter.Apply(s); len(d) > 0 {
+			out = append(out, delivery{sb.fn, d})
+		}
+	}
+	h.mu.Unlock()
+	deliver(out)
+}
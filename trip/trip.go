@@ -0,0 +1,317 @@
+// Package trip is a trip computer: it integrates VehicleSpeed and Fuel samples into resettable trip meters and
+// reports them as Trip values.
+//
+// The standard meters are two driver resettable meters, a meter reset on refuelling and a meter reset on every
+// ignition. The meters are saved to a state file so they survive power cycles:
+//
+//	c, err := trip.Open("/var/lib/vehicle/trip.json", trip.Config{})
+//	...
+//	defer c.Close()
+//	for smp := range samples {
+//		for _, t := range c.Update(smp) {
+//			// t.Value is a vehicledata.Trip
+//		}
+//	}
+//	c.Reset(trip.MeterA)
+package trip
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/calvernaz/w3c-vehicle-data"
+	"github.com/calvernaz/w3c-vehicle-data/internal/statefile"
+	"github.com/calvernaz/w3c-vehicle-data/types/vehicle-power"
+)
+
+// Standard meter names.
+const (
+	MeterA = "A"
+	MeterB = "B"
+	// Reset when a refuel is detected
+	SinceRefuel = "refuel"
+	// Reset when the ignition is switched on
+	SinceIgnition = "ignition"
+)
+
+// Config configures a trip computer. Zero values select the defaults noted on each field.
+type Config struct {
+	// Meters in the order of Trip.Meters (default MeterA, MeterB, SinceRefuel, SinceIgnition)
+	Meters []string
+	// Speed samples further apart than this are a gap: the distance and time between them are not counted
+	// (default 5 s)
+	MaxGap time.Duration
+	// Rise of Fuel.Level that counts as refuelling (Unit: percentage, default 5)
+	RefuelLevel uint16
+	// Minimum time between Trip samples (default 1 s)
+	EmitInterval time.Duration
+	// Minimum time between automatic saves of the state file (default 1 minute)
+	SaveInterval time.Duration
+}
+
+func (c *Config) defaults() {
+	if len(c.Meters) == 0 {
+		c.Meters = []string{MeterA, MeterB, SinceRefuel, SinceIgnition}
+	}
+	if c.MaxGap <= 0 {
+		c.MaxGap = 5 * time.Second
+	}
+	if c.RefuelLevel == 0 {
+		c.RefuelLevel = 5
+	}
+	if c.EmitInterval <= 0 {
+		c.EmitInterval = time.Second
+	}
+	if c.SaveInterval <= 0 {
+		c.SaveInterval = time.Minute
+	}
+}
+
+// A Meter is the raw state of one trip meter.
+type Meter struct {
+	// Distance travelled (Unit: meters)
+	Distance float64
+	// Time spent driving, excluding gaps and ignition off
+	Time time.Duration
+	// Fuel consumed (Unit: milliliters)
+	Fuel float64
+	// Time of the last reset
+	Since time.Time
+}
+
+// Trip returns the meter as a Trip value.
+func (m Meter) Trip() vehicledata.Trip {
+	t := vehicledata.Trip{Distance: uint64(m.Distance)}
+	if m.Time > 0 {
+		t.AverageSpeed = uint16(math.Min(m.Distance/m.Time.Seconds()*3.6, math.MaxUint16))
+	}
+	if m.Distance > 0 {
+		t.FuelConsumption = uint16(math.Min(m.Fuel/m.Distance*100000, math.MaxUint16))
+	}
+	return t
+}
+
+// A Computer maintains trip meters. It is not safe for concurrent use.
+type Computer struct {
+	cfg    Config
+	path   string
+	meters map[string]*Meter
+
+	// last speed sample
+	speed     float64
+	speedTime time.Time
+	// fuel inputs
+	consumed     uint64
+	haveConsumed bool
+	counter      bool
+	instant      float64
+	level        uint16
+	haveLevel    bool
+	power        vehicle_power.VehiclePowerMode
+
+	now      time.Time
+	lastEmit time.Time
+	lastSave time.Time
+	// meters changed since the last Trip sample and since the last save
+	changed, unsaved bool
+	err              error
+}
+
+// state is the content of the state file.
+type state struct {
+	Meters map[string]*Meter
+	// fuel level at the last save, for refuel detection across power cycles
+	Level uint16 `json:",omitempty"`
+}
+
+// New returns a trip computer that is not backed by a state file.
+func New(cfg Config) *Computer {
+	cfg.defaults()
+	c := &Computer{cfg: cfg, meters: map[string]*Meter{}}
+	for _, name := range cfg.Meters {
+		c.meters[name] = &Meter{}
+	}
+	return c
+}
+
+// Open returns a trip computer that saves its meters to the state file at path, restoring them if the file
+// exists. Meters in the file that are not configured are dropped.
+func Open(path string, cfg Config) (*Computer, error) {
+	c := New(cfg)
+	c.path = path
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var st state
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, fmt.Errorf("trip: state file %s: %v", path, err)
+	}
+	for name, m := range st.Meters {
+		if _, ok := c.meters[name]; ok && m != nil {
+			c.meters[name] = m
+		}
+	}
+	if st.Level > 0 {
+		c.level, c.haveLevel = st.Level, true
+	}
+	return c, nil
+}
+
+// Meter returns the state of a meter.
+func (c *Computer) Meter(name string) (Meter, bool) {
+	m, ok := c.meters[name]
+	if !ok {
+		return Meter{}, false
+	}
+	return *m, true
+}
+
+// Trip returns the meters as a Trip value: the first meter at the top level and all meters in Meters, in the
+// configured order.
+func (c *Computer) Trip() vehicledata.Trip {
+	var t vehicledata.Trip
+	for i, name := range c.cfg.Meters {
+		mt := c.meters[name].Trip()
+		if i == 0 {
+			t = mt
+		}
+		t.Meters = append(t.Meters, mt)
+	}
+	return t
+}
+
+// Reset sets a meter to zero. It returns false for an unknown meter.
+func (c *Computer) Reset(name string) bool {
+	m, ok := c.meters[name]
+	if !ok {
+		return false
+	}
+	*m = Meter{Since: c.now}
+	c.changed, c.unsaved = true, true
+	c.lastEmit = time.Time{}
+	return true
+}
+
+// Update processes a sample. It returns a Trip sample at most every EmitInterval while the meters change, and
+// immediately after a reset.
+func (c *Computer) Update(s vehicledata.Sample) []vehicledata.Sample {
+	if s.Time.After(c.now) {
+		c.now = s.Time
+	}
+	switch v := s.Value.(type) {
+	case vehicledata.VehicleSpeed:
+		c.speedSample(s.Time, float64(v.Speed)/3600)
+	case vehicledata.Fuel:
+		c.fuelSample(v)
+	case vehicledata.VehiclePowerModeType:
+		c.powerSample(v.Value)
+	default:
+		return nil
+	}
+	if c.path != "" && c.unsaved && c.now.Sub(c.lastSave) >= c.cfg.SaveInterval {
+		c.err = c.Save()
+	}
+	if !c.changed || (!c.lastEmit.IsZero() && c.now.Sub(c.lastEmit) < c.cfg.EmitInterval) {
+		return nil
+	}
+	c.lastEmit, c.changed = c.now, false
+	return []vehicledata.Sample{{Time: c.now, Value: c.Trip()}}
+}
+
+// speedSample integrates the distance since the previous speed sample; v is in meters per second.
+func (c *Computer) speedSample(t time.Time, v float64) {
+	prev, prevTime := c.speed, c.speedTime
+	c.speed, c.speedTime = v, t
+	if prevTime.IsZero() || c.power != 0 && c.power != vehicle_power.Running {
+		return
+	}
+	dt := t.Sub(prevTime)
+	if dt <= 0 || dt > c.cfg.MaxGap {
+		return
+	}
+	d := (prev + v) / 2 * dt.Seconds()
+	var fuel float64
+	if !c.counter {
+		fuel = c.instant * d / 100000
+	}
+	for _, m := range c.meters {
+		m.Distance += d
+		m.Time += dt
+		m.Fuel += fuel
+	}
+	c.changed, c.unsaved = true, true
+}
+
+func (c *Computer) fuelSample(v vehicledata.Fuel) {
+	c.instant = float64(v.InstantConsumption)
+	if v.FuelConsumedSinceRestart > 0 {
+		c.counter = true
+	}
+	if c.counter {
+		// the counter restarts from zero with the engine
+		if c.haveConsumed && v.FuelConsumedSinceRestart >= c.consumed {
+			used := float64(v.FuelConsumedSinceRestart - c.consumed)
+			for _, m := range c.meters {
+				m.Fuel += used
+			}
+			if used > 0 {
+				c.changed, c.unsaved = true, true
+			}
+		}
+		c.consumed, c.haveConsumed = v.FuelConsumedSinceRestart, true
+	}
+	if v.Level > 0 {
+		if c.haveLevel && v.Level >= c.level+c.cfg.RefuelLevel {
+			c.Reset(SinceRefuel)
+		}
+		if !c.haveLevel || v.Level < c.level || v.Level >= c.level+c.cfg.RefuelLevel {
+			// follow the level down, but keep the low point while it creeps up from sensor noise
+			c.level, c.haveLevel = v.Level, true
+		}
+	}
+}
+
+func (c *Computer) powerSample(p vehicle_power.VehiclePowerMode) {
+	if p == vehicle_power.Running && c.power != vehicle_power.Running {
+		c.Reset(SinceIgnition)
+		c.speedTime = time.Time{}
+	}
+	if p != vehicle_power.Running && c.power == vehicle_power.Running && c.path != "" {
+		c.err = c.Save()
+	}
+	c.power = p
+}
+
+// Err returns the error of the last automatic save, if any.
+func (c *Computer) Err() error {
+	return c.err
+}
+
+// Save writes the meters to the state file. The file is replaced atomically, so a power loss leaves either
+// the old or the new state.
+func (c *Computer) Save() error {
+	if c.path == "" {
+		return nil
+	}
+	st := state{Meters: c.meters}
+	if c.haveLevel {
+		st.Level = c.level
+	}
+	if err := statefile.Save(c.path, st); err != nil {
+		return err
+	}
+	c.lastSave, c.unsaved = c.now, false
+	return nil
+}
+
+// Close saves the meters.
+func (c *Computer) Close() error {
+	return c.Save()
+}
@@ -1,5 +1,6 @@
 package zone
 
+import "strings"
 
 //The Zone interface contains the constants that represent physical zones and logical zones
 type ZoneType int
@@ -22,3 +23,38 @@ type Zone struct {
 	Driver ZoneType
 }
 
+// String returns the physical zones joined by a dash in lower case, e.g. "front-left".
+// An empty zone is returned as "".
+func (z Zone) String() string {
+	return strings.ToLower(strings.Join(z.Value, "-"))
+}
+
+// Parse builds a Zone from its String form, e.g. "front-left" or "Rear-Right".
+func Parse(s string) Zone {
+	var z Zone
+	for _, v := range strings.Split(s, "-") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		z.Value = append(z.Value, strings.ToUpper(v[:1])+strings.ToLower(v[1:]))
+	}
+	return z
+}
+
+// Match reports whether z contains every physical zone of other. An empty other matches any zone.
+func (z Zone) Match(other Zone) bool {
+	for _, o := range other.Value {
+		found := false
+		for _, v := range z.Value {
+			if strings.EqualFold(v, o) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -2,6 +2,7 @@ package vehicledata
 
 import "time"
 import (
+	"github.com/calvernaz/w3c-vehicle-data/types/vehicle-power"
 	"github.com/calvernaz/w3c-vehicle-data/types/zone"
 )
 
@@ -108,7 +109,7 @@ type EngineSpeed struct {
 // The VehiclePowerModeType interface represents position of the ignition switch.
 type VehiclePowerModeType struct {
 	// Position of the ignition switch
-	Value vehicle_type.VehicleType
+	Value vehicle_power.VehiclePowerMode
 }
 
 // The PowertrainTorque interface represents powertrain torque.
@@ -317,9 +318,9 @@ type NightMode struct {
 // The Odometer interface provides information about the distance that the vehicle has traveled
 type Odometer struct {
 	// The distance traveled by vehicle since start (Unit: meters).
-	distanceSinceStart uint64
+	DistanceSinceStart uint64
 	// The total distance traveled by the vehicle (Unit: meters).
-	distanceTotal uint64
+	DistanceTotal uint64
 }
 
 // The TransmissionOil interface provides information about the state of a vehicles transmission-gear oil.